@@ -0,0 +1,416 @@
+// Package backup reads and writes the portable backup archives of gostore
+// stores. An archive is a tar stream, optionally gzipped, holding a manifest
+// followed by the native backup of the store
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// Format version of the archives written by Write
+const Format = 1
+
+// Stores which write backups, raw backups of these stores can be restored
+// without a manifest
+const (
+	StoreBadger = "badger"
+	StoreBolt   = "bolt"
+)
+
+const kindArchive = "archive"
+
+// boltMagic marks the meta pages at the start of a bolt file
+const boltMagic = 0xED0CDAED
+
+// maxBadgerListSize bounds the size of the first entry list of a badger
+// backup when detecting it
+const maxBadgerListSize = 1 << 31
+
+const (
+	// ManifestName name of the manifest entry of an archive
+	ManifestName = "manifest.json"
+	// DataName name of the entry holding the native backup of the store
+	DataName = "data"
+)
+
+// ErrUnknownFormat returned when a backup is neither an archive nor a raw
+// badger backup or bolt file
+var ErrUnknownFormat = errors.New("unknown backup format")
+
+// ErrNoManifest returned when an archive does not start with a manifest
+var ErrNoManifest = errors.New("backup archive has no manifest")
+
+// ErrNoData returned when an archive has no data entry
+var ErrNoData = errors.New("backup archive has no data")
+
+// ErrChecksumMismatch returned when the data of an archive does not match
+// the checksum of its manifest
+var ErrChecksumMismatch = errors.New("backup data does not match its checksum")
+
+// ErrChainGap returned when backups do not form a chain of a full backup
+// followed by incrementals, each continuing from the version of the previous
+var ErrChainGap = errors.New("backups do not form a chain")
+
+// ErrIncrementalNotSupported returned when a store can only write full backups
+var ErrIncrementalNotSupported = errors.New("incremental backups are not supported by this store")
+
+// Options configures a backup
+type Options struct {
+	// Since only backs up entries newer than this version, 0 is a full backup
+	Since uint64
+	// Gzip compresses the archive
+	Gzip bool
+}
+
+// IndexConfig the index a store was opened with, GeoField is the field geo
+// queries search when the index is geo capable
+type IndexConfig struct {
+	Type     string          `json:"type,omitempty"`
+	Mapping  json.RawMessage `json:"mapping,omitempty"`
+	GeoField string          `json:"geoField,omitempty"`
+}
+
+// NewIndexConfig records an index type and mapping, a nil mapping is omitted
+func NewIndexConfig(index string, indexMapping mapping.IndexMapping) (*IndexConfig, error) {
+	config := &IndexConfig{Type: index}
+	if indexMapping != nil {
+		dat, err := json.Marshal(indexMapping)
+		if err != nil {
+			return nil, err
+		}
+		config.Mapping = dat
+	}
+	return config, nil
+}
+
+// Link identifies a backup in a chain
+type Link struct {
+	Since    uint64    `json:"since"`
+	Version  uint64    `json:"version"`
+	Checksum string    `json:"checksum"`
+	Created  time.Time `json:"created"`
+}
+
+// Manifest describes the data of an archive. Version is the watermark of the
+// backup, passing it as Since to the next backup continues from this one.
+// Chain lists the backups an incremental backup builds on, base first
+type Manifest struct {
+	Format   int          `json:"format"`
+	Store    string       `json:"store"`
+	Since    uint64       `json:"since"`
+	Version  uint64       `json:"version"`
+	Tables   []string     `json:"tables"`
+	Index    *IndexConfig `json:"index,omitempty"`
+	Chain    []Link       `json:"chain,omitempty"`
+	Size     int64        `json:"size"`
+	Checksum string       `json:"checksum"`
+	Created  time.Time    `json:"created"`
+}
+
+// Link returns the link of the backup in a chain
+func (m *Manifest) Link() Link {
+	return Link{Since: m.Since, Version: m.Version, Checksum: m.Checksum, Created: m.Created}
+}
+
+// CheckChain checks backups, in the order they will be restored, start with a
+// full backup and each incremental continues from the version of the backup
+// before it. An incremental which records its chain must follow the last
+// backup of that chain
+func CheckChain(manifests []*Manifest) error {
+	if len(manifests) == 0 {
+		return fmt.Errorf("%w: no backups", ErrChainGap)
+	}
+	if manifests[0].Since != 0 {
+		return fmt.Errorf("%w: first backup is incremental since version %d", ErrChainGap, manifests[0].Since)
+	}
+	for i := 1; i < len(manifests); i++ {
+		prev, m := manifests[i-1], manifests[i]
+		if m.Store != prev.Store {
+			return fmt.Errorf("%w: backup %d is a %s backup, not %s", ErrChainGap, i, m.Store, prev.Store)
+		}
+		if m.Since == 0 {
+			return fmt.Errorf("%w: backup %d is a full backup", ErrChainGap, i)
+		}
+		if m.Since > prev.Version+1 {
+			return fmt.Errorf("%w: backup %d is since version %d but backup %d ends at version %d", ErrChainGap, i, m.Since, i-1, prev.Version)
+		}
+		if m.Version < prev.Version {
+			return fmt.Errorf("%w: backup %d is older than backup %d", ErrChainGap, i, i-1)
+		}
+		if n := len(m.Chain); n > 0 && m.Chain[n-1].Checksum != prev.Checksum {
+			return fmt.Errorf("%w: backup %d does not continue from backup %d", ErrChainGap, i, i-1)
+		}
+	}
+	return nil
+}
+
+// Write writes an archive of the data written by backup to w. The data is
+// spooled to a temporary file first so the manifest can hold its checksum,
+// backup returns the version watermark of the data
+func Write(w io.Writer, m *Manifest, gz bool, backup func(w io.Writer) (uint64, error)) error {
+	f, err := os.CreateTemp("", "gostore-backup-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	cw := &countWriter{w: io.MultiWriter(f, h)}
+	version, err := backup(cw)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	m.Format = Format
+	m.Version = version
+	m.Size = cw.n
+	m.Checksum = hex.EncodeToString(h.Sum(nil))
+	if m.Created.IsZero() {
+		m.Created = time.Now().UTC()
+	}
+	if m.Tables == nil {
+		m.Tables = []string{}
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	if err := writeEntry(tw, ManifestName, int64(len(manifest)), m.Created, bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+	if err := writeEntry(tw, DataName, m.Size, m.Created, f); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if zw != nil {
+		return zw.Close()
+	}
+	return nil
+}
+
+// WriteFile writes an archive to path through a temporary file in the same
+// directory, so path only ever holds a complete archive
+func WriteFile(path string, write func(w io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Extract reads a backup, gzipped or not, and calls fn with its manifest and
+// data. Archives are checked against their manifest once fn returns, data fn
+// did not read is read and discarded. Raw badger backups and bolt files have
+// no manifest, fn gets one with only the store set and their data is not
+// verified. A nil fn only verifies the backup
+func Extract(r io.Reader, fn func(m *Manifest, data io.Reader) error) (*Manifest, error) {
+	br, closer, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	kind, err := detect(br)
+	if err != nil {
+		return nil, err
+	}
+	if kind != kindArchive {
+		m := &Manifest{Store: kind, Tables: []string{}}
+		if fn != nil {
+			return m, fn(m, br)
+		}
+		return m, nil
+	}
+	tr, m, err := readManifest(br)
+	if err != nil {
+		return m, err
+	}
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != DataName) {
+		return m, ErrNoData
+	} else if err != nil {
+		return m, err
+	}
+	h := sha256.New()
+	data := &countReader{r: io.TeeReader(tr, h)}
+	if fn != nil {
+		if err := fn(m, data); err != nil {
+			return m, err
+		}
+	}
+	if _, err := io.Copy(io.Discard, data); err != nil {
+		return m, err
+	}
+	if data.n != m.Size || hex.EncodeToString(h.Sum(nil)) != m.Checksum {
+		return m, ErrChecksumMismatch
+	}
+	return m, nil
+}
+
+// ReadManifest reads the manifest of a backup without reading its data, see
+// Extract for the manifest of raw backups
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	br, closer, err := decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+	kind, err := detect(br)
+	if err != nil {
+		return nil, err
+	}
+	if kind != kindArchive {
+		return &Manifest{Store: kind, Tables: []string{}}, nil
+	}
+	_, m, err := readManifest(br)
+	return m, err
+}
+
+// ReadManifestFile reads the manifest of the backup at path
+func ReadManifestFile(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadManifest(f)
+}
+
+// decompress returns a reader of the gunzipped data of a gzipped backup,
+// closer releases the gzip reader
+func decompress(r io.Reader) (*bufio.Reader, io.Closer, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bufio.NewReader(zr), zr, nil
+	}
+	return br, io.NopCloser(br), nil
+}
+
+// detect peeks at the start of a backup and returns kindArchive or the store
+// of a raw backup. A bolt file starts with a meta page holding the bolt magic
+// and a badger backup with the size of a protobuf list of entries
+func detect(br *bufio.Reader) (string, error) {
+	head, _ := br.Peek(512)
+	if len(head) >= 262 && string(head[257:262]) == "ustar" {
+		return kindArchive, nil
+	}
+	if len(head) >= 20 && binary.LittleEndian.Uint32(head[16:20]) == boltMagic {
+		return StoreBolt, nil
+	}
+	if len(head) >= 9 && head[8] == 0x0a {
+		if size := binary.LittleEndian.Uint64(head[:8]); size > 0 && size < maxBadgerListSize {
+			return StoreBadger, nil
+		}
+	}
+	return "", ErrUnknownFormat
+}
+
+// readManifest reads the manifest of an archive leaving the tar reader at
+// the data entry
+func readManifest(r io.Reader) (*tar.Reader, *Manifest, error) {
+	tr := tar.NewReader(r)
+	hdr, err := tr.Next()
+	if err == io.EOF || (err == nil && hdr.Name != ManifestName) {
+		return nil, nil, ErrNoManifest
+	} else if err != nil {
+		return nil, nil, err
+	}
+	var m Manifest
+	if err := json.NewDecoder(tr).Decode(&m); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if m.Format > Format {
+		return nil, &m, fmt.Errorf("backup format %d is newer than %d", m.Format, Format)
+	}
+	return tr, &m, nil
+}
+
+// VerifyFile checks the data of the archive at path against its manifest
+func VerifyFile(path string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Extract(f, nil)
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, modTime time.Time, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0600, Size: size, ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,98 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeArchive(t *testing.T, gz bool, data string) []byte {
+	var buf bytes.Buffer
+	m := &Manifest{Store: "test", Since: 3, Tables: []string{"users"}}
+	err := Write(&buf, m, gz, func(w io.Writer) (uint64, error) {
+		_, err := io.WriteString(w, data)
+		return 7, err
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(7), m.Version)
+	assert.Equal(t, int64(len(data)), m.Size)
+	return buf.Bytes()
+}
+
+func TestWriteExtract(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		archive := writeArchive(t, gz, "some backup data")
+		if gz {
+			assert.Equal(t, []byte{0x1f, 0x8b}, archive[:2])
+		}
+		var data []byte
+		m, err := Extract(bytes.NewReader(archive), func(m *Manifest, r io.Reader) error {
+			var err error
+			data, err = io.ReadAll(r)
+			return err
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, "some backup data", string(data))
+		assert.Equal(t, Format, m.Format)
+		assert.Equal(t, "test", m.Store)
+		assert.Equal(t, uint64(3), m.Since)
+		assert.Equal(t, uint64(7), m.Version)
+		assert.Equal(t, []string{"users"}, m.Tables)
+		assert.False(t, m.Created.IsZero())
+	}
+}
+
+func TestExtractChecksumMismatch(t *testing.T) {
+	archive := writeArchive(t, false, "some backup data")
+	i := bytes.Index(archive, []byte("some backup data"))
+	archive[i] = 'S'
+	_, err := Extract(bytes.NewReader(archive), nil)
+	assert.Equal(t, ErrChecksumMismatch, err)
+
+	_, err = Extract(bytes.NewReader([]byte("not an archive")), nil)
+	assert.Equal(t, ErrUnknownFormat, err)
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "store.tar.gz")
+	err := WriteFile(path, func(w io.Writer) error {
+		_, err := w.Write(writeArchive(t, true, "data"))
+		return err
+	})
+	assert.Nil(t, err)
+	m, err := VerifyFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), m.Size)
+
+	// a failed write leaves neither the archive nor a temporary file
+	failed := filepath.Join(dir, "failed.tar")
+	err = WriteFile(failed, func(w io.Writer) error { return os.ErrClosed })
+	assert.Equal(t, os.ErrClosed, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestCheckChain(t *testing.T) {
+	base := &Manifest{Store: "badger", Version: 10, Checksum: "base"}
+	incr := &Manifest{Store: "badger", Since: 10, Version: 20, Checksum: "incr", Chain: []Link{base.Link()}}
+	next := &Manifest{Store: "badger", Since: 21, Version: 30, Checksum: "next", Chain: []Link{base.Link(), incr.Link()}}
+	assert.Nil(t, CheckChain([]*Manifest{base}))
+	assert.Nil(t, CheckChain([]*Manifest{base, incr, next}))
+
+	for _, chain := range [][]*Manifest{
+		nil,
+		{incr},
+		{base, next},
+		{base, next, incr},
+		{base, base},
+		{base, {Store: "bolt", Since: 10, Version: 20}},
+		{base, {Store: "badger", Since: 5, Version: 20, Chain: []Link{{Checksum: "other"}}}},
+	} {
+		assert.ErrorIs(t, CheckChain(chain), ErrChainGap)
+	}
+}
@@ -0,0 +1,376 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/mgutz/logxi/v1"
+)
+
+var logger = log.New("gostore-contrib.backup")
+
+// archiveTimeLayout the time in the name of scheduled archives
+const archiveTimeLayout = "20060102T150405.000Z"
+
+// Store writes backup archives, BadgerStore and BoltStore are stores
+type Store interface {
+	Backup(w io.Writer, opts Options) (*Manifest, error)
+}
+
+// Destination keeps the archives of a Scheduler
+type Destination interface {
+	// Put stores the archive written by write as name, nothing is stored when
+	// write fails
+	Put(name string, write func(w io.Writer) error) error
+	Open(name string) (io.ReadCloser, error)
+	List() ([]string, error)
+	Remove(name string) error
+}
+
+// LocalDestination keeps archives in a directory
+type LocalDestination struct {
+	Dir string
+}
+
+// NewLocalDestination keeps archives in dir, creating it if needed
+func NewLocalDestination(dir string) (*LocalDestination, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalDestination{Dir: dir}, nil
+}
+
+func (d *LocalDestination) Put(name string, write func(w io.Writer) error) error {
+	return WriteFile(filepath.Join(d.Dir, name), write)
+}
+
+func (d *LocalDestination) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(d.Dir, name))
+}
+
+// List returns the archives in the directory, archives being written are left out
+func (d *LocalDestination) List() ([]string, error) {
+	entries, err := os.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && !strings.Contains(entry.Name(), ".tmp-") {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d *LocalDestination) Remove(name string) error {
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+// Retention keeps the newest archive of each of the last Hourly hours, Daily
+// days and Weekly weeks with archives. The newest archive is always kept and
+// a zero Retention keeps every archive
+type Retention struct {
+	Hourly int `json:"hourly"`
+	Daily  int `json:"daily"`
+	Weekly int `json:"weekly"`
+}
+
+// keep returns the archives to keep of archives sorted newest first
+func (r Retention) keep(archives []archive) map[string]bool {
+	keep := map[string]bool{}
+	if len(archives) == 0 {
+		return keep
+	}
+	if r == (Retention{}) {
+		for _, a := range archives {
+			keep[a.name] = true
+		}
+		return keep
+	}
+	keep[archives[0].name] = true
+	rules := []struct {
+		n   int
+		key func(t time.Time) string
+	}{
+		{r.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{r.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{r.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		}},
+	}
+	for _, rule := range rules {
+		seen := map[string]bool{}
+		for _, a := range archives {
+			if len(seen) == rule.n {
+				break
+			}
+			if k := rule.key(a.created); !seen[k] {
+				seen[k] = true
+				keep[a.name] = true
+			}
+		}
+	}
+	return keep
+}
+
+type archive struct {
+	name    string
+	created time.Time
+}
+
+// Status of a Scheduler, the last success and failure are zero until a
+// backup succeeded or failed. RetentionError is the error of the last
+// backup's pruning, the backup itself is still a success
+type Status struct {
+	Running        bool      `json:"running"`
+	Next           time.Time `json:"next"`
+	LastSuccess    time.Time `json:"lastSuccess"`
+	LastArchive    string    `json:"lastArchive"`
+	LastVersion    uint64    `json:"lastVersion"`
+	LastFailure    time.Time `json:"lastFailure"`
+	LastError      string    `json:"lastError"`
+	Successes      int       `json:"successes"`
+	Failures       int       `json:"failures"`
+	RetentionError string    `json:"retentionError,omitempty"`
+}
+
+// SchedulerOption configures a Scheduler
+type SchedulerOption func(*Scheduler)
+
+// SchedulerRetention removes archives which are not kept by r after every backup
+func SchedulerRetention(r Retention) SchedulerOption {
+	return func(s *Scheduler) {
+		s.retention = r
+	}
+}
+
+// SchedulerGzip gzips the archives
+func SchedulerGzip() SchedulerOption {
+	return func(s *Scheduler) {
+		s.gzip = true
+	}
+}
+
+// SchedulerName sets the name archives start with, "backup" by default.
+// Stores sharing a destination need different names
+func SchedulerName(name string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.name = name
+	}
+}
+
+// Scheduler takes full backups of a store on a schedule. Each archive is
+// verified after it is written and archives are then pruned by the retention
+// rules, only archives named by the scheduler are pruned
+type Scheduler struct {
+	store     Store
+	dest      Destination
+	schedule  Schedule
+	retention Retention
+	gzip      bool
+	name      string
+	now       func() time.Time
+
+	// run serializes backups
+	run    sync.Mutex
+	mu     sync.Mutex
+	status Status
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewScheduler creates a scheduler backing up store to dest, Start runs it
+func NewScheduler(store Store, dest Destination, schedule Schedule, opts ...SchedulerOption) *Scheduler {
+	s := &Scheduler{store: store, dest: dest, schedule: schedule, name: "backup", now: time.Now}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start takes backups on the schedule until Stop is called
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	s.status.Running = true
+	go s.loop(s.stop, s.done)
+}
+
+// Stop stops the schedule, waiting for a running backup to finish
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.status.Running = false
+	s.status.Next = time.Time{}
+	s.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+func (s *Scheduler) loop(stop, done chan struct{}) {
+	defer close(done)
+	for {
+		now := s.now()
+		next := s.schedule.Next(now)
+		if next.IsZero() {
+			logger.Warn("backup schedule has no next time", "name", s.name)
+			return
+		}
+		s.mu.Lock()
+		s.status.Next = next
+		s.mu.Unlock()
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			s.RunNow()
+		}
+	}
+}
+
+// RunNow takes a backup, verifies it and prunes the archives. A backup whose
+// archives could not be pruned is a success with a RetentionError status
+func (s *Scheduler) RunNow() (*Manifest, error) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	now := s.now().UTC()
+	name := s.archiveName(now)
+	m, err := s.backup(name)
+	if err != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.status.LastFailure = now
+		s.status.LastError = err.Error()
+		s.status.Failures++
+		logger.Warn("scheduled backup failed", "name", name, "err", err)
+		return nil, err
+	}
+	perr := s.prune()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastSuccess = now
+	s.status.LastArchive = name
+	s.status.LastVersion = m.Version
+	s.status.Successes++
+	s.status.RetentionError = ""
+	if perr != nil {
+		s.status.RetentionError = perr.Error()
+		logger.Warn("pruning backups failed", "name", name, "err", perr)
+	}
+	logger.Info("scheduled backup", "name", name, "version", m.Version, "size", m.Size)
+	return m, nil
+}
+
+// Status returns the status of the scheduler
+func (s *Scheduler) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// ServeStatus writes the status of the scheduler as json
+func (s *Scheduler) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
+
+// backup writes an archive named name and verifies it, an archive which
+// fails to verify is removed
+func (s *Scheduler) backup(name string) (*Manifest, error) {
+	var m *Manifest
+	err := s.dest.Put(name, func(w io.Writer) (err error) {
+		m, err = s.store.Backup(w, Options{Gzip: s.gzip})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.verify(name, m); err != nil {
+		s.dest.Remove(name)
+		return nil, fmt.Errorf("verify %s: %w", name, err)
+	}
+	return m, nil
+}
+
+func (s *Scheduler) verify(name string, m *Manifest) error {
+	r, err := s.dest.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	written, err := Extract(r, nil)
+	if err != nil {
+		return err
+	}
+	if written.Checksum != m.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// prune removes the archives of the scheduler not kept by the retention rules
+func (s *Scheduler) prune() error {
+	if s.retention == (Retention{}) {
+		return nil
+	}
+	names, err := s.dest.List()
+	if err != nil {
+		return err
+	}
+	archives := []archive{}
+	for _, name := range names {
+		if created, ok := s.archiveTime(name); ok {
+			archives = append(archives, archive{name, created})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].created.After(archives[j].created)
+	})
+	keep := s.retention.keep(archives)
+	for _, a := range archives {
+		if !keep[a.name] {
+			if err := s.dest.Remove(a.name); err != nil {
+				return err
+			}
+			logger.Debug("removed backup", "name", a.name)
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) archiveName(t time.Time) string {
+	name := s.name + "-" + t.Format(archiveTimeLayout) + ".tar"
+	if s.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// archiveTime parses the time of an archive named by the scheduler
+func (s *Scheduler) archiveTime(name string) (time.Time, bool) {
+	if !strings.HasPrefix(name, s.name+"-") {
+		return time.Time{}, false
+	}
+	stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, s.name+"-"), ".gz"), ".tar")
+	t, err := time.Parse(archiveTimeLayout, stamp)
+	return t, err == nil
+}
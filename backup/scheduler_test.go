@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memStore struct {
+	data string
+	err  error
+}
+
+// readOnlyDestination fails to remove archives
+type readOnlyDestination struct {
+	*LocalDestination
+}
+
+func (d readOnlyDestination) Remove(name string) error {
+	return errors.New("read-only")
+}
+
+func (s *memStore) Backup(w io.Writer, opts Options) (*Manifest, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	m := &Manifest{Store: "mem"}
+	err := Write(w, m, opts.Gzip, func(w io.Writer) (uint64, error) {
+		_, err := io.WriteString(w, s.data)
+		return 1, err
+	})
+	return m, err
+}
+
+func TestParseCron(t *testing.T) {
+	from := time.Date(2026, 10, 19, 14, 7, 30, 0, time.UTC) // a monday
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 10, 19, 14, 15, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"30 2 * * sun", time.Date(2026, 10, 25, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2026, 10, 25, 2, 30, 0, 0, time.UTC)},
+		{"0 9 1-5 * *", time.Date(2026, 11, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 13 * fri", time.Date(2026, 10, 23, 12, 0, 0, 0, time.UTC)},
+		{"0 12 1 * */2", time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)},
+		{"5,10 14 * * *", time.Date(2026, 10, 19, 14, 10, 0, 0, time.UTC)},
+		{"@every 90m", from.Add(90 * time.Minute)},
+		{"0 0 30 feb *", time.Time{}},
+	}
+	for _, tt := range tests {
+		s, err := ParseCron(tt.spec)
+		assert.Nil(t, err, tt.spec)
+		assert.Equal(t, tt.next, s.Next(from), tt.spec)
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "@every soon"} {
+		_, err := ParseCron(spec)
+		assert.NotNil(t, err, spec)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	start := time.Date(2026, 10, 19, 23, 0, 0, 0, time.UTC)
+	archives := []archive{}
+	// every 6 hours over 3 weeks, newest first
+	for i := 0; i < 4*21; i++ {
+		created := start.Add(-time.Duration(i) * 6 * time.Hour)
+		archives = append(archives, archive{created.Format(time.RFC3339), created})
+	}
+	keep := Retention{Hourly: 2, Daily: 3, Weekly: 3}.keep(archives)
+	kept := []string{}
+	for _, a := range archives {
+		if keep[a.name] {
+			kept = append(kept, a.name)
+		}
+	}
+	assert.Equal(t, []string{
+		"2026-10-19T23:00:00Z",
+		"2026-10-19T17:00:00Z",
+		"2026-10-18T23:00:00Z",
+		"2026-10-17T23:00:00Z",
+		"2026-10-11T23:00:00Z",
+	}, kept)
+	assert.Len(t, Retention{}.keep(archives), len(archives))
+}
+
+func TestScheduler(t *testing.T) {
+	dest, err := NewLocalDestination(filepath.Join(t.TempDir(), "backups"))
+	assert.Nil(t, err)
+	store := &memStore{data: "rows"}
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	s := NewScheduler(store, dest, Every(time.Hour), SchedulerGzip(), SchedulerRetention(Retention{Hourly: 2}))
+	s.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		m, err := s.RunNow()
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), m.Version)
+		now = now.Add(time.Hour)
+	}
+	// an archive the scheduler did not name is never pruned
+	assert.Nil(t, os.WriteFile(filepath.Join(dest.Dir, "manual.tar"), nil, 0600))
+	_, err = s.RunNow()
+	assert.Nil(t, err)
+	names, err := dest.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"backup-20261019T120000.000Z.tar.gz", "backup-20261019T130000.000Z.tar.gz", "manual.tar"}, names)
+	for _, name := range names[:2] {
+		_, err := VerifyFile(filepath.Join(dest.Dir, name))
+		assert.Nil(t, err)
+	}
+
+	store.err = errors.New("disk full")
+	failedAt := now
+	_, err = s.RunNow()
+	assert.NotNil(t, err)
+	status := s.Status()
+	assert.Equal(t, 4, status.Successes)
+	assert.Equal(t, 1, status.Failures)
+	assert.Equal(t, "backup-20261019T130000.000Z.tar.gz", status.LastArchive)
+	assert.Equal(t, failedAt, status.LastFailure)
+	assert.Equal(t, "disk full", status.LastError)
+
+	rec := httptest.NewRecorder()
+	s.ServeStatus(rec, httptest.NewRequest("GET", "/", nil))
+	assert.True(t, strings.Contains(rec.Body.String(), `"lastError":"disk full"`))
+
+	// a backup whose archives cannot be pruned is only a success
+	store.err = nil
+	s.dest = readOnlyDestination{dest}
+	now = now.Add(time.Hour)
+	_, err = s.RunNow()
+	assert.Nil(t, err)
+	status = s.Status()
+	assert.Equal(t, 5, status.Successes)
+	assert.Equal(t, 1, status.Failures)
+	assert.Equal(t, "read-only", status.RetentionError)
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	dest, err := NewLocalDestination(t.TempDir())
+	assert.Nil(t, err)
+	s := NewScheduler(&memStore{data: "rows"}, dest, Every(time.Second))
+	s.Start()
+	assert.True(t, s.Status().Running)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Status().Successes == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.Stop()
+	status := s.Status()
+	assert.False(t, status.Running)
+	assert.True(t, status.Successes > 0)
+	assert.True(t, status.Next.IsZero())
+}
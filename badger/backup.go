@@ -1,34 +1,298 @@
 package badger
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	badgerdb "github.com/dgraph-io/badger"
+	"github.com/osiloke/gostore-contrib/backup"
+	"github.com/osiloke/gostore-contrib/indexer"
 )
 
-// WriteToHTTP writes store to http writer
-func (s *BadgerStore) WriteToHTTP(w http.ResponseWriter) error {
-	since := uint64(0)
-	_, err := s.Db.Backup(w, since)
+// backupKey holds the chain of the last backup, it is left out of backups
+const backupKey = "_backup"
+
+// WriteToHTTP writes a backup archive of the entries newer than since to w
+func (s *BadgerStore) WriteToHTTP(w http.ResponseWriter, since ...uint64) error {
+	opts := backup.Options{}
+	if len(since) > 0 {
+		opts.Since = since[0]
+	}
+	return s.writeHTTP(w, opts)
+}
+
+// ServeBackup serves a backup archive. The since query parameter is a
+// version or "last" to continue from the last backup, gzip=true compresses it
+func (s *BadgerStore) ServeBackup(w http.ResponseWriter, r *http.Request) {
+	opts := backup.Options{}
+	q := r.URL.Query()
+	if since := q.Get("since"); since == "last" {
+		watermark, err := s.BackupWatermark()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		opts.Since = watermark
+	} else if since != "" {
+		v, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since "+since, http.StatusBadRequest)
+			return
+		}
+		opts.Since = v
+	}
+	if gz := q.Get("gzip"); gz != "" {
+		v, err := strconv.ParseBool(gz)
+		if err != nil {
+			http.Error(w, "invalid gzip "+gz, http.StatusBadRequest)
+			return
+		}
+		opts.Gzip = v
+	}
+	if err := s.writeHTTP(w, opts); err != nil {
+		// nothing is written before the archive is complete
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *BadgerStore) writeHTTP(w http.ResponseWriter, opts backup.Options) error {
+	date := time.Now().Format("2006_01_02_15-04-05")
+	filename := fmt.Sprintf("badger_%d_%s.tar", opts.Since, date)
+	contentType := "application/x-tar"
+	if opts.Gzip {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	_, err := s.Backup(w, opts)
+	return err
+}
+
+// Backup writes a backup archive of the store to w. The data is a badger
+// backup of the entries newer than opts.Since, the manifest version is the
+// watermark to pass as Since to continue from this backup. The chain of the
+// backup is persisted so the next incremental backup records it
+func (s *BadgerStore) Backup(w io.Writer, opts backup.Options) (*backup.Manifest, error) {
+	tables, err := s.tables()
+	if err != nil {
+		return nil, err
+	}
+	index, err := s.indexConfig()
 	if err != nil {
-		// http.Error(w, err.Error(), http.StatusInternalServerError)p
+		return nil, err
+	}
+	m := &backup.Manifest{Store: backup.StoreBadger, Since: opts.Since, Tables: tables, Index: index}
+	if opts.Since > 0 {
+		chain, err := s.backupChain()
+		if err != nil {
+			return nil, err
+		}
+		// an incremental backup continues the chain ending at its since version
+		for i, link := range chain {
+			if link.Version == opts.Since {
+				m.Chain = chain[:i+1]
+				break
+			}
+		}
+	}
+	err = backup.Write(w, m, opts.Gzip, func(w io.Writer) (uint64, error) {
+		stream := s.Db.NewStream()
+		stream.LogPrefix = "BadgerStore.Backup"
+		stream.ChooseKey = func(item *badgerdb.Item) bool {
+			return string(item.Key()) != backupKey
+		}
+		return stream.Backup(w, opts.Since)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveBackupChain(append(m.Chain[:len(m.Chain):len(m.Chain)], m.Link())); err != nil {
+		return m, err
+	}
+	logger.Info("backed up", "since", m.Since, "version", m.Version, "size", m.Size)
+	return m, nil
+}
+
+// BackupToFile writes a backup archive of the store to path
+func (s *BadgerStore) BackupToFile(path string, opts backup.Options) (m *backup.Manifest, err error) {
+	err = backup.WriteFile(path, func(w io.Writer) error {
+		m, err = s.Backup(w, opts)
 		return err
+	})
+	return
+}
+
+// BackupWatermark returns the version of the last backup, backing up since
+// this version continues from it
+func (s *BadgerStore) BackupWatermark() (uint64, error) {
+	chain, err := s.backupChain()
+	if err != nil || len(chain) == 0 {
+		return 0, err
 	}
-	// _, size := db.Size()
-	date := time.Now().Format("2006_01_02_15-04-05")
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="badger_%d_%s.db"`, since, date))
-	// w.Header().Set("Content-Length", strconv.Itoa(int(size)))
-	return nil
+	return chain[len(chain)-1].Version, nil
 }
 
-func (s *BadgerStore) Restore(filename string) error {
-	// Open File
-	f, err := os.Open(filename)
+// backupChain returns the chain of the last backup, base first
+func (s *BadgerStore) backupChain() (chain []backup.Link, err error) {
+	err = s.Db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get([]byte(backupKey))
+		if err == badgerdb.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &chain)
+		})
+	})
+	return
+}
+
+func (s *BadgerStore) saveBackupChain(chain []backup.Link) error {
+	data, err := json.Marshal(chain)
+	if err != nil {
+		return err
+	}
+	return s.Db.Update(func(txn *badgerdb.Txn) error {
+		return txn.Set([]byte(backupKey), data)
+	})
+}
+
+// RestoreBackups loads a full backup followed by incremental backups, in
+// order, into the store. Backups are archives or raw badger backups, gzipped
+// or not. Every backup is verified and the chain checked before anything is
+// loaded, backups with a gap between them are rejected. The index is rebuilt
+// once the rows are loaded and the store continues the chain of the last
+// backup
+func (s *BadgerStore) RestoreBackups(paths ...string) ([]*backup.Manifest, error) {
+	manifests := make([]*backup.Manifest, len(paths))
+	for i, path := range paths {
+		m, err := backup.VerifyFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if m.Store != backup.StoreBadger {
+			return nil, fmt.Errorf("%s is a %s backup", path, m.Store)
+		}
+		manifests[i] = m
+	}
+	if err := backup.CheckChain(manifests); err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if err := s.restoreArchive(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		logger.Info("restored backup", "path", path)
+	}
+	if err := s.reindexRestored(); err != nil {
+		return manifests, err
+	}
+	last := manifests[len(manifests)-1]
+	if last.Checksum == "" {
+		// a raw backup has no link to continue from
+		return manifests, nil
+	}
+	if err := s.saveBackupChain(append(last.Chain[:len(last.Chain):len(last.Chain)], last.Link())); err != nil {
+		return manifests, err
+	}
+	return manifests, nil
+}
+
+func (s *BadgerStore) restoreArchive(path string) error {
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return s.Db.Load(f, 1)
+	_, err = backup.Extract(f, func(m *backup.Manifest, data io.Reader) error {
+		return s.Db.Load(data, 1)
+	})
+	return err
+}
+
+// reindexRestored rebuilds the index of a store opened with NewWithIndex and
+// reindexes tables routed to named indexes, the rows of other stores are
+// indexed into their indexer
+func (s *BadgerStore) reindexRestored() error {
+	if s.reindex != nil {
+		if err := s.Reindex(context.Background()); err != nil {
+			return err
+		}
+		for table := range s.IndexRoutes() {
+			if err := s.reIndexTable(table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if s.Indexer == nil {
+		return nil
+	}
+	report, err := indexer.ReIndex(s, s.Indexer)
+	if err != nil {
+		return err
+	}
+	if len(report.Failures) > 0 {
+		logger.Warn("some restored documents failed to reindex", "indexed", report.Indexed, "failed", len(report.Failures))
+	}
+	return nil
+}
+
+// tables lists the tables with rows, skipping past the rows of each table
+func (s *BadgerStore) tables() ([]string, error) {
+	tables := []string{}
+	prefix := []byte(s.keyForTable(""))
+	err := s.Db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); {
+			key := string(it.Item().Key()[len(prefix):])
+			i := strings.Index(key, "|")
+			if i < 0 {
+				it.Next()
+				continue
+			}
+			table := key[:i]
+			tables = append(tables, table)
+			// "}" sorts right after the "|" separating table and id
+			it.Seek([]byte(s.keyForTable(table) + "}"))
+		}
+		return nil
+	})
+	return tables, err
+}
+
+// indexConfig the index type, mapping and geo field the store was opened with
+func (s *BadgerStore) indexConfig() (config *backup.IndexConfig, err error) {
+	if s.reindex != nil {
+		s.reindex.mu.Lock()
+		index := s.reindex.index
+		s.reindex.mu.Unlock()
+		config, err = backup.NewIndexConfig(index, s.reindex.mapping)
+	} else if s.Indexer != nil && s.Indexer.Index() != nil {
+		config, err = backup.NewIndexConfig("", s.Indexer.Index().Mapping())
+	}
+	if config != nil {
+		if geoIndexer, ok := s.Indexer.(indexer.GeoCapableIndexer); ok {
+			config.GeoField = geoIndexer.GeoField()
+		}
+	}
+	return
+}
+
+// Restore loads a backup into the store and rebuilds the index
+func (s *BadgerStore) Restore(filename string) error {
+	_, err := s.RestoreBackups(filename)
+	return err
 }
@@ -2,9 +2,9 @@ package badger
 
 //TODO: Extract methods into functions
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"time"
 
 	// "fmt"
@@ -14,6 +14,7 @@ import (
 	"strings"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
 	"github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/search"
 	badgerdb "github.com/dgraph-io/badger"
@@ -43,6 +44,9 @@ type BadgerStore struct {
 	tableConfig map[string]*TableConfig
 	t           *time.Ticker
 	done        chan bool
+	reindex     *reindexState
+	queue       *indexQueue
+	snapshots   *snapshotSet
 }
 
 // IndexedData represents a stored row
@@ -57,6 +61,11 @@ func (d *IndexedData) Type() string {
 	return "indexed_data"
 }
 
+// BleveType types indexed documents by their bucket
+func (d IndexedData) BleveType() string {
+	return d.Bucket
+}
+
 func (s *BadgerStore) setupTicker() {
 	done := make(chan bool)
 	ticker := time.NewTicker(5 * time.Minute)
@@ -89,11 +98,24 @@ func NewDBOnly(dbPath string) (s *BadgerStore, err error) {
 		make(map[string]*TableConfig),
 		nil,
 		nil,
+		nil,
+		nil,
+		newSnapshotSet(),
 	}
 	s.setupTicker()
 	return
 }
 
+// defaultIndexMapping the mapping of stores created without a mapping or
+// config, fields are only indexed by the document mappings added to it
+func defaultIndexMapping() *mapping.IndexMappingImpl {
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.IndexDynamic = false
+	indexMapping.StoreDynamic = false
+	indexer.StoreBucket(indexMapping)
+	return indexMapping
+}
+
 // New badger store
 func New(root string) (s *BadgerStore, err error) {
 	dbPath := filepath.Join(root, "db")
@@ -112,10 +134,7 @@ func New(root string) (s *BadgerStore, err error) {
 		logger.Error("unable to create badgerdb", "err", err.Error(), "opt", opt)
 		return
 	}
-	indexMapping := bleve.NewIndexMapping()
-	indexMapping.IndexDynamic = false
-	indexMapping.StoreDynamic = false
-	index := indexer.NewIndexer(indexPath, indexMapping)
+	index := indexer.NewIndexer(indexPath, defaultIndexMapping())
 	s = &BadgerStore{
 		[]byte("_default"),
 		db,
@@ -123,6 +142,9 @@ func New(root string) (s *BadgerStore, err error) {
 		make(map[string]*TableConfig),
 		nil,
 		nil,
+		nil,
+		nil,
+		newSnapshotSet(),
 	}
 	s.setupTicker()
 	return
@@ -154,6 +176,9 @@ func NewWithIndexer(root string, index indexer.Indexer) (s *BadgerStore, err err
 		make(map[string]*TableConfig),
 		nil,
 		nil,
+		nil,
+		nil,
+		newSnapshotSet(),
 	}
 	s.setupTicker()
 	//	e.CreateBucket(bucket)
@@ -165,79 +190,105 @@ var indexFilenamePrefix = map[string]string{
 	"moss":        "moss_",
 	"moss-scorch": "moss_scorch_",
 	"geo-moss":    "geo_moss_",
+	"scorch":      "scorch_",
+}
+
+// NewWithIndexConfig New badger store with an index mapping built from a config
+func NewWithIndexConfig(root, index string, config *indexer.IndexConfig, indexOpts ...indexer.IndexOptions) (s *BadgerStore, err error) {
+	indexMapping, err := config.Mapping()
+	if err != nil {
+		return nil, err
+	}
+	return NewWithIndex(root, index, indexMapping, indexOpts...)
+}
+
+// NewWithScorchIndex New badger store with a scorch index using the given persister and merger options
+func NewWithScorchIndex(root string, indexMapping mapping.IndexMapping, scorchOpts indexer.ScorchOptions, indexOpts ...indexer.IndexOptions) (s *BadgerStore, err error) {
+	return newWithIndex(root, "scorch", indexMapping, scorchOpts, indexOpts...)
 }
 
-// NewWithIndex New badger store with indexer
+// NewWithIndex New badger store with indexer, a nil mapping does not index fields dynamically
 func NewWithIndex(root, index string, indexMapping mapping.IndexMapping, indexOpts ...indexer.IndexOptions) (s *BadgerStore, err error) {
+	return newWithIndex(root, index, indexMapping, indexer.ScorchOptions{}, indexOpts...)
+}
+
+func newWithIndex(root, index string, indexMapping mapping.IndexMapping, scorchOpts indexer.ScorchOptions, indexOpts ...indexer.IndexOptions) (s *BadgerStore, err error) {
+	if indexMapping == nil {
+		indexMapping = defaultIndexMapping()
+	}
 	if _, err := os.Stat(root); os.IsNotExist(err) {
 		os.Mkdir(root, os.FileMode(0755))
 		logger.Debug("created root path " + root)
 	}
 	indexPath := filepath.Join(root, indexFilenamePrefix[index]+"db.index")
-	var ix indexer.Indexer
-	reIndex := false
-	indexInitPath := filepath.Join(root, ".init")
+	initIndex, initDir, initialized := readIndexInit(root)
+	if initialized && initIndex == index {
+		indexPath = filepath.Join(root, initDir)
+	}
+	reIndex := !initialized || initIndex != index
 	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
 		reIndex = true
-		os.Remove(indexInitPath)
 	}
-	if _, err := os.Stat(indexInitPath); os.IsNotExist(err) {
-		reIndex = true
+	// a persistent index of another type keeps serving while the new index is built
+	online := false
+	oldPath := filepath.Join(root, initDir)
+	if reIndex && initialized && initIndex != index && initIndex != "memory" {
+		if _, err := os.Stat(oldPath); err == nil {
+			online = true
+		}
 	}
-	if dat, err := os.ReadFile(indexInitPath); err != nil || !strings.HasPrefix(string(dat), index) {
-		// initialized index is not the same as current index
-		reIndex = true
-		logger.Warn("initialized db is different from current", "path", indexInitPath, "init", string(dat), "current", index)
-		entries, err := os.ReadDir(root)
-		if err != nil {
+	var ix indexer.Indexer
+	if online {
+		logger.Warn("initialized index is different from current, rebuilding online", "init", initIndex, "current", index)
+		if err := removeIndexDirs(root, initDir); err != nil {
 			return nil, err
 		}
-		for _, entry := range entries {
-			if entry.IsDir() && strings.HasSuffix(entry.Name(), "db.index") {
-				err := os.RemoveAll(filepath.Join(root, entry.Name()))
-				if err != nil {
-					return nil, err
-				}
-				logger.Debug("removing index", "path", entry.Name())
-			} else if strings.HasSuffix(entry.Name(), ".init") {
-				err := os.RemoveAll(filepath.Join(root, entry.Name()))
-				if err != nil {
-					return nil, err
-				}
-				logger.Debug("removing index init", "path", entry.Name())
+		ix = openIndex(initIndex, oldPath, indexMapping, scorchOpts)
+	} else {
+		if reIndex {
+			// initialized index is not the same as current index
+			logger.Warn("initialized db is different from current", "init", initIndex, "current", index)
+			if err := removeIndexDirs(root, ""); err != nil {
+				return nil, err
 			}
+			os.Remove(filepath.Join(root, ".init"))
 		}
-	}
-	if index == "badger" {
-		if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-			os.Mkdir(indexPath, os.FileMode(0755))
-			logger.Debug("made badger db index path", "path", indexPath)
-		}
-		ix = indexer.NewBadgerIndexerWithMapping(indexPath, indexMapping)
-	} else if index == "memory" {
-		ix, _ = indexer.NewMemIndexerWithMapping(indexPath, indexMapping)
-	} else if index == "moss-scorch" {
-		ix, _ = indexer.NewMossScorchIndexerWithMapping(indexPath, indexMapping)
-	} else if index == "moss" {
-		ix, _ = indexer.NewMossIndexer(indexPath)
-	} else if index == "geo-moss" {
-		ix, _ = indexer.NewMossIndexerWithMapping(indexPath, indexMapping)
-	} else {
-		ix = indexer.NewIndexer(indexPath, indexMapping)
+		ix = openIndex(index, indexPath, indexMapping, scorchOpts)
 	}
 
-	geoIndex := &indexer.GeoIndexer{Field: "_location", Indexer: ix}
+	swap := indexer.NewSwapIndexer(ix)
+	router := indexer.NewRouterIndexer(swap)
+	geoIndex := &indexer.GeoIndexer{Field: "_location", Indexer: router}
 	for _, opt := range indexOpts {
 		opt(geoIndex)
 	}
 	s, err = NewWithIndexer(root, geoIndex)
-	if reIndex {
+	if err != nil {
+		return
+	}
+	s.reindex = &reindexState{root: root, index: index, path: indexPath, mapping: indexMapping, scorch: scorchOpts, swap: swap, router: router}
+	if err = s.openIndexes(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if online {
+		s.reindex.index, s.reindex.path = initIndex, oldPath
+		s.reindex.wg.Add(1)
+		go func() {
+			defer s.reindex.wg.Done()
+			s.rebuild(context.Background(), index, indexPath)
+		}()
+	} else if reIndex {
 		ixj, _ := json.Marshal(ix.Index().Mapping())
 		logger.Debug("reindex db", "mapping", string(ixj))
-		if err := indexer.ReIndex(s, ix); err != nil {
+		report, err := indexer.ReIndex(indexer.ExcludeStores(s, router.RoutedBuckets()...), ix)
+		if err != nil {
 			return nil, err
 		}
-		err = ioutil.WriteFile(indexInitPath, []byte(index+"|"+time.Now().UTC().String()), os.ModePerm)
+		if len(report.Failures) > 0 {
+			logger.Warn("some documents failed to reindex", "indexed", report.Indexed, "failed", len(report.Failures))
+		}
+		err = writeIndexInit(root, index, indexPath)
 	}
 	return
 }
@@ -273,7 +324,7 @@ func (s *BadgerStore) GetStore() interface{} {
 
 // UpdateTransaction starts an update transaction
 func (s *BadgerStore) UpdateTransaction() gostore.Transaction {
-	return &BadgerTransaction{s.Db, s.Db.NewTransaction(true), "update"}
+	return &BadgerTransaction{db: s.Db, txn: s.Db.NewTransaction(true), mode: "update"}
 }
 
 // FinishTransaction ebds transaction
@@ -290,21 +341,28 @@ func (s *BadgerStore) updateTableStats(table string, change uint) {
 }
 
 func (s *BadgerStore) _Get(key, store string) ([][]byte, error) {
+	var data [][]byte
+	err := s.Db.View(func(txn *badgerdb.Txn) (err error) {
+		data, err = s.getRow(txn, key, store)
+		return err
+	})
+	return data, err
+}
+
+// getRow gets the row of key in store as seen by txn
+func (s *BadgerStore) getRow(txn *badgerdb.Txn, key, store string) ([][]byte, error) {
 	k := s.keyForTableId(store, key)
 	storeKey := []byte(k)
 	var val []byte
-	err := s.Db.View(func(txn *badgerdb.Txn) error {
-		item, err2 := txn.Get(storeKey)
-		if err2 != nil {
-			logger.Info("error getting key", "store", store, "key", k, "err", err2.Error())
-			return err2
-		}
-		err2 = item.Value(func(v []byte) error {
+	item, err := txn.Get(storeKey)
+	if err != nil {
+		logger.Info("error getting key", "store", store, "key", k, "err", err.Error())
+	} else {
+		err = item.Value(func(v []byte) error {
 			val = append([]byte{}, v...)
 			return nil
 		})
-		return err2
-	})
+	}
 	if err != nil {
 		if err == badgerdb.ErrKeyNotFound {
 			return nil, gostore.ErrNotFound
@@ -447,33 +505,14 @@ func (s *BadgerStore) _Save(key, store string, data []byte) error {
 	return err
 }
 
-// All gets all entries in a store
+// All gets count entries of a store after skipping skip
 // a gouritine which holds open a db view transaction and then listens on
 // a channel for getting the next row itr. There is also a timeout to prevent long running routines
 func (s *BadgerStore) All(count int, skip int, store string) (gostore.ObjectRows, error) {
 	var objs [][][]byte
-	err := s.Db.View(func(txn *badgerdb.Txn) error {
-		opts := badgerdb.DefaultIteratorOptions
-		opts.PrefetchSize = count / 2
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			obj := make([][]byte, 2)
-			// logger.Debug("key + " + string(k) + " retrieved")
-			err := item.Value(func(v []byte) error {
-				obj[1] = append([]byte{}, v...)
-				return nil
-			})
-			if err != nil {
-				return err
-			}
-			objs = append(objs, obj)
-			obj[0] = make([]byte, len(k))
-			copy(obj[0], k)
-		}
-		return nil
+	err := s.Db.View(func(txn *badgerdb.Txn) (err error) {
+		objs, err = s.allRows(txn, count, skip, store)
+		return err
 	})
 	if len(objs) > 0 {
 		return &TransactionRows{entries: objs, length: len(objs)}, err
@@ -523,21 +562,69 @@ func (s *BadgerStore) Stream() (*common.CursorRows, error) {
 	return nil, gostore.ErrNotImplemented
 }
 
+// AllWithinRange gets entries whose key or indexed fields fall within a range.
+// A range on the primary key "_id" is served by a kv scan, other fields are
+// served by the index
 func (s *BadgerStore) AllWithinRange(filter map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
-	return nil, gostore.ErrNotImplemented
+	ranges, err := indexer.ParseRanges(filter)
+	if err != nil {
+		return nil, err
+	}
+	var orderBy []string
+	if opts != nil {
+		orderBy = opts.GetOrderBy()
+	}
+	keyRange, fieldRanges := indexer.SplitKeyRange(ranges)
+	if keyRange != nil {
+		return s.keyRange(*keyRange, fieldRanges, count, skip, store, indexer.IsReverseOrder(orderBy))
+	}
+	if len(orderBy) == 0 {
+		orderBy = []string{"data." + fieldRanges[0].Field, "_id"}
+	}
+	q := indexer.GetQueryString(store, nil)
+	logger.Info("AllWithinRange", "count", count, "skip", skip, "Store", store, "query", q, "ranges", fieldRanges)
+	res, err := s.Indexer.RangeQuery(q, fieldRanges, count, skip, false, []string{}, indexer.OrderRequest(orderBy))
+	if err != nil {
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, nil
 }
 
-// Since get items after a key
-func (s *BadgerStore) Since(id string, count int, skip int, store string) (gostore.ObjectRows, error) {
+// keyRange scans the keys of a store within a range, filtering values with
+// any extra field ranges
+func (s *BadgerStore) keyRange(keyRange indexer.Range, fieldRanges []indexer.Range, count int, skip int, store string, reverse bool) (gostore.ObjectRows, error) {
 	var objs [][][]byte
+	prefix := []byte(s.keyForTable(store) + "|")
 	err := s.Db.View(func(txn *badgerdb.Txn) error {
 		opts := badgerdb.DefaultIteratorOptions
-		opts.PrefetchSize = 10
+		opts.PrefetchSize = count
+		opts.Reverse = reverse
 		it := txn.NewIterator(opts)
 		defer it.Close()
-		for it.Seek([]byte(s.keyForTableId(store, id))); it.Valid(); it.Next() {
+		seek := prefix
+		if reverse {
+			if keyRange.Max != nil {
+				seek = append(append([]byte{}, prefix...), []byte(to.String(keyRange.Max))...)
+			} else {
+				seek = append(append([]byte{}, prefix...), 0xFF)
+			}
+		} else if keyRange.Min != nil {
+			seek = append(append([]byte{}, prefix...), []byte(to.String(keyRange.Min))...)
+		}
+		skipped := 0
+		for it.Seek(seek); it.ValidForPrefix(prefix) && len(objs) < count; it.Next() {
 			item := it.Item()
 			k := item.Key()
+			c := keyRange.CompareKey(k[len(prefix):])
+			if (c > 0 && !reverse) || (c < 0 && reverse) {
+				break
+			}
+			if c != 0 {
+				continue
+			}
 			obj := make([][]byte, 2)
 			err := item.Value(func(v []byte) error {
 				obj[1] = append([]byte{}, v...)
@@ -546,15 +633,90 @@ func (s *BadgerStore) Since(id string, count int, skip int, store string) (gosto
 			if err != nil {
 				return err
 			}
+			if len(fieldRanges) > 0 {
+				var doc map[string]interface{}
+				if err := json.Unmarshal(obj[1], &doc); err != nil {
+					return err
+				}
+				if !indexer.MatchesRanges(doc, fieldRanges) {
+					continue
+				}
+			}
+			if skipped < skip {
+				skipped++
+				continue
+			}
+			obj[0] = item.KeyCopy(nil)
 			objs = append(objs, obj)
-			obj[0] = make([]byte, len(k))
-			copy(obj[0], k)
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) > 0 {
+		return &TransactionRows{entries: objs, length: len(objs)}, nil
+	}
+	return nil, gostore.ErrNotFound
+}
+
+// Since get items after a key
+func (s *BadgerStore) Since(id string, count int, skip int, store string) (gostore.ObjectRows, error) {
+	var objs [][][]byte
+	err := s.Db.View(func(txn *badgerdb.Txn) (err error) {
+		objs, err = s.sinceRows(txn, id, count, skip, store)
+		return err
+	})
 	return &TransactionRows{entries: objs, length: len(objs)}, err
 }
 
+// allRows gets count rows of store after skipping skip as seen by txn, a
+// count of 0 or less gets every row
+func (s *BadgerStore) allRows(txn *badgerdb.Txn, count, skip int, store string) ([][][]byte, error) {
+	prefix := []byte(s.keyForTableId(store, ""))
+	opts := badgerdb.DefaultIteratorOptions
+	if count > 0 {
+		opts.PrefetchSize = count / 2
+	}
+	return iterRows(txn, opts, prefix, prefix, count, skip)
+}
+
+// sinceRows gets count rows of store from id onwards after skipping skip as
+// seen by txn, a count of 0 or less gets every row
+func (s *BadgerStore) sinceRows(txn *badgerdb.Txn, id string, count, skip int, store string) ([][][]byte, error) {
+	opts := badgerdb.DefaultIteratorOptions
+	opts.PrefetchSize = 10
+	return iterRows(txn, opts, []byte(s.keyForTableId(store, id)), []byte(s.keyForTableId(store, "")), count, skip)
+}
+
+// iterRows collects count keys and values from seek while they have prefix
+// after skipping skip, a count of 0 or less collects every key
+func iterRows(txn *badgerdb.Txn, opts badgerdb.IteratorOptions, seek, prefix []byte, count, skip int) ([][][]byte, error) {
+	var objs [][][]byte
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(seek); it.ValidForPrefix(prefix) && (count <= 0 || len(objs) < count); it.Next() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		item := it.Item()
+		k := item.Key()
+		obj := make([][]byte, 2)
+		err := item.Value(func(v []byte) error {
+			obj[1] = append([]byte{}, v...)
+			return nil
+		})
+		if err != nil {
+			return objs, err
+		}
+		objs = append(objs, obj)
+		obj[0] = make([]byte, len(k))
+		copy(obj[0], k)
+	}
+	return objs, nil
+}
+
 // Before Get all recent items from a key
 func (s *BadgerStore) Before(id string, count int, skip int, store string) (gostore.ObjectRows, error) {
 	var objs [][][]byte
@@ -619,12 +781,12 @@ func (s *BadgerStore) Save(key, store string, src interface{}) (string, error) {
 	skey := s.keyForTableId(store, key)
 	storeKey := []byte(skey)
 	logger.Debug("Save", "key", key, "store", store, "storeKey", skey)
-	err = s.Db.Update(func(txn *badgerdb.Txn) error {
+	err = s.update(func(txn *badgerdb.Txn) error {
 		err := txn.Set(storeKey, data)
 		if err != nil {
 			return err
 		}
-		return s.Indexer.IndexDocument(key, IndexedData{Bucket: store, Data: src})
+		return s.indexDocument(txn, key, store, src)
 	})
 	return key, err
 }
@@ -635,9 +797,9 @@ func (s *BadgerStore) SaveWithGeo(key, store string, src interface{}, field stri
 		skey := s.keyForTableId(store, key)
 		storeKey := []byte(skey)
 		logger.Debug("SaveWithGeo", "key", key, "store", store, "storeKey", skey)
-		err := s.Db.Update(func(txn *badgerdb.Txn) error {
+		err := s.update(func(txn *badgerdb.Txn) error {
 			if len(field) > 0 {
-				geo, err := valForPath(field, srcMap)
+				geo, err := common.ValForPath(field, srcMap)
 				if err == nil {
 					srcMap["_location"] = geo
 
@@ -649,7 +811,7 @@ func (s *BadgerStore) SaveWithGeo(key, store string, src interface{}, field stri
 					if err != nil {
 						return err
 					}
-					return s.Indexer.IndexDocument(key, map[string]interface{}{"bucket": store, "data": srcMap, "location": geo})
+					return s.indexGeoDocument(txn, key, store, srcMap, geo)
 				}
 				return err
 			}
@@ -662,7 +824,7 @@ func (s *BadgerStore) SaveWithGeo(key, store string, src interface{}, field stri
 			if err != nil {
 				return err
 			}
-			return s.Indexer.IndexDocument(key, IndexedData{Bucket: store, Data: src})
+			return s.indexDocument(txn, key, store, src)
 		})
 		return "", err
 	}
@@ -676,7 +838,7 @@ func (s *BadgerStore) SaveWithGeoTX(key, store string, src interface{}, field st
 		storeKey := []byte(skey)
 		logger.Debug("SaveWithGeoTX", "key", key, "store", store, "storeKey", skey)
 		if len(field) > 0 {
-			geo, err := valForPath(field, srcMap)
+			geo, err := common.ValForPath(field, srcMap)
 			if err == nil {
 				srcMap["_location"] = geo
 				data, err := json.Marshal(srcMap)
@@ -687,6 +849,9 @@ func (s *BadgerStore) SaveWithGeoTX(key, store string, src interface{}, field st
 				if err != nil {
 					return err
 				}
+				if s.queueTX(txn, indexOp{ID: key, Store: store, Location: geo}) {
+					return nil
+				}
 				return s.Indexer.IndexDocument(key, map[string]interface{}{"bucket": store, "data": srcMap, "location": geo})
 			}
 			return err
@@ -701,6 +866,9 @@ func (s *BadgerStore) SaveWithGeoTX(key, store string, src interface{}, field st
 		if err != nil {
 			return err
 		}
+		if s.queueTX(txn, indexOp{ID: key, Store: store}) {
+			return nil
+		}
 		return s.Indexer.IndexDocument(key, IndexedData{Bucket: store, Data: src})
 	}
 	return errors.New("unable to save")
@@ -719,6 +887,9 @@ func (s *BadgerStore) SaveTX(key, store string, src interface{}, txn gostore.Tra
 	if err != nil {
 		return err
 	}
+	if s.queueTX(txn, indexOp{ID: key, Store: store}) {
+		return nil
+	}
 	err = s.Indexer.IndexDocument(key, IndexedData{Bucket: store, Data: src})
 	return err
 }
@@ -860,6 +1031,31 @@ func (s *BadgerStore) FilterGetTX(filter map[string]interface{}, store string, d
 
 }
 
+// Suggest returns ranked completions of a prefix for a field in a store
+func (s *BadgerStore) Suggest(store, field, prefix string, n int) ([]indexer.Suggestion, error) {
+	logger.Info("Suggest", "store", store, "field", field, "prefix", prefix, "n", n)
+	return s.Indexer.Suggest(store, field, prefix, n)
+}
+
+// SimilarTo returns up to n rows of a store similar to the row with id,
+// compared by the significant terms of fields. The row itself is excluded
+func (s *BadgerStore) SimilarTo(store, id string, fields []string, n int) (gostore.ObjectRows, error) {
+	logger.Info("SimilarTo", "store", store, "id", id, "fields", fields, "n", n)
+	var doc map[string]interface{}
+	if err := s.Get(id, store, &doc); err != nil {
+		return nil, err
+	}
+	res, err := s.Indexer.SimilarTo(store, id, doc, fields, n)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, nil
+}
+
 // FilterGetAll allows you to filter a store if an indexer exists
 func (s *BadgerStore) FilterGetAll(filter map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
 	if query, ok := filter["q"].(map[string]interface{}); ok {
@@ -883,92 +1079,45 @@ func (s *BadgerStore) Query(query, aggregates map[string]interface{}, count int,
 	if len(query) > 0 {
 		var err error
 		var res *bleve.SearchResult
-		agg := gostore.AggregateResult{}
 		q := indexer.GetQueryString(store, query)
-		var order indexer.RequestOpt
-		order = indexer.OrderRequest([]string{"-_score", "-_id"})
+		order := indexer.OrderRequest([]string{"-_score", "-_id"})
 		if opts != nil {
 			if orderBy := opts.GetOrderBy(); len(orderBy) > 0 {
-				order = indexer.OrderRequest((orderBy))
+				order = indexer.OrderRequest(orderBy)
 			}
 		}
+		facets, aggs := indexer.ParseAggregates(aggregates)
 		if len(aggregates) == 0 {
 			logger.Info("Query", "count", count, "skip", skip, "Store", store, "query", q, "order", order)
 			res, err = s.Indexer.QueryWithOptions(q, count, skip, true, []string{}, order)
-
 		} else {
-			facets := indexer.Facets{}
-			for k, v := range aggregates {
-				if k == "top" && v != nil {
-					facets.Top = make(map[string]indexer.TopFacet)
-					for kk, vv := range v.(map[string]interface{}) {
-						f := vv.(map[string]interface{})
-						name := kk
-						if n, ok := f["name"].(string); ok {
-							name = n
-						}
-						facets.Top[name] = indexer.TopFacet{
-							Name:  f["name"].(string),
-							Field: "data." + f["field"].(string),
-							Count: int(to.Int64(f["count"])),
-						}
-					}
-				}
-				if k == "range" && v != nil {
-					facets.Range = make(map[string]indexer.RangeFacet)
-					if ranges, ok := v.(map[string]interface{}); ok {
-						for kk, vv := range ranges {
-							f := vv.(map[string]interface{})
-							name := kk
-							facets.Range[name] = indexer.RangeFacet{
-								Field:  "data." + f["field"].(string),
-								Ranges: f["ranges"].([]interface{}),
-							}
-						}
-					} else if ranges, ok := v.([]interface{}); ok {
-						for _, vv := range ranges {
-							f := vv.(map[string]interface{})
-							name := f["name"].(string)
-							facets.Range[name] = indexer.RangeFacet{
-								Field:  "data." + f["field"].(string),
-								Ranges: f["ranges"].([]interface{}),
-							}
-						}
-					}
-				}
-			}
 			logger.Info("Query", "count", count, "skip", skip, "Store", store, "query", q, "facets", facets, "orderBy", order)
-			res, err = s.Indexer.FacetedQuery(q, &facets, count, skip, true, []string{}, order)
-
+			res, err = s.Indexer.FacetedQuery(q, facets, count, skip, true, []string{}, order)
 		}
 		if err != nil {
 			logger.Warn("err", "error", err)
 			return nil, nil, err
 		}
-		if len(res.Facets) > 0 {
-			for k, v := range res.Facets {
-				if len(v.NumericRanges) > 0 {
-					// numericRanges := make([]interface{}, len(v.NumericRanges))
-					// for i, n := range v.NumericRanges{
-					// 	numericRanges[i] = map[string]interface{}{"field": n.Name, "min": n.Min, "max": n.Max, "count": n.Count}
-					// }
-					agg[k] = gostore.Match{
-						NumberRange: v.NumericRanges,
-						Field:       strings.SplitN(v.Field, ".", 2)[1],
-						Matched:     v.Total,
-						UnMatched:   v.Other,
-						Missing:     v.Missing,
-						DateRange:   search.DateRangeFacet{},
-					}
-				} else {
-					agg[k] = gostore.Match{
-						Top:       v.Terms,
-						Field:     strings.SplitN(v.Field, ".", 2)[1],
-						Matched:   v.Total,
-						UnMatched: v.Other,
-						Missing:   v.Missing,
-					}
+		agg, err := indexer.FacetResults(res, facets)
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.Total > 0 && !aggs.Empty() {
+			metrics, err := indexer.Aggregate(s.Indexer, q, aggs, func(id string) (map[string]interface{}, error) {
+				row, err := s._Get(id, store)
+				if err != nil {
+					return nil, err
 				}
+				var doc map[string]interface{}
+				err = json.Unmarshal(row[1], &doc)
+				return doc, err
+			})
+			if err != nil {
+				logger.Warn("err", "error", err)
+				return nil, nil, err
+			}
+			for k, v := range metrics {
+				agg[k] = v
 			}
 		}
 		if res.Total == 0 {
@@ -980,7 +1129,34 @@ func (s *BadgerStore) Query(query, aggregates map[string]interface{}, count int,
 	return nil, nil, gostore.ErrNotFound
 }
 
-// GeoQuery query a geocapable indexer
+// MultiQuery searches several stores in one request, each row has the store
+// it was retrieved from as "_bucket"
+func (s *BadgerStore) MultiQuery(stores []string, query map[string]interface{}, count int, skip int, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	if len(stores) == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	q := indexer.GetFilterString(query)
+	order := indexer.OrderRequest([]string{"-_score", "-_id"})
+	if opts != nil {
+		if orderBy := opts.GetOrderBy(); len(orderBy) > 0 {
+			order = indexer.OrderRequest(orderBy)
+		}
+	}
+	logger.Info("MultiQuery", "count", count, "skip", skip, "stores", stores, "query", q)
+	res, err := s.Indexer.QueryWithOptions(q, count, skip, true, []string{"bucket"}, indexer.BucketsRequest(stores), order)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: strings.Join(stores, ","), stores: stores, length: res.Total, result: res, bs: s}, nil
+}
+
+// GeoQuery query a geocapable indexer for rows within a distance of lon, lat.
+// Rows are ordered by opts, "_distance" orders by the distance from lon, lat
+// and each row has its distance in the unit of distance as "_distance"
 func (s *BadgerStore) GeoQuery(lon, lat float64, distance string, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
 
 	var err error
@@ -990,12 +1166,28 @@ func (s *BadgerStore) GeoQuery(lon, lat float64, distance string, query map[stri
 		q = indexer.GetQueryString(store, query)
 		// if len(aggregates) == 0 {
 	}
-	logger.Info("GeoQuery", "count", count, "skip", skip, "Store", store, "lat", lat, "lon", lon, "distance", distance, "query", q)
-	if geoIndexer, ok := s.Indexer.(indexer.GeoCapableIndexer); ok {
-		res, err = geoIndexer.GeoDistanceQuery(q, lon, lat, distance, count, skip, true, []string{}, indexer.OrderRequest([]string{"-_score", "-_id"}))
-	} else {
+	geoIndexer, ok := s.Indexer.(indexer.GeoCapableIndexer)
+	if !ok {
 		return nil, gostore.ErrNotImplemented
 	}
+	unit, err := indexer.DistanceUnit(distance)
+	if err != nil {
+		return nil, err
+	}
+	orderBy := []string{"-_score", "-_id"}
+	if opts != nil {
+		if o := opts.GetOrderBy(); len(o) > 0 {
+			orderBy = o
+		}
+	}
+	// the distance is always sorted on so it can be returned with each row
+	distanceAt := indexer.DistanceSortIndex(orderBy)
+	if distanceAt < 0 {
+		orderBy = append(append([]string{}, orderBy...), indexer.DistanceField)
+		distanceAt = len(orderBy) - 1
+	}
+	logger.Info("GeoQuery", "count", count, "skip", skip, "Store", store, "lat", lat, "lon", lon, "distance", distance, "query", q, "orderBy", orderBy)
+	res, err = geoIndexer.GeoDistanceQuery(q, lon, lat, distance, count, skip, true, []string{}, indexer.GeoOrderRequest(orderBy, geoIndexer.GeoField(), lon, lat, unit))
 	if err != nil {
 		logger.Warn("err", "error", err)
 		return nil, err
@@ -1004,7 +1196,40 @@ func (s *BadgerStore) GeoQuery(lon, lat float64, distance string, query map[stri
 		return nil, gostore.ErrNotFound
 	}
 
-	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, err
+	hitDistance := func(h *search.DocumentMatch) (float64, bool) {
+		return indexer.HitDistance(h, distanceAt)
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: hitDistance}, err
+}
+
+// GeoBoundingBoxQuery query a geocapable indexer for rows of a store within a bounding box.
+// Rows are ordered by opts, "_distance" orders by the distance in meters from
+// the center of the box and adds it to each row
+func (s *BadgerStore) GeoBoundingBoxQuery(topLeftLon, topLeftLat, bottomRightLon, bottomRightLat float64, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	res, distance, err := indexer.GeoBoundingBoxSearch(s.Indexer, store, query, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat, count, skip, opts)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: distance}, nil
+}
+
+// GeoPolygonQuery query a geocapable indexer for rows of a store within a polygon.
+// Rows are ordered by opts, "_distance" orders by the distance in meters from
+// the mean of the polygon's points and adds it to each row
+func (s *BadgerStore) GeoPolygonQuery(polygon []geo.Point, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	res, distance, err := indexer.GeoPolygonSearch(s.Indexer, store, query, polygon, count, skip, opts)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: distance}, nil
 }
 
 // FilterDelete filter delete items
@@ -1018,11 +1243,13 @@ func (s *BadgerStore) FilterDelete(query map[string]interface{}, store string, o
 			return gostore.ErrNotFound
 		}
 		for _, v := range res.Hits {
-			err = s._Delete(v.ID, store)
-			if err != nil {
-				break
-			}
-			err = s.Indexer.UnIndexDocument(v.ID)
+			storeKey := []byte(s.keyForTableId(store, v.ID))
+			err = s.update(func(txn *badgerdb.Txn) error {
+				if err := txn.Delete(storeKey); err != nil {
+					return err
+				}
+				return s.unIndexDocument(txn, v.ID, store)
+			})
 			if err != nil {
 				break
 			}
@@ -1058,7 +1285,7 @@ func (s *BadgerStore) BatchDelete(ids []interface{}, store string, opts gostore.
 func (s *BadgerStore) BatchUpdate(id []interface{}, data []interface{}, store string, opts gostore.ObjectStoreOptions) (err error) {
 	// keys = make([]string, len(data))
 	b := s.Indexer.BatchIndex()
-	err = s.Db.Update(func(txn *badgerdb.Txn) error {
+	err = s.update(func(txn *badgerdb.Txn) error {
 		for _, src := range data {
 			var key string
 			if _v, ok := src.(map[string]interface{}); ok {
@@ -1084,9 +1311,11 @@ func (s *BadgerStore) BatchUpdate(id []interface{}, data []interface{}, store st
 			}
 			indexedData := map[string]interface{}{"bucket": store, "data": src}
 			logger.Debug("BatchInsert", "row", indexedData)
-			b.Index(key, indexedData)
+			if err := s.batchDocument(txn, b, key, store, indexedData); err != nil {
+				return err
+			}
 		}
-		return s.Indexer.Batch(b)
+		return s.commitBatch(b)
 	})
 	return
 }
@@ -1129,40 +1358,43 @@ func (s *BadgerStore) BatchInsert(data []interface{}, store string, opts gostore
 	// 	}
 	// 	return s.Indexer.Batch(b)
 	// })
-	txn := s.Db.NewTransaction(true)
-	defer txn.Discard()
-	for i, src := range data {
-		var key string
-		if _v, ok := src.(map[string]interface{}); ok {
-			if k, ok := _v["id"].(string); ok {
-				key = k
+	err = s.update(func(txn *badgerdb.Txn) error {
+		for i, src := range data {
+			var key string
+			if _v, ok := src.(map[string]interface{}); ok {
+				if k, ok := _v["id"].(string); ok {
+					key = k
+				} else {
+					key = gostore.NewObjectId().String()
+					_v["id"] = key
+				}
+			} else if _v, ok := src.(HasID); ok {
+				key = _v.GetId()
 			} else {
 				key = gostore.NewObjectId().String()
-				_v["id"] = key
 			}
-		} else if _v, ok := src.(HasID); ok {
-			key = _v.GetId()
-		} else {
-			key = gostore.NewObjectId().String()
-		}
-		data, err := json.Marshal(src)
-		if err != nil {
-			return nil, err
-		}
-		storeKey := []byte(s.keyForTableId(store, key))
-		err = txn.Set(storeKey, data)
-		if err != nil {
-			return nil, err
+			data, err := json.Marshal(src)
+			if err != nil {
+				return err
+			}
+			storeKey := []byte(s.keyForTableId(store, key))
+			err = txn.Set(storeKey, data)
+			if err != nil {
+				return err
+			}
+			indexedData := IndexedData{Bucket: store, Data: src}
+			logger.Debug("BatchInsert", "row", indexedData)
+			if err := s.batchDocument(txn, b, key, store, indexedData); err != nil {
+				return err
+			}
+			keys[i] = key
 		}
-		indexedData := IndexedData{Bucket: store, Data: src}
-		logger.Debug("BatchInsert", "row", indexedData)
-		b.Index(key, indexedData)
-		keys[i] = key
-	}
-	if err2 := txn.Commit(); err2 != nil {
-		return nil, err2
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	err = s.Indexer.Batch(b)
+	err = s.commitBatch(b)
 	return
 }
 
@@ -1194,7 +1426,9 @@ func (s *BadgerStore) BatchInsertTX(data []interface{}, store string, opts gosto
 		}
 		indexedData := IndexedData{Bucket: store, Data: src}
 		logger.Debug("BatchInsertTX", "row", indexedData)
-		b.Index(key, indexedData)
+		if !s.queueTX(txn, indexOp{ID: key, Store: store}) {
+			b.Index(key, indexedData)
+		}
 		keys[i] = key
 	}
 	if err2 := txn.Commit(); err2 != nil {
@@ -1203,13 +1437,15 @@ func (s *BadgerStore) BatchInsertTX(data []interface{}, store string, opts gosto
 	if err = txn.Restart(); err != nil {
 		return
 	}
-	err = s.Indexer.Batch(b)
+	if b.Size() > 0 {
+		err = s.Indexer.Batch(b)
+	}
 	return
 }
 
 func (s *BadgerStore) BatchInsertKVAndIndex(rows [][][]byte, store string, opts gostore.ObjectStoreOptions) (keys []string, err error) {
 	keys = make([]string, len(rows))
-	err = s.Db.Update(func(txn *badgerdb.Txn) error {
+	err = s.update(func(txn *badgerdb.Txn) error {
 		b := s.Indexer.BatchIndex()
 		for i, row := range rows {
 			key := string(row[0])
@@ -1225,11 +1461,13 @@ func (s *BadgerStore) BatchInsertKVAndIndex(rows [][][]byte, store string, opts
 			if err != nil {
 				return err
 			}
-			b.Index(key, IndexedData{Bucket: store, Data: iData})
+			if err := s.batchDocument(txn, b, key, store, IndexedData{Bucket: store, Data: iData}); err != nil {
+				return err
+			}
 			keys[i] = key
 		}
 		logger.Debug("copied", "rows", len(keys))
-		return s.Indexer.Batch(b)
+		return s.commitBatch(b)
 	})
 	return
 }
@@ -1254,6 +1492,14 @@ func (s *BadgerStore) BatchInsertKV(rows [][][]byte, store string, opts gostore.
 }
 func (s *BadgerStore) Close() {
 	defer s.t.Stop()
+	if s.reindex != nil {
+		s.CancelReindex()
+		s.reindex.wg.Wait()
+	}
+	if s.queue != nil {
+		s.queue.stop()
+	}
+	s.snapshots.closeAll()
 	if s.Db != nil {
 		s.Db.Close()
 		logger.Debug("closed badger store")
@@ -1,11 +1,19 @@
 package badger
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/blevesearch/bleve/v2"
 	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
@@ -14,8 +22,11 @@ import (
 	"github.com/blevesearch/bleve/v2/analysis/lang/en"
 	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
 	rtoken "github.com/blevesearch/bleve/v2/analysis/tokenizer/regexp"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/mapping"
 	"github.com/blevesearch/bleve/v2/search"
 	"github.com/osiloke/gostore"
+	"github.com/osiloke/gostore-contrib/backup"
 	"github.com/osiloke/gostore-contrib/indexer"
 	"github.com/stretchr/testify/assert"
 )
@@ -26,6 +37,16 @@ func init() {
 	os.Mkdir(rootPath, 0777)
 }
 
+// dynamicMapping indexes every field, as a store created with an empty
+// index config does
+func dynamicMapping() mapping.IndexMapping {
+	indexMapping, err := (&indexer.IndexConfig{}).Mapping()
+	if err != nil {
+		panic(err)
+	}
+	return indexMapping
+}
+
 func createDB(name string) *BadgerStore {
 	mode := int(0777)
 	testDbPath := filepath.Join(rootPath, name)
@@ -794,3 +815,1026 @@ func TestBadgerStore_SaveTX(t *testing.T) {
 		})
 	}
 }
+
+func TestBadgerStore_AllWithinRange(t *testing.T) {
+	db := createDB("AllWithinRange")
+	defer removeDB("AllWithinRange", db)
+	db.CreateTable("data", nil)
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		db.Save(key, "data", map[string]interface{}{
+			"id":    key,
+			"count": float64(i),
+		})
+	}
+	collect := func(rows gostore.ObjectRows) []string {
+		ids := []string{}
+		for {
+			var row map[string]interface{}
+			if ok, _ := rows.Next(&row); !ok {
+				break
+			}
+			ids = append(ids, row["id"].(string))
+		}
+		return ids
+	}
+	tests := []struct {
+		name     string
+		filter   map[string]interface{}
+		opts     gostore.ObjectStoreOptions
+		expected []string
+	}{
+		{
+			"key range",
+			map[string]interface{}{"_id": map[string]interface{}{"gte": "b", "lt": "d"}},
+			nil,
+			[]string{"b", "c"},
+		},
+		{
+			"reverse key range",
+			map[string]interface{}{"_id": map[string]interface{}{"gt": "b"}},
+			gostore.DefaultObjectStoreOptions{OrderBy: []string{"-_id"}},
+			[]string{"e", "d", "c"},
+		},
+		{
+			"key range with field range",
+			map[string]interface{}{
+				"_id":   map[string]interface{}{"lte": "d"},
+				"count": map[string]interface{}{"gte": 2},
+			},
+			nil,
+			[]string{"c", "d"},
+		},
+		{
+			"indexed field range",
+			map[string]interface{}{"count": map[string]interface{}{"gt": 1, "lte": 3}},
+			nil,
+			[]string{"c", "d"},
+		},
+		{
+			"reverse indexed field range",
+			map[string]interface{}{"count": map[string]interface{}{"gte": 3}},
+			gostore.DefaultObjectStoreOptions{OrderBy: []string{"-data.count"}},
+			[]string{"e", "d"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := db.AllWithinRange(tt.filter, 10, 0, "data", tt.opts)
+			if err != nil {
+				t.Errorf("BadgerStore.AllWithinRange() error = %v", err)
+				return
+			}
+			assert.Equal(t, tt.expected, collect(rows))
+		})
+	}
+}
+
+func TestBadgerStore_QueryAggregations(t *testing.T) {
+	db := createDB("QueryAggregations")
+	defer removeDB("QueryAggregations", db)
+	db.CreateTable("orders", nil)
+	orders := []map[string]interface{}{
+		{"customer": "ada", "total": 10.0, "created": "2020-01-01T10:00:00Z"},
+		{"customer": "ada", "total": 30.0, "created": "2020-01-01T12:00:00Z"},
+		{"customer": "bob", "total": 20.0, "created": "2020-01-02T10:00:00Z"},
+		{"customer": "eve", "total": 40.0, "created": "2020-01-04T10:00:00Z"},
+	}
+	for _, o := range orders {
+		key := gostore.NewObjectId().String()
+		o["id"] = key
+		o["type"] = "order"
+		db.Save(key, "orders", o)
+	}
+	aggregates := map[string]interface{}{
+		"metrics": map[string]interface{}{
+			"revenue":   map[string]interface{}{"field": "total", "type": "sum"},
+			"average":   map[string]interface{}{"field": "total", "type": "avg"},
+			"smallest":  map[string]interface{}{"field": "total", "type": "min"},
+			"largest":   map[string]interface{}{"field": "total", "type": "max"},
+			"customers": map[string]interface{}{"field": "customer", "type": "cardinality"},
+		},
+		"groupBy": map[string]interface{}{
+			"byCustomer": map[string]interface{}{
+				"field": "customer",
+				"size":  2,
+				"metrics": map[string]interface{}{
+					"revenue": map[string]interface{}{"field": "total", "type": "sum"},
+				},
+			},
+		},
+		"dateRange": map[string]interface{}{
+			"firstDay": map[string]interface{}{
+				"field": "created",
+				"ranges": []interface{}{
+					map[string]interface{}{"name": "jan1", "start": "2020-01-01T00:00:00Z", "end": "2020-01-02T00:00:00Z"},
+				},
+			},
+		},
+		"dateHistogram": map[string]interface{}{
+			"perDay": map[string]interface{}{
+				"field":    "created",
+				"interval": "day",
+				"start":    "2020-01-01T00:00:00Z",
+				"end":      "2020-01-05T00:00:00Z",
+			},
+		},
+	}
+	// a page size of one checks aggregates are computed over every match
+	rows, agg, err := db.Query(map[string]interface{}{"type": "order"}, aggregates, 1, 0, "orders", nil)
+	if err != nil {
+		t.Errorf("BadgerStore.Query() error = %v", err)
+		return
+	}
+	assert.NotNil(t, rows, "rows were empty")
+	assert.Equal(t, 100.0, agg["revenue"].(indexer.MetricResult).Value)
+	assert.Equal(t, 25.0, agg["average"].(indexer.MetricResult).Value)
+	assert.Equal(t, 10.0, agg["smallest"].(indexer.MetricResult).Value)
+	assert.Equal(t, 40.0, agg["largest"].(indexer.MetricResult).Value)
+	assert.Equal(t, 3.0, agg["customers"].(indexer.MetricResult).Value)
+
+	groups := agg["byCustomer"].(indexer.GroupByResult)
+	assert.Len(t, groups.Buckets, 2)
+	assert.Equal(t, "ada", groups.Buckets[0].Key)
+	assert.Equal(t, 2, groups.Buckets[0].Count)
+	assert.Equal(t, 40.0, groups.Buckets[0].Metrics["revenue"].Value)
+
+	firstDay := agg["firstDay"].(gostore.Match).DateRange.(search.DateRangeFacets)
+	assert.Len(t, firstDay, 1)
+	assert.Equal(t, 2, firstDay[0].Count)
+
+	perDay := agg["perDay"].(gostore.Match).DateRange.(search.DateRangeFacets)
+	counts := map[string]int{}
+	for _, d := range perDay {
+		counts[d.Name] = d.Count
+	}
+	assert.Equal(t, map[string]int{
+		"2020-01-01T00:00:00Z": 2,
+		"2020-01-02T00:00:00Z": 1,
+		"2020-01-04T00:00:00Z": 1,
+	}, counts)
+}
+
+func TestBadgerStore_QueryOrdersPerDay(t *testing.T) {
+	db := createDB("QueryOrdersPerDay")
+	defer removeDB("QueryOrdersPerDay", db)
+	db.CreateTable("orders", nil)
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, daysAgo := range []int{0, 0, 1, 5, 29, 31} {
+		key := gostore.NewObjectId().String()
+		db.Save(key, "orders", map[string]interface{}{
+			"id":      key,
+			"type":    "order",
+			"created": today.AddDate(0, 0, -daysAgo).Add(time.Hour).Format(time.RFC3339),
+		})
+	}
+	// the last 30 days including today
+	aggregates := map[string]interface{}{
+		"dateHistogram": map[string]interface{}{
+			"ordersPerDay": map[string]interface{}{
+				"field":    "created",
+				"interval": "day",
+				"start":    today.AddDate(0, 0, -29).Format(time.RFC3339),
+				"end":      today.AddDate(0, 0, 1).Format(time.RFC3339),
+			},
+		},
+	}
+	_, agg, err := db.Query(map[string]interface{}{"type": "order"}, aggregates, 10, 0, "orders", nil)
+	if err != nil {
+		t.Errorf("BadgerStore.Query() error = %v", err)
+		return
+	}
+	perDay := agg["ordersPerDay"].(gostore.Match).DateRange.(search.DateRangeFacets)
+	counts := map[string]int{}
+	for _, d := range perDay {
+		counts[d.Name] = d.Count
+	}
+	day := func(daysAgo int) string {
+		return today.AddDate(0, 0, -daysAgo).Format(time.RFC3339)
+	}
+	assert.Equal(t, map[string]int{day(0): 2, day(1): 1, day(5): 1, day(29): 1}, counts)
+}
+
+func TestBadgerStore_Suggest(t *testing.T) {
+	name := "Suggest"
+	testDbPath := filepath.Join(rootPath, name)
+	os.Mkdir(testDbPath, 0777)
+	os.RemoveAll(filepath.Join(testDbPath, "/db"))
+	indexMapping := bleve.NewIndexMapping()
+	if err := indexer.AddSuggestAnalyzer(indexMapping); err != nil {
+		t.Fatal(err)
+	}
+	dataFieldMapping := bleve.NewDocumentMapping()
+	indexer.AddSuggestField(dataFieldMapping, "name")
+	indexMapping.DefaultMapping.AddSubDocumentMapping("data", dataFieldMapping)
+	db, err := NewWithIndex(testDbPath, "memory", indexMapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.CreateTable("people", nil)
+	db.CreateTable("others", nil)
+	for _, p := range []map[string]interface{}{
+		{"name": "Osiloke Emoekpere", "city": "Lagos"},
+		{"name": "Osiloke Emoekpere", "city": "Lagos"},
+		{"name": "Oduffa Emoekpere", "city": "Lokoja"},
+		{"name": "Emike Emoekpere", "city": "Abuja"},
+	} {
+		key := gostore.NewObjectId().String()
+		p["id"] = key
+		db.Save(key, "people", p)
+	}
+	key := gostore.NewObjectId().String()
+	db.Save(key, "others", map[string]interface{}{"id": key, "name": "Osas", "city": "Lekki"})
+
+	suggestions, err := db.Suggest("people", "name", "os", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []indexer.Suggestion{{Term: "Osiloke Emoekpere", Count: 2}}, suggestions)
+
+	suggestions, err = db.Suggest("people", "name", "O", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, []indexer.Suggestion{
+		{Term: "Osiloke Emoekpere", Count: 2},
+		{Term: "Oduffa Emoekpere", Count: 1},
+	}, suggestions)
+
+	suggestions, err = db.Suggest("people", "city", "L", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []indexer.Suggestion{{Term: "lagos", Count: 2}}, suggestions)
+
+	// completions of the store are kept when another store has more of them
+	for i := 0; i < 150; i++ {
+		key := gostore.NewObjectId().String()
+		db.Save(key, "others", map[string]interface{}{"id": key, "city": fmt.Sprintf("l%03d", i)})
+	}
+	suggestions, err = db.Suggest("people", "city", "L", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []indexer.Suggestion{
+		{Term: "lagos", Count: 2},
+		{Term: "lokoja", Count: 1},
+	}, suggestions)
+}
+
+func TestNewWithIndexConfig(t *testing.T) {
+	name := "IndexConfig"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	os.Mkdir(testDbPath, 0777)
+	configPath := filepath.Join(testDbPath, "index.json")
+	os.WriteFile(configPath, []byte(`{"tables": {"products": {"fields": {
+		"sku": {"type": "keyword"},
+		"title": {"type": "text", "analyzer": "en"}
+	}}}}`), 0666)
+	config, err := indexer.LoadIndexConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := NewWithIndexConfig(testDbPath, "memory", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.CreateTable("products", nil)
+	key := gostore.NewObjectId().String()
+	db.Save(key, "products", map[string]interface{}{"id": key, "sku": "AB-1", "title": "Running shoes"})
+
+	var dst map[string]interface{}
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"title": "run"}}, "products", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, key, dst["id"])
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"sku": "AB-1"}}, "products", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, key, dst["id"])
+}
+
+func TestBadgerStore_Reindex(t *testing.T) {
+	name := "Reindex"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.CreateTable("fruits", nil)
+	for _, fruit := range []string{"apple", "banana", "cherry"} {
+		db.Save(fruit, "fruits", map[string]interface{}{"id": fruit, "name": fruit})
+	}
+	db.Close()
+
+	// a different index type is rebuilt in the background while the old index serves
+	db, err = NewWithIndex(testDbPath, "moss-scorch", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	assert.Eventually(t, func() bool {
+		init, _, _ := readIndexInit(testDbPath)
+		return init == "moss-scorch" && !db.ReindexProgress().Running
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(3), db.ReindexProgress().Indexed)
+	_, err = os.Stat(filepath.Join(testDbPath, "db.index"))
+	assert.True(t, os.IsNotExist(err))
+
+	var dst map[string]interface{}
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "banana"}}, "fruits", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "banana", dst["id"])
+
+	// an explicit reindex moves the index to a new directory
+	_, before, _ := readIndexInit(testDbPath)
+	assert.Nil(t, db.Reindex(context.Background()))
+	_, after, _ := readIndexInit(testDbPath)
+	assert.NotEqual(t, before, after)
+	_, err = os.Stat(filepath.Join(testDbPath, before))
+	assert.True(t, os.IsNotExist(err))
+	db.Save("date", "fruits", map[string]interface{}{"id": "date", "name": "date"})
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "date"}}, "fruits", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "date", dst["id"])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, db.Reindex(ctx), context.Canceled)
+	_, current, _ := readIndexInit(testDbPath)
+	assert.Equal(t, after, current)
+}
+
+func TestNewWithScorchIndex(t *testing.T) {
+	name := "ScorchIndex"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithScorchIndex(testDbPath, dynamicMapping(), indexer.ScorchOptions{PersisterNapTimeMSec: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.CreateTable("fruits", nil)
+	db.Save("apple", "fruits", map[string]interface{}{"id": "apple", "name": "apple"})
+
+	init, dir, _ := readIndexInit(testDbPath)
+	assert.Equal(t, "scorch", init)
+	assert.Equal(t, "scorch_db.index", dir)
+	var dst map[string]interface{}
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "apple"}}, "fruits", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "apple", dst["id"])
+}
+
+func TestBadgerStore_AsyncIndex(t *testing.T) {
+	name := "AsyncIndex"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.EnableAsyncIndex(AsyncIndexOptions{BatchSize: 2, MaxDepth: 2}))
+	db.CreateTable("fruits", nil)
+	for _, fruit := range []string{"apple", "banana", "cherry", "date", "elderberry"} {
+		_, err := db.Save(fruit, "fruits", map[string]interface{}{"id": fruit, "name": fruit})
+		assert.Nil(t, err)
+	}
+	_, err = db.BatchInsert([]interface{}{
+		map[string]interface{}{"id": "fig", "name": "fig"},
+		map[string]interface{}{"id": "grape", "name": "grape"},
+	}, "fruits", nil)
+	assert.Nil(t, err)
+	version := db.IndexVersion()
+	assert.Equal(t, uint64(7), version)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.Nil(t, db.WaitForIndex(ctx, version))
+	stats := db.IndexQueueStats()
+	assert.Equal(t, 0, stats.Depth)
+	assert.Equal(t, uint64(7), stats.Indexed)
+	assert.Equal(t, 2, stats.MaxDepth)
+
+	var dst map[string]interface{}
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "grape"}}, "fruits", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "grape", dst["id"])
+
+	// queued operations are not returned as rows
+	rows, err := db.All(100, 0, "fruits")
+	assert.Nil(t, err)
+	count := 0
+	for {
+		var row map[string]interface{}
+		ok, err := rows.Next(&row)
+		if !ok || err != nil {
+			break
+		}
+		count++
+	}
+	rows.Close()
+	assert.Equal(t, 7, count)
+
+	// operations queued before a restart are indexed when async indexing is enabled again
+	db.queue.stop()
+	_, err = db.Save("kiwi", "fruits", map[string]interface{}{"id": "kiwi", "name": "kiwi"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, db.IndexQueueStats().Depth)
+	db.queue = nil
+	db.Close()
+
+	db, err = NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { removeDB(name, db) }()
+	assert.Nil(t, db.EnableAsyncIndex(AsyncIndexOptions{}))
+	assert.Nil(t, db.WaitForIndex(ctx, 0))
+	err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "kiwi"}}, "fruits", &dst, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "kiwi", dst["id"])
+	assert.Equal(t, 0, db.IndexQueueStats().Depth)
+	assert.Equal(t, uint64(8), db.IndexVersion())
+
+	// versions continue after a restart with an empty queue
+	db.Close()
+	db, err = NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, db.EnableAsyncIndex(AsyncIndexOptions{}))
+	assert.Equal(t, uint64(8), db.IndexVersion())
+	assert.Nil(t, db.WaitForIndex(ctx, 8))
+
+	// writes in transactions are queued when they are committed
+	tx := db.UpdateTransaction()
+	assert.Nil(t, db.SaveTX("lemon", "fruits", map[string]interface{}{"id": "lemon", "name": "lemon"}, tx))
+	keys, err := db.BatchInsertTX([]interface{}{map[string]interface{}{"id": "mango", "name": "mango"}}, "fruits", nil, tx)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"mango"}, keys)
+	assert.Equal(t, uint64(10), db.IndexVersion())
+	assert.Nil(t, db.SaveWithGeoTX("nectarine", "fruits", map[string]interface{}{"id": "nectarine", "name": "nectarine"}, "", tx))
+	assert.Equal(t, uint64(10), db.IndexVersion())
+	assert.Nil(t, db.FinishTransaction(tx))
+	assert.Equal(t, uint64(11), db.IndexVersion())
+	assert.Nil(t, db.WaitForIndex(ctx, 0))
+	for _, fruit := range []string{"lemon", "mango", "nectarine"} {
+		err = db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": fruit}}, "fruits", &dst, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, fruit, dst["id"])
+	}
+}
+
+func TestBadgerStore_MultiQuery(t *testing.T) {
+	name := "MultiQuery"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "memory", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.Save("p1", "products", map[string]interface{}{"id": "p1", "name": "acme widget"})
+	db.Save("p2", "products", map[string]interface{}{"id": "p2", "name": "plain widget"})
+	db.Save("c1", "customers", map[string]interface{}{"id": "c1", "name": "acme corp"})
+	db.Save("o1", "orders", map[string]interface{}{"id": "o1", "name": "acme order"})
+	db.Save("x1", "products", map[string]interface{}{"id": "x1", "name": "old widget"})
+	db.Save("x1", "customers", map[string]interface{}{"id": "x1", "name": "acme moved"})
+
+	rows, err := db.MultiQuery([]string{"products", "customers"}, map[string]interface{}{"name": "acme"}, 10, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	found := map[string]string{}
+	for {
+		var dst map[string]interface{}
+		ok, _ := rows.Next(&dst)
+		if !ok {
+			break
+		}
+		found[dst["id"].(string)] = dst[indexer.BucketField].(string)
+		assert.Equal(t, rows.(*SyncIndexRows).Store(), dst[indexer.BucketField])
+	}
+	assert.Equal(t, map[string]string{"p1": "products", "c1": "customers", "x1": "customers"}, found)
+
+	_, err = db.MultiQuery([]string{"orders"}, map[string]interface{}{"name": "widget"}, 10, 0, nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+}
+
+func TestBadgerStore_NamedIndexes(t *testing.T) {
+	name := "NamedIndexes"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Save("a1", "articles", map[string]interface{}{"id": "a1", "title": "running shoes"})
+	db.Save("s1", "sessions", map[string]interface{}{"id": "s1", "token": "abc"})
+	db.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+
+	text := &indexer.IndexConfig{Tables: map[string]indexer.TableConfig{
+		"articles": {Fields: map[string]indexer.FieldConfig{"title": {Type: "text", Analyzer: "en"}}},
+	}}
+	assert.Nil(t, db.CreateIndex("text", NamedIndex{Type: "moss-scorch", Config: text}))
+	assert.Nil(t, db.CreateIndex("keyword", NamedIndex{Type: "memory"}))
+	assert.Equal(t, ErrIndexExists, db.CreateIndex("text", NamedIndex{}))
+	assert.Nil(t, db.RouteTable("articles", "text"))
+	assert.Nil(t, db.RouteTable("sessions", "keyword"))
+	assert.Equal(t, indexer.ErrUnknownIndex, db.RouteTable("users", "missing"))
+	db.Save("a2", "articles", map[string]interface{}{"id": "a2", "title": "runs daily"})
+
+	count := func(ix indexer.Indexer, store string) uint64 {
+		res, err := ix.Query(indexer.GetQueryString(store, nil))
+		assert.Nil(t, err)
+		return res.Total
+	}
+	textIndex, _ := db.reindex.router.Named("text")
+	assert.Equal(t, uint64(2), count(textIndex, "articles"))
+	assert.Equal(t, uint64(0), count(db.reindex.swap, "articles"))
+	assert.Equal(t, uint64(0), count(db.reindex.swap, "sessions"))
+	assert.Equal(t, uint64(1), count(db.reindex.swap, "users"))
+
+	// the english analyzer of the text index stems both titles
+	rows, err := db.FilterGetAll(map[string]interface{}{"q": map[string]interface{}{"title": "run"}}, 10, 0, "articles", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rows.(*SyncIndexRows).Count())
+
+	rows, err = db.MultiQuery([]string{"articles", "sessions", "users"}, nil, 10, 0, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, rows.(*SyncIndexRows).Count())
+	db.Close()
+
+	db, err = NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	assert.Equal(t, map[string]string{"articles": "text", "sessions": "keyword"}, db.IndexRoutes())
+	var dst map[string]interface{}
+	assert.Nil(t, db.FilterGet(map[string]interface{}{"q": map[string]interface{}{"token": "abc"}}, "sessions", &dst, nil))
+	assert.Equal(t, "s1", dst["id"])
+
+	// routing back to the default index moves the rows
+	assert.Nil(t, db.RouteTable("articles", ""))
+	textIndex, _ = db.reindex.router.Named("text")
+	assert.Equal(t, uint64(0), count(textIndex, "articles"))
+	assert.Equal(t, uint64(2), count(db.reindex.swap, "articles"))
+}
+
+func TestBadgerStore_SimilarTo(t *testing.T) {
+	name := "SimilarTo"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "memory", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.Save("1", "articles", map[string]interface{}{"id": "1", "title": "golang search engine"})
+	db.Save("2", "articles", map[string]interface{}{"id": "2", "title": "building a search engine in golang"})
+	db.Save("3", "articles", map[string]interface{}{"id": "3", "title": "search engine tuning"})
+	db.Save("4", "articles", map[string]interface{}{"id": "4", "title": "baking bread"})
+
+	rows, err := db.SimilarTo("articles", "1", []string{"title"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for {
+		var dst map[string]interface{}
+		ok, _ := rows.Next(&dst)
+		if !ok {
+			break
+		}
+		ids = append(ids, dst["id"].(string))
+	}
+	assert.Equal(t, []string{"2", "3"}, ids)
+
+	_, err = db.SimilarTo("articles", "missing", []string{"title"}, 10)
+	assert.Equal(t, gostore.ErrNotFound, err)
+}
+
+func TestBadgerStore_GeoBoundingBoxAndPolygonQuery(t *testing.T) {
+	db := createGeoDB("GeoShapeQuery", "location", "drivers", "bucket")
+	defer removeDB("GeoShapeQuery", db)
+	drivers := []struct {
+		id, status string
+		lon, lat   float64
+	}{
+		{"fremont", "free", -121.989, 37.5483},
+		{"sunnyvale", "busy", -122.03, 37.3775},
+		{"washington", "free", -77.0272, 38.8999},
+	}
+	for _, d := range drivers {
+		_, err := db.SaveWithGeo(d.id, "drivers", map[string]interface{}{
+			"id":     d.id,
+			"status": d.status,
+			"home": map[string]interface{}{
+				"location": map[string]interface{}{"lat": d.lat, "lon": d.lon},
+			},
+		}, "home.location")
+		assert.Nil(t, err)
+	}
+	ids := func(rows gostore.ObjectRows) []string {
+		found := []string{}
+		for {
+			var dst map[string]interface{}
+			ok, _ := rows.Next(&dst)
+			if !ok {
+				break
+			}
+			found = append(found, dst["id"].(string))
+		}
+		sort.Strings(found)
+		return found
+	}
+
+	// the bay area
+	rows, err := db.GeoBoundingBoxQuery(-122.5, 38.0, -121.5, 37.0, nil, 10, 0, "drivers", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fremont", "sunnyvale"}, ids(rows))
+	rows, err = db.GeoBoundingBoxQuery(-122.5, 38.0, -121.5, 37.0, map[string]interface{}{"status": "free"}, 10, 0, "drivers", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"fremont"}, ids(rows))
+	// rows are ordered by their distance from the center of the box
+	opts := gostore.DefaultObjectStoreOptions{OrderBy: []string{"-_distance"}}
+	rows, err = db.GeoBoundingBoxQuery(-122.5, 38.0, -121.5, 37.0, nil, 10, 0, "drivers", opts)
+	if assert.Nil(t, err) {
+		var dst map[string]interface{}
+		ok, _ := rows.Next(&dst)
+		assert.True(t, ok)
+		assert.Equal(t, "sunnyvale", dst["id"])
+		assert.InDelta(t, 13875, dst[indexer.DistanceField], 10)
+	}
+
+	// a delivery zone around washington
+	zone := []geo.Point{{Lon: -77.2, Lat: 39.0}, {Lon: -76.9, Lat: 39.0}, {Lon: -76.9, Lat: 38.8}, {Lon: -77.2, Lat: 38.8}}
+	rows, err = db.GeoPolygonQuery(zone, map[string]interface{}{"status": "free"}, 10, 0, "drivers", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"washington"}, ids(rows))
+	_, err = db.GeoPolygonQuery(zone, map[string]interface{}{"status": "busy"}, 10, 0, "drivers", nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+	_, err = db.GeoPolygonQuery(zone[:2], nil, 10, 0, "drivers", nil)
+	assert.Equal(t, indexer.ErrInvalidPolygon, err)
+}
+
+func TestBadgerStore_GeoQueryDistance(t *testing.T) {
+	db := createGeoDB("GeoQueryDistance", "location", "drivers", "bucket")
+	defer removeDB("GeoQueryDistance", db)
+	drivers := []struct {
+		id       string
+		lon, lat float64
+	}{
+		{"fremont", -121.989, 37.5483},
+		{"sunnyvale", -122.03, 37.3775},
+		{"sanjose", -121.8863, 37.3382},
+	}
+	for _, d := range drivers {
+		_, err := db.SaveWithGeo(d.id, "drivers", map[string]interface{}{
+			"id":   d.id,
+			"type": "driver",
+			"home": map[string]interface{}{
+				"location": map[string]interface{}{"lat": d.lat, "lon": d.lon},
+			},
+		}, "home.location")
+		assert.Nil(t, err)
+	}
+	type driver struct {
+		ID       string  `json:"id"`
+		Distance float64 `json:"_distance"`
+	}
+	nearest := func(orderBy []string) []driver {
+		var opts gostore.ObjectStoreOptions
+		if orderBy != nil {
+			opts = gostore.DefaultObjectStoreOptions{OrderBy: orderBy}
+		}
+		rows, err := db.GeoQuery(-121.989, 37.5483, "50km", map[string]interface{}{"type": "driver"}, 10, 0, "drivers", opts)
+		assert.Nil(t, err)
+		found := []driver{}
+		for {
+			var dst driver
+			ok, _ := rows.Next(&dst)
+			if !ok {
+				break
+			}
+			found = append(found, dst)
+		}
+		return found
+	}
+
+	found := nearest([]string{"_distance"})
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, "fremont", found[0].ID)
+		assert.Equal(t, "sunnyvale", found[1].ID)
+		assert.Equal(t, "sanjose", found[2].ID)
+		assert.InDelta(t, 0, found[0].Distance, 0.01)
+		assert.InDelta(t, 19.3, found[1].Distance, 0.1)
+		assert.InDelta(t, 25.1, found[2].Distance, 0.1)
+	}
+	found = nearest([]string{"-_distance"})
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, "sanjose", found[0].ID)
+	}
+	// distances are returned with custom sorts
+	found = nearest([]string{"data.id"})
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, "fremont", found[0].ID)
+		assert.Equal(t, "sanjose", found[1].ID)
+		assert.InDelta(t, 25.1, found[1].Distance, 0.1)
+	}
+}
+
+func TestBadgerStore_Backup(t *testing.T) {
+	name := "Backup"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+	db.Save("u2", "users", map[string]interface{}{"id": "u2", "name": "grace"})
+	db.Save("o1", "orders", map[string]interface{}{"id": "o1", "total": 10.0})
+
+	path := filepath.Join(rootPath, "backup.tar.gz")
+	defer os.Remove(path)
+	m, err := db.BackupToFile(path, backup.Options{Gzip: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "badger", m.Store)
+	assert.Equal(t, []string{"orders", "users"}, m.Tables)
+	assert.True(t, m.Version > 0)
+	assert.NotNil(t, m.Index)
+	assert.NotEmpty(t, m.Index.Mapping)
+
+	verified, err := backup.VerifyFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Checksum, verified.Checksum)
+
+	restored, err := NewDBOnly(filepath.Join(rootPath, name, "restored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = backup.Extract(f, func(m *backup.Manifest, data io.Reader) error {
+		return restored.Db.Load(data, 1)
+	})
+	assert.Nil(t, err)
+	var dst map[string]interface{}
+	assert.Nil(t, restored.Get("u2", "users", &dst))
+	assert.Equal(t, "grace", dst["name"])
+
+	// newer entries only
+	db.Save("u3", "users", map[string]interface{}{"id": "u3", "name": "linus"})
+	var buf bytes.Buffer
+	incremental, err := db.Backup(&buf, backup.Options{Since: m.Version})
+	assert.Nil(t, err)
+	assert.True(t, incremental.Version > m.Version)
+	assert.True(t, incremental.Size < m.Size)
+}
+
+func TestBadgerStore_IncrementalBackup(t *testing.T) {
+	name := "IncrementalBackup"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	archive := func(n string) string { return filepath.Join(testDbPath, n+".tar") }
+
+	db.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+	db.Save("u2", "users", map[string]interface{}{"id": "u2", "name": "grace"})
+	watermark, err := db.BackupWatermark()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), watermark)
+	base, err := db.BackupToFile(archive("base"), backup.Options{})
+	assert.Nil(t, err)
+	assert.Empty(t, base.Chain)
+
+	db.Save("u3", "users", map[string]interface{}{"id": "u3", "name": "linus"})
+	watermark, _ = db.BackupWatermark()
+	assert.Equal(t, base.Version, watermark)
+	first, err := db.BackupToFile(archive("first"), backup.Options{Since: watermark})
+	assert.Nil(t, err)
+	assert.Equal(t, []backup.Link{base.Link()}, first.Chain)
+
+	db.Delete("u1", "users")
+	db.Save("u2", "users", map[string]interface{}{"id": "u2", "name": "hopper"})
+	rec := httptest.NewRecorder()
+	db.ServeBackup(rec, httptest.NewRequest("GET", "/?since=last&gzip=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), fmt.Sprintf("badger_%d_", first.Version))
+	assert.Nil(t, os.WriteFile(archive("second"), rec.Body.Bytes(), 0600))
+	second, err := backup.VerifyFile(archive("second"))
+	assert.Nil(t, err)
+	assert.Equal(t, first.Version, second.Since)
+	assert.Equal(t, []backup.Link{base.Link(), first.Link()}, second.Chain)
+
+	rec = httptest.NewRecorder()
+	db.ServeBackup(rec, httptest.NewRequest("GET", "/?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	restore := func(n string, paths ...string) (*BadgerStore, error) {
+		restored, err := NewDBOnly(filepath.Join(testDbPath, n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = restored.RestoreBackups(paths...)
+		return restored, err
+	}
+	restored, err := restore("restored", archive("base"), archive("first"), archive("second"))
+	assert.Nil(t, err)
+	var dst map[string]interface{}
+	assert.Equal(t, gostore.ErrNotFound, restored.Get("u1", "users", &dst))
+	assert.Nil(t, restored.Get("u2", "users", &dst))
+	assert.Equal(t, "hopper", dst["name"])
+	assert.Nil(t, restored.Get("u3", "users", &dst))
+	assert.Equal(t, "linus", dst["name"])
+	watermark, _ = restored.BackupWatermark()
+	assert.Equal(t, second.Version, watermark)
+	restored.Close()
+
+	// the second backup does not continue from the base
+	restored, err = restore("gap", archive("base"), archive("second"))
+	assert.ErrorIs(t, err, backup.ErrChainGap)
+	assert.Equal(t, gostore.ErrNotFound, restored.Get("u1", "users", &dst))
+	restored.Close()
+	restored, err = restore("incremental", archive("first"))
+	assert.ErrorIs(t, err, backup.ErrChainGap)
+	restored.Close()
+}
+
+func TestBadgerStore_Restore(t *testing.T) {
+	name := "Restore"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+	db.Save("u2", "users", map[string]interface{}{"id": "u2", "name": "grace"})
+
+	archivePath := filepath.Join(testDbPath, "backup.tar")
+	_, err = db.BackupToFile(archivePath, backup.Options{})
+	assert.Nil(t, err)
+	rawPath := filepath.Join(testDbPath, "backup.bak")
+	f, err := os.Create(rawPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Db.Backup(f, 0)
+	f.Close()
+	assert.Nil(t, err)
+
+	for _, path := range []string{archivePath, rawPath} {
+		target := filepath.Join(testDbPath, "restored_"+filepath.Base(path))
+		restored, err := NewWithIndex(target, "", dynamicMapping())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, restored.Restore(path))
+		// the restored rows are indexed
+		var dst map[string]interface{}
+		assert.Nil(t, restored.FilterGet(map[string]interface{}{"q": map[string]interface{}{"name": "grace"}}, "users", &dst, nil))
+		assert.Equal(t, "u2", dst["id"])
+		restored.Close()
+	}
+}
+
+func TestBadgerStore_BackupScheduler(t *testing.T) {
+	name := "BackupScheduler"
+	testDbPath := filepath.Join(rootPath, name)
+	os.RemoveAll(testDbPath)
+	db, err := NewWithIndex(testDbPath, "", dynamicMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer removeDB(name, db)
+	db.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+
+	dest, err := backup.NewLocalDestination(filepath.Join(testDbPath, "backups"))
+	assert.Nil(t, err)
+	s := backup.NewScheduler(db, dest, backup.Every(time.Hour), backup.SchedulerGzip())
+	m, err := s.RunNow()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, m.Tables)
+	names, err := dest.List()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{s.Status().LastArchive}, names)
+}
+
+func TestBadgerStore_Snapshot(t *testing.T) {
+	db := createDB("Snapshot")
+	defer removeDB("Snapshot", db)
+	db.CreateTable("data", nil)
+	keys := []string{}
+	for _, name := range []string{"osiloke", "emike", "oduffa", "tony"} {
+		key := gostore.NewObjectId().String()
+		_, err := db.Save(key, "data", map[string]interface{}{"id": key, "name": name, "type": "person"})
+		assert.Nil(t, err)
+		keys = append(keys, key)
+	}
+	snap, err := db.Snapshot(SnapshotTTL(0))
+	assert.Nil(t, err)
+	assert.True(t, snap.Expires().IsZero())
+
+	// rows written after the snapshot are left out of its hits
+	added := gostore.NewObjectId().String()
+	_, err = db.Save(added, "data", map[string]interface{}{"id": added, "name": "new", "type": "person"})
+	assert.Nil(t, err)
+
+	query := map[string]interface{}{"type": "person"}
+	page := func(count, skip int) map[string]string {
+		rows, _, err := snap.Query(query, nil, count, skip, "data", nil)
+		assert.Nil(t, err)
+		defer rows.Close()
+		assert.Equal(t, 4, rows.(*SyncIndexRows).Count())
+		names := map[string]string{}
+		for {
+			var dst map[string]interface{}
+			if ok, _ := rows.Next(&dst); !ok {
+				break
+			}
+			names[dst["id"].(string)] = dst["name"].(string)
+		}
+		return names
+	}
+	names := page(2, 0)
+	assert.Len(t, names, 2)
+
+	// writes between pages neither shift nor change the pages
+	_, err = db.Save(gostore.NewObjectId().String(), "data", map[string]interface{}{"name": "newer", "type": "person"})
+	assert.Nil(t, err)
+	_, err = db.Save(keys[0], "data", map[string]interface{}{"id": keys[0], "name": "renamed", "type": "person"})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Delete(keys[1], "data"))
+	for id, name := range page(2, 2) {
+		names[id] = name
+	}
+	assert.Equal(t, map[string]string{keys[0]: "osiloke", keys[1]: "emike", keys[2]: "oduffa", keys[3]: "tony"}, names)
+
+	var dst map[string]interface{}
+	assert.Nil(t, snap.Get(keys[0], "data", &dst))
+	assert.Equal(t, "osiloke", dst["name"])
+	assert.Nil(t, snap.Get(keys[1], "data", &dst))
+	assert.Equal(t, gostore.ErrNotFound, snap.Get(added, "data", &dst))
+	_, _, err = snap.Query(map[string]interface{}{"name": "new"}, nil, 10, 0, "data", nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+	// the row written after the snapshot is still indexed
+	_, _, err = db.Query(map[string]interface{}{"name": "new"}, nil, 10, 0, "data", nil)
+	assert.Nil(t, err)
+
+	limited, err := db.Snapshot(SnapshotTTL(0), SnapshotMaxHits(2))
+	assert.Nil(t, err)
+	_, _, err = limited.Query(query, nil, 2, 0, "data", nil)
+	assert.Equal(t, ErrSnapshotTooManyHits, err)
+	limited.Close()
+
+	rows, err := snap.All(10, 0, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, rows.(*TransactionRows).Count())
+	rows, err = snap.All(2, 1, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rows.(*TransactionRows).Count())
+	rows, err = snap.Since(keys[2], 10, 0, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rows.(*TransactionRows).Count())
+	rows, err = snap.Since(keys[1], 2, 1, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rows.(*TransactionRows).Count())
+	// rows of other stores are not returned
+	_, err = db.Save("other", "other", map[string]interface{}{"id": "other"})
+	assert.Nil(t, err)
+	rows, err = snap.Since(keys[3], 10, 0, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rows.(*TransactionRows).Count())
+	// the store pages All and Since like the snapshot
+	rows, err = db.All(2, 1, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rows.(*TransactionRows).Count())
+	rows, err = db.Since(keys[2], 1, 1, "data")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rows.(*TransactionRows).Count())
+
+	assert.Nil(t, db.Get(keys[0], "data", &dst))
+	assert.Equal(t, "renamed", dst["name"])
+
+	snap.Close()
+	assert.Equal(t, ErrSnapshotClosed, snap.Get(keys[0], "data", &dst))
+	_, _, err = snap.Query(query, nil, 2, 0, "data", nil)
+	assert.Equal(t, ErrSnapshotClosed, err)
+
+	expiring, err := db.Snapshot(SnapshotTTL(50 * time.Millisecond))
+	assert.Nil(t, err)
+	assert.False(t, expiring.Expires().IsZero())
+	assert.Nil(t, expiring.Get(keys[0], "data", &dst))
+	assert.Eventually(t, func() bool {
+		return expiring.Get(keys[0], "data", &dst) == ErrSnapshotClosed
+	}, time.Second, 10*time.Millisecond)
+}
@@ -6,8 +6,9 @@ import (
 	"sync"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
 	"github.com/osiloke/gostore"
-	// "github.com/osiloke/gostore-contrib/indexer"
+	"github.com/osiloke/gostore-contrib/indexer"
 )
 
 type NextItem struct {
@@ -123,9 +124,70 @@ type SyncIndexRows struct {
 	lastError error
 	length    uint64
 	name      string
+	stores    []string
+	store     string
 	result    *bleve.SearchResult
 	bs        *BadgerStore
 	ci        uint64
+	distance  func(*search.DocumentMatch) (float64, bool)
+	// read gets rows instead of the store, snapshots read rows at their timestamp
+	read func(key, store string) ([][]byte, error)
+}
+
+// get retrieves the row of a hit from the store it was indexed in
+func (s *SyncIndexRows) get(h *search.DocumentMatch) ([][]byte, error) {
+	read := s.bs._Get
+	if s.read != nil {
+		read = s.read
+	}
+	if len(s.stores) == 0 {
+		s.store = s.name
+		return read(h.ID, s.name)
+	}
+	if bucket, ok := h.Fields["bucket"].(string); ok {
+		s.store = bucket
+		return read(h.ID, bucket)
+	}
+	// mappings which do not store the bucket can only be read from the one
+	// store the row is in
+	var found [][]byte
+	s.store = ""
+	for _, store := range s.stores {
+		row, err := read(h.ID, store)
+		if err == gostore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%s is in %s and %s, store the bucket field to query several stores", h.ID, s.store, store)
+		}
+		found, s.store = row, store
+	}
+	if found == nil {
+		return nil, gostore.ErrNotFound
+	}
+	return found, nil
+}
+
+// decorate adds the distance of the hit to its row when rows are from a geo
+// query and the store of the row when rows are from several stores
+func (s *SyncIndexRows) decorate(h *search.DocumentMatch, row [][]byte) {
+	if len(s.stores) > 0 {
+		row[1] = indexer.WithField(row[1], indexer.BucketField, s.store)
+	}
+	if s.distance == nil {
+		return
+	}
+	if d, ok := s.distance(h); ok {
+		row[1] = indexer.WithField(row[1], indexer.DistanceField, d)
+	}
+}
+
+// Store returns the store of the last row retrieved
+func (s *SyncIndexRows) Store() string {
+	return s.store
 }
 
 // Next get next item
@@ -134,14 +196,15 @@ func (s *SyncIndexRows) Next(dst interface{}) (bool, error) {
 	if int(s.ci) != s.result.Hits.Len() {
 		h := s.result.Hits[s.ci]
 		logger.Info("next row", "key", h.ID, "store", s.name)
-		row, err := s.bs._Get(h.ID, s.name)
+		row, err := s.get(h)
 		if err == nil {
+			s.decorate(h, row)
 			err = json.Unmarshal(row[1], dst)
 			if err == nil {
 				s.ci++
 				return true, nil
 			}
-			if err == gostore.ErrNotFound {
+			if err == gostore.ErrNotFound && s.read == nil {
 				//not found so remove from indexer
 				s.bs.Indexer.UnIndexDocument(h.ID)
 			} else {
@@ -159,13 +222,14 @@ func (s *SyncIndexRows) NextRaw() ([]byte, bool) {
 	if int(s.ci) != s.result.Hits.Len() {
 		h := s.result.Hits[s.ci]
 		logger.Info("NEXT KEY", "id", h.ID, "store", s.name)
-		row, err := s.bs._Get(h.ID, s.name)
+		row, err := s.get(h)
 		if err == nil {
 			s.ci++
+			s.decorate(h, row)
 			return row[1], true
 		}
-		if err == gostore.ErrNotFound {
-			//not found so remove from indexer
+		if err == gostore.ErrNotFound && s.read == nil {
+			//not found so remove from indexer, snapshots do not see newer rows
 			s.bs.Indexer.UnIndexDocument(h.ID)
 		} else {
 			logger.Warn(err.Error())
@@ -0,0 +1,226 @@
+package badger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	badgerdb "github.com/dgraph-io/badger"
+	"github.com/osiloke/gostore-contrib/indexer"
+)
+
+// ErrIndexExists returned when creating a named index which already exists
+var ErrIndexExists = errors.New("index already exists")
+
+// indexesFile persists the named indexes and table routes of a store
+const indexesFile = ".indexes"
+
+// namedIndexDir directory in the store root holding the named indexes
+const namedIndexDir = "indexes"
+
+// NamedIndex configures a named index which tables can be routed to.
+// Type is one of the index types accepted by NewWithIndex
+type NamedIndex struct {
+	Type   string                `json:"type"`
+	Config *indexer.IndexConfig  `json:"config,omitempty"`
+	Scorch indexer.ScorchOptions `json:"scorch,omitempty"`
+}
+
+// indexesConfig the persisted named indexes and the table routed to each
+type indexesConfig struct {
+	Indexes map[string]NamedIndex `json:"indexes"`
+	Routes  map[string]string     `json:"routes"`
+}
+
+func readIndexesConfig(root string) (indexesConfig, error) {
+	config := indexesConfig{Indexes: map[string]NamedIndex{}, Routes: map[string]string{}}
+	dat, err := os.ReadFile(filepath.Join(root, indexesFile))
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(dat, &config); err != nil {
+		return config, err
+	}
+	if config.Indexes == nil {
+		config.Indexes = map[string]NamedIndex{}
+	}
+	if config.Routes == nil {
+		config.Routes = map[string]string{}
+	}
+	return config, nil
+}
+
+func writeIndexesConfig(root string, config indexesConfig) error {
+	dat, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(root, indexesFile), dat, os.ModePerm)
+}
+
+// openNamedIndex opens or creates a named index, created is true when the
+// index has no documents yet
+func openNamedIndex(root, name string, named NamedIndex) (ix indexer.Indexer, created bool, err error) {
+	config := named.Config
+	if config == nil {
+		config = &indexer.IndexConfig{}
+	}
+	indexMapping, err := config.Mapping()
+	if err != nil {
+		return nil, false, err
+	}
+	dir := filepath.Join(root, namedIndexDir)
+	if err := os.MkdirAll(dir, os.FileMode(0755)); err != nil {
+		return nil, false, err
+	}
+	indexPath := filepath.Join(dir, name+".index")
+	if _, err := os.Stat(indexPath); os.IsNotExist(err) || named.Type == "memory" {
+		created = true
+	}
+	ix = openIndex(named.Type, indexPath, indexMapping, named.Scorch)
+	if ix == nil {
+		return nil, false, fmt.Errorf("unable to create %s index at %s", named.Type, indexPath)
+	}
+	return ix, created, nil
+}
+
+// openIndexes opens the persisted named indexes and routes their tables,
+// tables of an index which was just created are reindexed into it
+func (s *BadgerStore) openIndexes() error {
+	r := s.reindex
+	config, err := readIndexesConfig(r.root)
+	if err != nil {
+		return err
+	}
+	r.indexes = config
+	created := map[string]bool{}
+	for name, named := range config.Indexes {
+		ix, isNew, err := openNamedIndex(r.root, name, named)
+		if err != nil {
+			return err
+		}
+		r.router.AddIndex(name, ix)
+		created[name] = isNew
+	}
+	for table, name := range config.Routes {
+		if err := r.router.AddRoute(table, name); err != nil {
+			return fmt.Errorf("%w: %s routed to %s", err, table, name)
+		}
+		if created[name] {
+			if err := s.reIndexTable(table); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reIndexTable indexes every row of a table into the index it is routed to
+func (s *BadgerStore) reIndexTable(table string) error {
+	var target indexer.Indexer = s.reindex.router.Route(table)
+	if g, ok := s.Indexer.(*indexer.GeoIndexer); ok {
+		target = &indexer.GeoIndexer{Field: g.Field, Indexer: target}
+	}
+	report, err := indexer.ReIndex(s, target, indexer.ReIndexStores(table))
+	if err != nil {
+		return err
+	}
+	if len(report.Failures) > 0 {
+		logger.Warn("some documents failed to reindex", "table", table, "indexed", report.Indexed, "failed", len(report.Failures))
+	}
+	return nil
+}
+
+// unIndexTable removes every row of a table from an index
+func (s *BadgerStore) unIndexTable(table string, ix indexer.Indexer) error {
+	b := ix.BatchIndex()
+	prefix := []byte(s.keyForTableId(table, ""))
+	err := s.Db.View(func(txn *badgerdb.Txn) error {
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			b.Delete(string(it.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return ix.Batch(b)
+}
+
+// CreateIndex creates a named index which tables can be routed to with
+// RouteTable, the index is reopened with the store
+func (s *BadgerStore) CreateIndex(name string, named NamedIndex) error {
+	if s.reindex == nil {
+		return ErrReindexNotSupported
+	}
+	if name == "" || filepath.Base(name) != name {
+		return fmt.Errorf("invalid index name %q", name)
+	}
+	r := s.reindex
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.indexes.Indexes[name]; ok {
+		return ErrIndexExists
+	}
+	ix, _, err := openNamedIndex(r.root, name, named)
+	if err != nil {
+		return err
+	}
+	r.indexes.Indexes[name] = named
+	if err := writeIndexesConfig(r.root, r.indexes); err != nil {
+		delete(r.indexes.Indexes, name)
+		ix.Close()
+		return err
+	}
+	r.router.AddIndex(name, ix)
+	logger.Info("created named index", "name", name, "type", named.Type)
+	return nil
+}
+
+// RouteTable routes a table to a named index, an empty name routes it back to
+// the default index. Rows of the table are moved to the new index
+func (s *BadgerStore) RouteTable(table, name string) error {
+	if s.reindex == nil {
+		return ErrReindexNotSupported
+	}
+	r := s.reindex
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous := r.router.Route(table)
+	if name == "" {
+		r.router.RemoveRoute(table)
+		delete(r.indexes.Routes, table)
+	} else {
+		if err := r.router.AddRoute(table, name); err != nil {
+			return err
+		}
+		r.indexes.Routes[table] = name
+	}
+	if err := writeIndexesConfig(r.root, r.indexes); err != nil {
+		return err
+	}
+	if r.router.Route(table) == previous {
+		return nil
+	}
+	if err := s.reIndexTable(table); err != nil {
+		return err
+	}
+	logger.Info("routed table", "table", table, "index", name)
+	return s.unIndexTable(table, previous)
+}
+
+// IndexRoutes returns the named index each routed table is indexed in
+func (s *BadgerStore) IndexRoutes() map[string]string {
+	if s.reindex == nil {
+		return map[string]string{}
+	}
+	return s.reindex.router.Routes()
+}
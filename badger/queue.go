@@ -0,0 +1,490 @@
+package badger
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	badgerdb "github.com/dgraph-io/badger"
+	"github.com/osiloke/gostore"
+	"github.com/osiloke/gostore-contrib/indexer"
+)
+
+const (
+	// DefaultIndexQueueBatchSize number of queued operations indexed per batch
+	DefaultIndexQueueBatchSize = 500
+	// DefaultIndexQueueMaxDepth number of queued operations before writes block
+	DefaultIndexQueueMaxDepth = 10000
+
+	indexQueuePrefix = "q$"
+	indexRetryDelay  = time.Second
+	// indexVersionKey holds the last allocated version so versions keep
+	// increasing after a restart with an empty queue
+	indexVersionKey = "_indexqueue"
+)
+
+// AsyncIndexOptions configures asynchronous indexing
+type AsyncIndexOptions struct {
+	// BatchSize number of queued operations indexed per batch
+	BatchSize int
+	// MaxDepth writes block while this many operations are waiting to be indexed
+	MaxDepth int
+}
+
+// IndexQueueStats metrics of the asynchronous index queue
+type IndexQueueStats struct {
+	Depth    int    `json:"depth"`
+	Queued   uint64 `json:"queued"`
+	Indexed  uint64 `json:"indexed"`
+	Failed   uint64 `json:"failed"`
+	Batches  uint64 `json:"batches"`
+	Blocked  uint64 `json:"blocked"`
+	MaxDepth int    `json:"maxDepth"`
+}
+
+// indexOp a queued index operation, documents are read from the store when
+// the operation is indexed so the latest value is always used
+type indexOp struct {
+	Delete   bool        `json:"delete,omitempty"`
+	ID       string      `json:"id"`
+	Store    string      `json:"store"`
+	Location interface{} `json:"location,omitempty"`
+}
+
+// indexQueue a durable queue of index operations stored alongside the data.
+// Writes are serialized so versions are committed in order and indexed is
+// a watermark below which every operation has been indexed
+type indexQueue struct {
+	s    *BadgerStore
+	opts AsyncIndexOptions
+
+	writeMu sync.Mutex
+	// pending versions allocated within the current write
+	pending []uint64
+
+	mu sync.Mutex
+	// version last allocated version, committed last version written to the store
+	version   uint64
+	committed uint64
+	indexed   uint64
+	depth     int
+	failed    uint64
+	batches   uint64
+	blocked   uint64
+	advanced  chan struct{}
+
+	notify chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func indexQueueKey(version uint64) []byte {
+	return []byte(fmt.Sprintf("%s%020d", indexQueuePrefix, version))
+}
+
+func newIndexQueue(s *BadgerStore, opts AsyncIndexOptions) (*indexQueue, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultIndexQueueBatchSize
+	}
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = DefaultIndexQueueMaxDepth
+	}
+	q := &indexQueue{
+		s:        s,
+		opts:     opts,
+		advanced: make(chan struct{}),
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	// operations left by a previous run are indexed by the worker
+	prefix := []byte(indexQueuePrefix)
+	var first uint64
+	err := s.Db.View(func(txn *badgerdb.Txn) error {
+		item, err := txn.Get([]byte(indexVersionKey))
+		if err == nil {
+			err = item.Value(func(val []byte) error {
+				if len(val) == 8 {
+					q.version = binary.BigEndian.Uint64(val)
+				}
+				return nil
+			})
+		}
+		if err != nil && err != badgerdb.ErrKeyNotFound {
+			return err
+		}
+		opts := badgerdb.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			v, err := strconv.ParseUint(strings.TrimPrefix(string(it.Item().Key()), indexQueuePrefix), 10, 64)
+			if err != nil {
+				continue
+			}
+			if first == 0 {
+				first = v
+			}
+			if v > q.version {
+				q.version = v
+			}
+			q.depth++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// versions before the first queued operation were indexed by a previous run
+	q.committed, q.indexed = q.version, q.version
+	if first > 0 {
+		q.indexed = first - 1
+	}
+	return q, nil
+}
+
+// update runs a write transaction in which index operations are queued
+func (q *indexQueue) update(fn func(txn *badgerdb.Txn) error) error {
+	return q.commit(nil, fn)
+}
+
+// commit runs fn in txn then commits txn with the index operations fn
+// queued, a nil txn runs fn in a new transaction. Versions are allocated
+// while writes are serialized so they are committed in order
+func (q *indexQueue) commit(txn *badgerdb.Txn, fn func(txn *badgerdb.Txn) error) error {
+	q.waitForRoom()
+	q.writeMu.Lock()
+	defer q.writeMu.Unlock()
+	if txn == nil {
+		txn = q.s.Db.NewTransaction(true)
+		defer txn.Discard()
+	}
+	q.pending = q.pending[:0]
+	err := fn(txn)
+	if err == nil && len(q.pending) > 0 {
+		version := make([]byte, 8)
+		binary.BigEndian.PutUint64(version, q.pending[len(q.pending)-1])
+		err = txn.Set([]byte(indexVersionKey), version)
+	}
+	if err == nil {
+		err = txn.Commit()
+	}
+	if err != nil || len(q.pending) == 0 {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			// allocated versions were not committed
+			q.version = q.pending[0] - 1
+		}
+		q.mu.Unlock()
+		return err
+	}
+	q.mu.Lock()
+	q.depth += len(q.pending)
+	q.committed = q.pending[len(q.pending)-1]
+	q.mu.Unlock()
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// push queues an operation within a transaction started by update
+func (q *indexQueue) push(txn *badgerdb.Txn, op indexOp) error {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	q.version++
+	v := q.version
+	q.mu.Unlock()
+	q.pending = append(q.pending, v)
+	return txn.Set(indexQueueKey(v), data)
+}
+
+// waitForRoom blocks writers while the queue is full
+func (q *indexQueue) waitForRoom() {
+	counted := false
+	for {
+		q.mu.Lock()
+		if q.depth < q.opts.MaxDepth {
+			q.mu.Unlock()
+			return
+		}
+		if !counted {
+			q.blocked++
+			counted = true
+		}
+		advanced := q.advanced
+		q.mu.Unlock()
+		select {
+		case <-advanced:
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// wait blocks until every operation up to version has been indexed
+func (q *indexQueue) wait(ctx context.Context, version uint64) error {
+	for {
+		q.mu.Lock()
+		if version == 0 {
+			version = q.committed
+		}
+		if q.indexed >= version {
+			q.mu.Unlock()
+			return nil
+		}
+		advanced := q.advanced
+		q.mu.Unlock()
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (q *indexQueue) stats() IndexQueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return IndexQueueStats{
+		Depth:    q.depth,
+		Queued:   q.committed,
+		Indexed:  q.indexed,
+		Failed:   q.failed,
+		Batches:  q.batches,
+		Blocked:  q.blocked,
+		MaxDepth: q.opts.MaxDepth,
+	}
+}
+
+func (q *indexQueue) start() {
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		for {
+			n, err := q.drain()
+			if err != nil {
+				logger.Warn("failed to index queued operations", "err", err)
+				select {
+				case <-time.After(indexRetryDelay):
+					continue
+				case <-q.done:
+					return
+				}
+			}
+			if n == q.opts.BatchSize {
+				continue
+			}
+			select {
+			case <-q.notify:
+			case <-q.done:
+				return
+			}
+		}
+	}()
+}
+
+func (q *indexQueue) stop() {
+	close(q.done)
+	q.wg.Wait()
+}
+
+// drain indexes a batch of queued operations then removes them from the queue
+func (q *indexQueue) drain() (int, error) {
+	var keys [][]byte
+	var last uint64
+	b := q.s.Indexer.BatchIndex()
+	failed := 0
+	prefix := []byte(indexQueuePrefix)
+	err := q.s.Db.View(func(txn *badgerdb.Txn) error {
+		it := txn.NewIterator(badgerdb.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix) && len(keys) < q.opts.BatchSize; it.Next() {
+			item := it.Item()
+			key := item.KeyCopy(nil)
+			keys = append(keys, key)
+			if v, err := strconv.ParseUint(strings.TrimPrefix(string(key), indexQueuePrefix), 10, 64); err == nil {
+				last = v
+			}
+			var op indexOp
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &op)
+			})
+			if err == nil {
+				err = q.batchOp(txn, b, op)
+			}
+			if err != nil {
+				logger.Warn("failed to index queued operation", "key", string(key), "err", err)
+				failed++
+			}
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	if err := q.s.Indexer.Batch(b); err != nil {
+		return 0, err
+	}
+	err = q.s.Db.Update(func(txn *badgerdb.Txn) error {
+		for _, key := range keys {
+			if err := txn.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	q.depth -= len(keys)
+	if last > q.indexed {
+		q.indexed = last
+	}
+	q.failed += uint64(failed)
+	q.batches++
+	close(q.advanced)
+	q.advanced = make(chan struct{})
+	q.mu.Unlock()
+	return len(keys), nil
+}
+
+// batchOp adds an operation to the batch using the current value of the row
+func (q *indexQueue) batchOp(txn *badgerdb.Txn, b *indexer.Batch, op indexOp) error {
+	if op.Delete {
+		b.Delete(op.ID)
+		return nil
+	}
+	item, err := txn.Get([]byte(q.s.keyForTableId(op.Store, op.ID)))
+	if err == badgerdb.ErrKeyNotFound {
+		// the row was removed after it was queued
+		b.Delete(op.ID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var data map[string]interface{}
+	if err := item.Value(func(val []byte) error {
+		return json.Unmarshal(val, &data)
+	}); err != nil {
+		return err
+	}
+	if op.Location != nil {
+		return b.Index(op.ID, map[string]interface{}{"bucket": op.Store, "data": data, "location": op.Location})
+	}
+	return b.Index(op.ID, IndexedData{Bucket: op.Store, Data: data})
+}
+
+// EnableAsyncIndex queues index operations with the data they index instead of
+// indexing within each write. Queued operations are indexed in batches by a
+// background worker, writes block while the queue is full. Operations queued
+// before a restart are indexed once async indexing is enabled again
+func (s *BadgerStore) EnableAsyncIndex(opts AsyncIndexOptions) error {
+	if s.queue != nil {
+		return nil
+	}
+	q, err := newIndexQueue(s, opts)
+	if err != nil {
+		return err
+	}
+	s.queue = q
+	q.start()
+	return nil
+}
+
+// IndexVersion returns the version of the last committed index operation,
+// it is zero when async indexing is disabled
+func (s *BadgerStore) IndexVersion() uint64 {
+	if s.queue == nil {
+		return 0
+	}
+	return s.queue.stats().Queued
+}
+
+// WaitForIndex blocks until every index operation up to version has been
+// indexed, a zero version waits for every operation queued so far
+func (s *BadgerStore) WaitForIndex(ctx context.Context, version uint64) error {
+	if s.queue == nil {
+		return nil
+	}
+	return s.queue.wait(ctx, version)
+}
+
+// IndexQueueStats returns metrics of the async index queue
+func (s *BadgerStore) IndexQueueStats() IndexQueueStats {
+	if s.queue == nil {
+		return IndexQueueStats{}
+	}
+	return s.queue.stats()
+}
+
+// update runs a write transaction, queuing index operations when async indexing is enabled
+func (s *BadgerStore) update(fn func(txn *badgerdb.Txn) error) error {
+	if s.queue != nil {
+		return s.queue.update(fn)
+	}
+	return s.Db.Update(fn)
+}
+
+// queueTX queues an index operation with a transaction of the store, it
+// returns false when async indexing is disabled or txn is not a
+// BadgerTransaction and the caller indexes the document itself
+func (s *BadgerStore) queueTX(txn gostore.Transaction, op indexOp) bool {
+	t, ok := txn.(*BadgerTransaction)
+	if s.queue == nil || !ok {
+		return false
+	}
+	t.queue = s.queue
+	t.ops = append(t.ops, op)
+	return true
+}
+
+// indexDocument indexes a document or queues it within txn when async indexing is enabled
+func (s *BadgerStore) indexDocument(txn *badgerdb.Txn, key, store string, src interface{}) error {
+	if s.queue != nil {
+		return s.queue.push(txn, indexOp{ID: key, Store: store})
+	}
+	return s.Indexer.IndexDocument(key, IndexedData{Bucket: store, Data: src})
+}
+
+// indexGeoDocument indexes a document with a location or queues it within txn
+func (s *BadgerStore) indexGeoDocument(txn *badgerdb.Txn, key, store string, src map[string]interface{}, geo interface{}) error {
+	if s.queue != nil {
+		return s.queue.push(txn, indexOp{ID: key, Store: store, Location: geo})
+	}
+	return s.Indexer.IndexDocument(key, map[string]interface{}{"bucket": store, "data": src, "location": geo})
+}
+
+// unIndexDocument removes a document from the index or queues its removal within txn
+func (s *BadgerStore) unIndexDocument(txn *badgerdb.Txn, key, store string) error {
+	if s.queue != nil {
+		return s.queue.push(txn, indexOp{Delete: true, ID: key, Store: store})
+	}
+	return s.Indexer.UnIndexDocument(key)
+}
+
+// batchDocument adds a document to an index batch or queues it within txn
+func (s *BadgerStore) batchDocument(txn *badgerdb.Txn, b *indexer.Batch, key, store string, doc interface{}) error {
+	if s.queue != nil {
+		return s.queue.push(txn, indexOp{ID: key, Store: store})
+	}
+	return b.Index(key, doc)
+}
+
+// commitBatch indexes a batch, batches are empty when async indexing is enabled
+func (s *BadgerStore) commitBatch(b *indexer.Batch) error {
+	if s.queue != nil {
+		return nil
+	}
+	return s.Indexer.Batch(b)
+}
@@ -0,0 +1,185 @@
+package badger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/osiloke/gostore-contrib/indexer"
+)
+
+// ErrReindexNotSupported returned when the store was not created with NewWithIndex
+var ErrReindexNotSupported = errors.New("online reindex not supported by this store")
+
+// reindexState tracks the active index so it can be rebuilt online, and
+// the named indexes tables are routed to
+type reindexState struct {
+	mu      sync.Mutex
+	root    string
+	index   string
+	path    string
+	mapping mapping.IndexMapping
+	scorch  indexer.ScorchOptions
+	swap    *indexer.SwapIndexer
+	router  *indexer.RouterIndexer
+	indexes indexesConfig
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// openIndex opens or creates an index of the given type at path
+func openIndex(index, indexPath string, indexMapping mapping.IndexMapping, scorchOpts indexer.ScorchOptions) (ix indexer.Indexer) {
+	if index == "badger" {
+		if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+			os.Mkdir(indexPath, os.FileMode(0755))
+			logger.Debug("made badger db index path", "path", indexPath)
+		}
+		ix = indexer.NewBadgerIndexerWithMapping(indexPath, indexMapping)
+	} else if index == "memory" {
+		ix, _ = indexer.NewMemIndexerWithMapping(indexPath, indexMapping)
+	} else if index == "moss-scorch" {
+		ix, _ = indexer.NewMossScorchIndexerWithMapping(indexPath, indexMapping)
+	} else if index == "moss" {
+		ix, _ = indexer.NewMossIndexer(indexPath)
+	} else if index == "geo-moss" {
+		ix, _ = indexer.NewMossIndexerWithMapping(indexPath, indexMapping)
+	} else if index == "scorch" {
+		ix, _ = indexer.NewScorchIndexerWithOptions(indexPath, indexMapping, scorchOpts)
+	} else {
+		ix = indexer.NewIndexer(indexPath, indexMapping)
+	}
+	return
+}
+
+// readIndexInit reads the index type and directory recorded in the .init
+// marker, markers written before the directory was recorded use the default
+func readIndexInit(root string) (index, dir string, ok bool) {
+	dat, err := os.ReadFile(filepath.Join(root, ".init"))
+	if err != nil {
+		return "", "", false
+	}
+	parts := strings.SplitN(string(dat), "|", 3)
+	index = parts[0]
+	if len(parts) == 3 && parts[2] != "" {
+		return index, parts[2], true
+	}
+	return index, indexFilenamePrefix[index] + "db.index", true
+}
+
+// writeIndexInit records the active index type and directory
+func writeIndexInit(root, index, indexPath string) error {
+	init := index + "|" + time.Now().UTC().String() + "|" + filepath.Base(indexPath)
+	return os.WriteFile(filepath.Join(root, ".init"), []byte(init), os.ModePerm)
+}
+
+// removeIndexDirs removes every index directory in root except keep
+func removeIndexDirs(root, keep string) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasSuffix(entry.Name(), "db.index") && entry.Name() != keep {
+			if err := os.RemoveAll(filepath.Join(root, entry.Name())); err != nil {
+				return err
+			}
+			logger.Debug("removing index", "path", entry.Name())
+		}
+	}
+	return nil
+}
+
+// rebuild builds an index of type index at indexPath while the current index
+// keeps serving, then swaps it in and removes the old index. Callers add the
+// rebuild to the wait group of the reindex state before it starts
+func (s *BadgerStore) rebuild(ctx context.Context, index, indexPath string) error {
+	r := s.reindex
+	r.mu.Lock()
+	if r.cancel != nil {
+		r.mu.Unlock()
+		return indexer.ErrRebuildRunning
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	r.mu.Unlock()
+	defer func() {
+		cancel()
+		r.mu.Lock()
+		r.cancel = nil
+		r.mu.Unlock()
+	}()
+
+	os.RemoveAll(indexPath)
+	ix := openIndex(index, indexPath, r.mapping, r.scorch)
+	if ix == nil {
+		return fmt.Errorf("unable to create %s index at %s", index, indexPath)
+	}
+	logger.Info("rebuilding index", "index", index, "path", indexPath)
+	old, err := r.swap.Rebuild(ctx, indexer.ExcludeStores(s, r.router.RoutedBuckets()...), ix, 0)
+	if err != nil {
+		logger.Warn("index rebuild failed", "index", index, "path", indexPath, "err", err)
+		ix.Close()
+		os.RemoveAll(indexPath)
+		return err
+	}
+	oldPath := r.path
+	r.mu.Lock()
+	r.index, r.path = index, indexPath
+	r.mu.Unlock()
+	if err := writeIndexInit(r.root, index, indexPath); err != nil {
+		return err
+	}
+	old.Close()
+	if oldPath != indexPath {
+		os.RemoveAll(oldPath)
+	}
+	logger.Info("rebuilt index", "index", index, "path", indexPath, "progress", r.swap.Progress())
+	return nil
+}
+
+// Reindex rebuilds the index into a new directory while it keeps serving
+// queries and writes, blocking until the new index is swapped in. It can be
+// cancelled with ctx or CancelReindex
+func (s *BadgerStore) Reindex(ctx context.Context) error {
+	if s.reindex == nil {
+		return ErrReindexNotSupported
+	}
+	s.reindex.mu.Lock()
+	index := s.reindex.index
+	s.reindex.mu.Unlock()
+	dir := fmt.Sprintf("%s%d.db.index", indexFilenamePrefix[index], time.Now().UnixNano())
+	s.reindex.wg.Add(1)
+	defer s.reindex.wg.Done()
+	return s.rebuild(ctx, index, filepath.Join(s.reindex.root, dir))
+}
+
+// ReindexProgress returns the progress of the running or last index rebuild,
+// a rebuild is running until the old index has been removed
+func (s *BadgerStore) ReindexProgress() indexer.RebuildProgress {
+	if s.reindex == nil {
+		return indexer.RebuildProgress{}
+	}
+	s.reindex.mu.Lock()
+	defer s.reindex.mu.Unlock()
+	p := s.reindex.swap.Progress()
+	p.Running = p.Running || s.reindex.cancel != nil
+	return p
+}
+
+// CancelReindex stops a running index rebuild, the current index is kept
+func (s *BadgerStore) CancelReindex() {
+	if s.reindex == nil {
+		return
+	}
+	s.reindex.mu.Lock()
+	defer s.reindex.mu.Unlock()
+	if s.reindex.cancel != nil {
+		s.reindex.cancel()
+	}
+}
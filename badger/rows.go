@@ -196,19 +196,17 @@ type TransactionRows struct {
 
 // Next get next item
 func (s *TransactionRows) Next(dst interface{}) (bool, error) {
-	err := gostore.ErrEOF
 	if s.ci < s.length {
+		val := s.entries[s.ci][1]
+		err := json.Unmarshal(val, dst)
 		if err == nil {
-			val := s.entries[s.ci][1]
-			err = json.Unmarshal(val, dst)
-			if err == nil {
-				s.ci++
-				return true, nil
-			}
-			logger.Warn(err.Error())
+			s.ci++
+			return true, nil
 		}
+		logger.Warn(err.Error())
+		return false, err
 	}
-	return false, err
+	return false, gostore.ErrEOF
 }
 
 // NextRaw get next raw item
@@ -0,0 +1,347 @@
+package badger
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
+	badgerdb "github.com/dgraph-io/badger"
+	"github.com/osiloke/gostore"
+	"github.com/osiloke/gostore-contrib/indexer"
+)
+
+// ErrSnapshotClosed is returned by a snapshot after it was closed or expired
+var ErrSnapshotClosed = errors.New("snapshot closed")
+
+// ErrSnapshotTooManyHits is returned by a snapshot query which matches more
+// hits than the snapshot keeps
+var ErrSnapshotTooManyHits = errors.New("snapshot query has too many hits")
+
+// DefaultSnapshotTTL closes snapshots which are still open after this long
+const DefaultSnapshotTTL = 5 * time.Minute
+
+// DefaultSnapshotMaxHits is the number of hits a snapshot keeps per query
+const DefaultSnapshotMaxHits = 10000
+
+// SnapshotOption configures a Snapshot
+type SnapshotOption func(*Snapshot)
+
+// SnapshotTTL closes the snapshot after ttl, a ttl of 0 keeps it open until
+// Close. Badger keeps every version an open snapshot can read so snapshots
+// should not be kept open longer than needed
+func SnapshotTTL(ttl time.Duration) SnapshotOption {
+	return func(s *Snapshot) {
+		s.ttl = ttl
+	}
+}
+
+// SnapshotMaxHits sets the number of hits a snapshot keeps per query, queries
+// matching more hits fail with ErrSnapshotTooManyHits
+func SnapshotMaxHits(n int) SnapshotOption {
+	return func(s *Snapshot) {
+		s.maxHits = n
+	}
+}
+
+// Snapshot is a read only view of a BadgerStore pinned to the badger read
+// timestamp it was taken at, rows written after the snapshot are not seen.
+// The hits of a query are taken from the index the first time the query runs
+// on the snapshot and are kept for paging through it, hits of rows written
+// after the snapshot are left out. Rows of the hits are read at the snapshot
+// timestamp so pages neither shift nor change while paging
+type Snapshot struct {
+	store   *BadgerStore
+	txn     *badgerdb.Txn
+	ttl     time.Duration
+	maxHits int
+	expires time.Time
+	timer   *time.Timer
+
+	// mu keeps Close from discarding txn while it is read
+	mu     sync.RWMutex
+	closed bool
+
+	qmu     sync.Mutex
+	queries map[string]*snapshotQuery
+}
+
+// snapshotQuery the hits and aggregates of a query on a snapshot
+type snapshotQuery struct {
+	hits search.DocumentMatchCollection
+	agg  gostore.AggregateResult
+}
+
+// Snapshot takes a snapshot of the store, the snapshot is closed after
+// DefaultSnapshotTTL unless SnapshotTTL is passed and keeps up to
+// DefaultSnapshotMaxHits hits per query unless SnapshotMaxHits is passed.
+// Snapshots still open are closed when the store is closed
+func (s *BadgerStore) Snapshot(opts ...SnapshotOption) (*Snapshot, error) {
+	snap := &Snapshot{store: s, ttl: DefaultSnapshotTTL, maxHits: DefaultSnapshotMaxHits, queries: map[string]*snapshotQuery{}}
+	for _, opt := range opts {
+		opt(snap)
+	}
+	snap.txn = s.Db.NewTransaction(false)
+	if !s.snapshots.add(snap) {
+		snap.txn.Discard()
+		return nil, ErrSnapshotClosed
+	}
+	if snap.ttl > 0 {
+		snap.expires = time.Now().Add(snap.ttl)
+		// the store may have been closed since the snapshot was added
+		snap.mu.Lock()
+		if !snap.closed {
+			snap.timer = time.AfterFunc(snap.ttl, func() {
+				logger.Info("snapshot expired", "readTs", snap.ReadTs())
+				snap.Close()
+			})
+		}
+		snap.mu.Unlock()
+	}
+	logger.Debug("took snapshot", "readTs", snap.ReadTs(), "ttl", snap.ttl)
+	return snap, nil
+}
+
+// ReadTs returns the badger read timestamp of the snapshot
+func (s *Snapshot) ReadTs() uint64 {
+	return s.txn.ReadTs()
+}
+
+// Expires returns when the snapshot is closed, it is zero without a ttl
+func (s *Snapshot) Expires() time.Time {
+	return s.expires
+}
+
+// Close releases the snapshot, reads after Close fail with ErrSnapshotClosed
+func (s *Snapshot) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	s.txn.Discard()
+	s.store.snapshots.remove(s)
+	logger.Debug("closed snapshot", "readTs", s.txn.ReadTs())
+}
+
+// view calls fn with the transaction of the snapshot
+func (s *Snapshot) view(fn func(txn *badgerdb.Txn) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+	return fn(s.txn)
+}
+
+func (s *Snapshot) getRow(key, store string) (row [][]byte, err error) {
+	err = s.view(func(txn *badgerdb.Txn) error {
+		row, err = s.store.getRow(txn, key, store)
+		return err
+	})
+	return
+}
+
+// Get gets the row of key in store as it was when the snapshot was taken
+func (s *Snapshot) Get(key string, store string, dst interface{}) error {
+	row, err := s.getRow(key, store)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(row[1], dst)
+}
+
+// All gets count rows of store after skipping skip as they were when the
+// snapshot was taken
+func (s *Snapshot) All(count int, skip int, store string) (gostore.ObjectRows, error) {
+	var objs [][][]byte
+	err := s.view(func(txn *badgerdb.Txn) (err error) {
+		objs, err = s.store.allRows(txn, count, skip, store)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(objs) > 0 {
+		return &TransactionRows{entries: objs, length: len(objs)}, nil
+	}
+	return nil, gostore.ErrNotFound
+}
+
+// Since gets count rows of store from id onwards after skipping skip as they
+// were when the snapshot was taken
+func (s *Snapshot) Since(id string, count int, skip int, store string) (gostore.ObjectRows, error) {
+	var objs [][][]byte
+	err := s.view(func(txn *badgerdb.Txn) (err error) {
+		objs, err = s.store.sinceRows(txn, id, count, skip, store)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &TransactionRows{entries: objs, length: len(objs)}, nil
+}
+
+// Query pages through the hits of a query. Every page of the same query,
+// aggregates, store and order is taken from the same hits and the aggregates
+// are computed once, hits of rows written after the snapshot are left out
+func (s *Snapshot) Query(query, aggregates map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, gostore.AggregateResult, error) {
+	if len(query) == 0 {
+		return nil, nil, gostore.ErrNotFound
+	}
+	// the rows of kept hits cannot be read after Close
+	if err := s.view(func(*badgerdb.Txn) error { return nil }); err != nil {
+		return nil, nil, err
+	}
+	order := []string{"-_score", "-_id"}
+	if opts != nil {
+		if orderBy := opts.GetOrderBy(); len(orderBy) > 0 {
+			order = orderBy
+		}
+	}
+	res, err := s.query(query, aggregates, store, order)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.hits) == 0 {
+		return nil, res.agg, gostore.ErrNotFound
+	}
+	page := search.DocumentMatchCollection{}
+	if skip < len(res.hits) {
+		end := len(res.hits)
+		if count >= 0 && skip+count < end {
+			end = skip + count
+		}
+		page = res.hits[skip:end]
+	}
+	result := &bleve.SearchResult{
+		Request: &bleve.SearchRequest{Size: count, From: skip},
+		Hits:    page,
+		Total:   uint64(len(res.hits)),
+	}
+	return &SyncIndexRows{name: store, length: result.Total, result: result, bs: s.store, read: s.getRow}, res.agg, nil
+}
+
+// query returns the hits of a query, searching the index the first time the
+// query runs on the snapshot
+func (s *Snapshot) query(query, aggregates map[string]interface{}, store string, order []string) (*snapshotQuery, error) {
+	key, err := json.Marshal([]interface{}{store, query, aggregates, order})
+	if err != nil {
+		return nil, err
+	}
+	s.qmu.Lock()
+	defer s.qmu.Unlock()
+	if res, ok := s.queries[string(key)]; ok {
+		return res, nil
+	}
+	res, err := s.search(query, aggregates, store, order)
+	if err != nil {
+		return nil, err
+	}
+	s.queries[string(key)] = res
+	return res, nil
+}
+
+func (s *Snapshot) search(query, aggregates map[string]interface{}, store string, order []string) (*snapshotQuery, error) {
+	ix := s.store.Indexer
+	q := indexer.GetQueryString(store, query)
+	facets, aggs := indexer.ParseAggregates(aggregates)
+	// a single search with one hit more than is kept finds queries with too
+	// many hits while every kept hit comes from the same index reader
+	size := s.maxHits + 1
+	logger.Info("snapshot query", "Store", store, "query", q, "maxHits", s.maxHits, "order", order)
+	var res *bleve.SearchResult
+	var err error
+	if len(aggregates) == 0 {
+		res, err = ix.QueryWithOptions(q, size, 0, false, []string{}, indexer.OrderRequest(order))
+	} else {
+		res, err = ix.FacetedQuery(q, facets, size, 0, false, []string{}, indexer.OrderRequest(order))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Hits) > s.maxHits {
+		return nil, ErrSnapshotTooManyHits
+	}
+	agg, err := indexer.FacetResults(res, facets)
+	if err != nil {
+		return nil, err
+	}
+	hits := search.DocumentMatchCollection{}
+	for _, h := range res.Hits {
+		_, err := s.getRow(h.ID, store)
+		if err == gostore.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, h)
+	}
+	if len(hits) > 0 && !aggs.Empty() {
+		metrics, err := indexer.Aggregate(ix, q, aggs, func(id string) (map[string]interface{}, error) {
+			row, err := s.getRow(id, store)
+			if err != nil {
+				return nil, err
+			}
+			var doc map[string]interface{}
+			err = json.Unmarshal(row[1], &doc)
+			return doc, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range metrics {
+			agg[k] = v
+		}
+	}
+	return &snapshotQuery{hits: hits, agg: agg}, nil
+}
+
+// snapshotSet the open snapshots of a store
+type snapshotSet struct {
+	mu     sync.Mutex
+	open   map[*Snapshot]struct{}
+	closed bool
+}
+
+func newSnapshotSet() *snapshotSet {
+	return &snapshotSet{open: map[*Snapshot]struct{}{}}
+}
+
+// add adds a snapshot unless the store is closed
+func (s *snapshotSet) add(snap *Snapshot) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.open[snap] = struct{}{}
+	return true
+}
+
+func (s *snapshotSet) remove(snap *Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.open, snap)
+}
+
+// closeAll closes every open snapshot, no snapshots are taken afterwards
+func (s *snapshotSet) closeAll() {
+	s.mu.Lock()
+	s.closed = true
+	open := make([]*Snapshot, 0, len(s.open))
+	for snap := range s.open {
+		open = append(open, snap)
+	}
+	s.mu.Unlock()
+	for _, snap := range open {
+		snap.Close()
+	}
+}
@@ -10,22 +10,42 @@ type BadgerTransaction struct {
 	db   *badgerdb.DB
 	txn  *badgerdb.Txn
 	mode string
+	// index operations queued on commit when async indexing is enabled
+	queue *indexQueue
+	ops   []indexOp
 }
 
 func (t *BadgerTransaction) Restart() error {
 	switch t.mode {
 	case "update":
 		t.txn = t.db.NewTransaction(true)
+		t.ops = nil
 	default:
 		return errors.New("unknown transaction mode")
 	}
 	return nil
 }
+
+// Commit commits the transaction, index operations are queued in the same
+// commit so they are indexed in order with other writes
 func (t *BadgerTransaction) Commit() error {
-	return t.txn.Commit()
+	if t.queue == nil || len(t.ops) == 0 {
+		return t.txn.Commit()
+	}
+	ops := t.ops
+	t.ops = nil
+	return t.queue.commit(t.txn, func(txn *badgerdb.Txn) error {
+		for _, op := range ops {
+			if err := t.queue.push(txn, op); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func (t *BadgerTransaction) Discard() {
+	t.ops = nil
 	t.txn.Discard()
 }
 
@@ -2,17 +2,22 @@ package bolt
 
 import (
 	"fmt"
+	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/osiloke/gostore-contrib/backup"
+	"github.com/osiloke/gostore-contrib/indexer"
 )
 
 // WriteToHTTP writes store to http writer
 func (s *BoltStore) WriteToHTTP(w http.ResponseWriter) error {
 	date := time.Now().Format("2006_01_02_15-04-05")
-	err := s.Db.View(func(tx *bolt.Tx) error {
+	err := s.view(func(tx *bolt.Tx) error {
 		w.Header().Set("Content-Type", "application/octet-stream")
 		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="bolt_%s.db"`, date))
 		w.Header().Set("Content-Length", strconv.Itoa(int(tx.Size())))
@@ -25,3 +30,122 @@ func (s *BoltStore) WriteToHTTP(w http.ResponseWriter) error {
 	}
 	return nil
 }
+
+// Backup writes a backup archive of the store to w. The data is a copy of the
+// bolt file taken in a single read transaction, whose id is the manifest
+// version. Bolt backups are always full so opts.Since must be 0
+func (s *BoltStore) Backup(w io.Writer, opts backup.Options) (*backup.Manifest, error) {
+	if opts.Since != 0 {
+		return nil, backup.ErrIncrementalNotSupported
+	}
+	m := &backup.Manifest{Store: backup.StoreBolt}
+	if s.Indexer != nil && s.Indexer.Index() != nil {
+		index, err := backup.NewIndexConfig("", s.Indexer.Index().Mapping())
+		if err != nil {
+			return nil, err
+		}
+		if geoIndexer, ok := s.Indexer.(indexer.GeoCapableIndexer); ok {
+			index.GeoField = geoIndexer.GeoField()
+		}
+		m.Index = index
+	}
+	err := s.view(func(tx *bolt.Tx) error {
+		m.Tables = tables(tx)
+		return backup.Write(w, m, opts.Gzip, func(w io.Writer) (uint64, error) {
+			_, err := tx.WriteTo(w)
+			return uint64(tx.ID()), err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("backed up", "version", m.Version, "size", m.Size)
+	return m, nil
+}
+
+// BackupToFile writes a backup archive of the store to path
+func (s *BoltStore) BackupToFile(path string, opts backup.Options) (m *backup.Manifest, err error) {
+	err = backup.WriteFile(path, func(w io.Writer) error {
+		m, err = s.Backup(w, opts)
+		return err
+	})
+	return
+}
+
+// RestoreBackup replaces the store file with a bolt backup, an archive or a
+// bolt file gzipped or not, and reindexes the store from the restored rows.
+// The backup is verified before the store file is replaced and writes made
+// while restoring are lost
+func (s *BoltStore) RestoreBackup(path string) (*backup.Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dbPath := s.Db.Path()
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-")
+	if err != nil {
+		return nil, err
+	}
+	m, err := backup.Extract(f, func(m *backup.Manifest, data io.Reader) error {
+		if m.Store != backup.StoreBolt {
+			return fmt.Errorf("%s is a %s backup", path, m.Store)
+		}
+		_, err := io.Copy(tmp, data)
+		return err
+	})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		var db *bolt.DB
+		if db, err = bolt.Open(tmp.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second}); err == nil {
+			err = db.Close()
+		}
+	}
+	if err == nil {
+		s.dbMu.Lock()
+		err = s.swapFile(tmp.Name())
+		s.dbMu.Unlock()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return m, err
+	}
+	s.tableConfig = make(map[string]*TableConfig)
+	if err := s.loadTableConfig(); err != nil {
+		return m, err
+	}
+	logger.Info("restored backup", "path", path, "version", m.Version)
+	if s.Indexer != nil {
+		if err := indexer.ClearIndex(s.Indexer); err != nil {
+			return m, err
+		}
+		report, err := indexer.ReIndex(s, s.Indexer)
+		if err != nil {
+			return m, err
+		}
+		if len(report.Failures) > 0 {
+			logger.Warn("some restored documents failed to reindex", "indexed", report.Indexed, "failed", len(report.Failures))
+		}
+	}
+	return m, nil
+}
+
+// Restore replaces the store file with a backup and indexes the restored rows
+func (s *BoltStore) Restore(filename string) error {
+	_, err := s.RestoreBackup(filename)
+	return err
+}
+
+// tables lists the top level buckets except those holding store metadata
+func tables(tx *bolt.Tx) []string {
+	tables := []string{}
+	tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if table := string(name); table != tablesBucket && table != pathsBucket {
+			tables = append(tables, table)
+		}
+		return nil
+	})
+	return tables
+}
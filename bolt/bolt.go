@@ -2,16 +2,21 @@ package bolt
 
 //TODO: Extract methods into functions
 import (
-	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search"
 	boltdb "github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 	log "github.com/mgutz/logxi/v1"
@@ -38,6 +43,29 @@ type BoltStore struct {
 	Db          *boltdb.DB
 	Indexer     indexer.Indexer
 	tableConfig map[string]*TableConfig
+	// dbMu is held for writing while Db is replaced by a compacted file
+	dbMu *sync.RWMutex
+}
+
+// update runs fn in a read-write transaction unless Db is being replaced
+func (s *BoltStore) update(fn func(*boltdb.Tx) error) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.Db.Update(fn)
+}
+
+// view runs fn in a read-only transaction unless Db is being replaced
+func (s *BoltStore) view(fn func(*boltdb.Tx) error) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.Db.View(fn)
+}
+
+// batch runs fn in a batched read-write transaction unless Db is being replaced
+func (s *BoltStore) batch(fn func(*boltdb.Tx) error) error {
+	s.dbMu.RLock()
+	defer s.dbMu.RUnlock()
+	return s.Db.Batch(fn)
 }
 
 // IndexedData indexed data stored
@@ -51,6 +79,11 @@ func (d *IndexedData) Type() string {
 	return "indexed_data"
 }
 
+// BleveType types indexed documents by their bucket
+func (d IndexedData) BleveType() string {
+	return d.Bucket
+}
+
 // NewDBOnly creates only a db store, no index
 func NewDBOnly(dbPath string) (store *BoltStore, err error) {
 	var db *boltdb.DB
@@ -58,7 +91,8 @@ func NewDBOnly(dbPath string) (store *BoltStore, err error) {
 	if err != nil {
 		return
 	}
-	store = &BoltStore{[]byte("_default"), db, nil, make(map[string]*TableConfig)}
+	store = &BoltStore{[]byte("_default"), db, nil, make(map[string]*TableConfig), &sync.RWMutex{}}
+	err = store.loadTableConfig()
 	return
 }
 
@@ -71,7 +105,37 @@ func NewWithPaths(boltPath, indexPath string) (store *BoltStore, err error) {
 	}
 	indexMapping := bleve.NewIndexMapping()
 	index := indexer.NewIndexer(indexPath, indexMapping)
-	store = &BoltStore{[]byte("_default"), db, index, make(map[string]*TableConfig)}
+	store = &BoltStore{[]byte("_default"), db, index, make(map[string]*TableConfig), &sync.RWMutex{}}
+	err = store.loadTableConfig()
+	return
+}
+
+// NewGeoWithPaths creates a store with a geo capable index at the specified
+// paths. A nil mapping indexes the location of rows saved with SaveWithGeo as
+// a geopoint, options such as indexer.WithGeoField configure the indexer
+func NewGeoWithPaths(boltPath, indexPath string, indexMapping mapping.IndexMapping, indexOpts ...indexer.IndexOptions) (store *BoltStore, err error) {
+	if indexMapping == nil {
+		im := bleve.NewIndexMapping()
+		im.TypeField = "bucket"
+		im.DefaultMapping.AddFieldMappingsAt("location", bleve.NewGeoPointFieldMapping())
+		indexMapping = im
+	}
+	var db *boltdb.DB
+	db, err = boltdb.Open(boltPath, 0600, nil)
+	if err != nil {
+		return
+	}
+	index := indexer.NewIndexer(indexPath, indexMapping)
+	if index == nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create index at %s", indexPath)
+	}
+	geoIndex := &indexer.GeoIndexer{Field: "location", Indexer: index}
+	for _, opt := range indexOpts {
+		opt(geoIndex)
+	}
+	store = &BoltStore{[]byte("_default"), db, geoIndex, make(map[string]*TableConfig), &sync.RWMutex{}}
+	err = store.loadTableConfig()
 	return
 }
 
@@ -96,7 +160,8 @@ func NewWithIndex(boltPath string, index indexer.Indexer) (store *BoltStore, err
 	if err != nil {
 		return
 	}
-	store = &BoltStore{[]byte("_default"), db, index, make(map[string]*TableConfig)}
+	store = &BoltStore{[]byte("_default"), db, index, make(map[string]*TableConfig), &sync.RWMutex{}}
+	err = store.loadTableConfig()
 	return
 }
 
@@ -125,16 +190,27 @@ func (s *BoltStore) CreateDatabase() error {
 	return nil
 }
 
+// CreateTable creates a table. A "nested" config maps fields to regexes, rows
+// are saved in sub buckets named by the matches of the regex in the field value
 func (s *BoltStore) CreateTable(table string, config interface{}) error {
 	//config used to configure table
-	s.CreateBucket(table)
+	if err := s.CreateBucket(table); err != nil {
+		return err
+	}
 	if c, ok := config.(map[string]interface{}); ok {
-		if nested, ok := c["nested"]; ok {
-			nbfm := make(map[string]*regexp.Regexp)
-			for k, v := range nested.(map[string]interface{}) {
-				nbfm[k] = regexp.MustCompile(v.(string))
+		if n, ok := c["nested"].(map[string]interface{}); ok {
+			nested := make(map[string]string, len(n))
+			for k, v := range n {
+				nested[k] = fmt.Sprintf("%v", v)
 			}
-			s.tableConfig[table] = &TableConfig{NestedBucketFieldMatcher: nbfm}
+			tc, err := newTableConfig(nested)
+			if err != nil {
+				return err
+			}
+			if err := s.saveTableConfig(table, nested); err != nil {
+				return err
+			}
+			s.tableConfig[table] = tc
 		}
 	}
 	return nil
@@ -144,23 +220,20 @@ func (s *BoltStore) GetStore() interface{} {
 	return s.Db
 }
 
+// getBucketPath splits a store such as orders/tenant1 into its bucket path
 func (s *BoltStore) getBucketPath(bucket string) []string {
-	return strings.Split("bucket", "/")
+	return strings.Split(bucket, pathSeparator)
 }
 func (s *BoltStore) CreateBucket(bucket string) error {
-	return s.Db.Update(func(tx *boltdb.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(bucket))
+	return s.update(func(tx *boltdb.Tx) error {
+		_, err := createBucketAt(tx, s.getBucketPath(bucket))
 		return err
 	})
 }
 
 func getBucket(tx *boltdb.Tx, bucket []string) (nbkt *boltdb.Bucket, err error) {
-	bkt := tx.Bucket([]byte(bucket[0]))
-	for _, k := range bucket[1:] {
-		nbkt, err = bkt.CreateBucketIfNotExists([]byte(k))
-		if err != nil {
-			return
-		}
+	if nbkt = bucketAt(tx, bucket); nbkt == nil {
+		err = gostore.ErrNotFound
 	}
 	return
 }
@@ -206,29 +279,16 @@ func PrefixGet(prefix []byte, bucket []byte, db *boltdb.DB) (k, v []byte, err er
 	return
 }
 
-func (s *BoltStore) getBucketList(store string, data map[string]interface{}) (bucket []string, err error) {
-	if config, ok := s.tableConfig[store]; ok {
-		for nestedField, re := range config.NestedBucketFieldMatcher {
-			if nestedFieldValue, ok := data[nestedField]; ok {
-				bucket = []string{store}
-				for _, match := range re.FindAllString(nestedFieldValue.(string), -1) {
-					bucket = append(bucket, match)
-				}
-				return
-			}
-		}
-	}
-	err = ErrNoNestedBuckets
-	return
-}
-
 func (s *BoltStore) _Get(key, resource string) (v [][]byte, err error) {
 	logger.Info("_Get", "key", key, "bucket", resource)
-	_key := []byte(key)
-	vv, err := Get(_key, []byte(resource), s.Db)
-	if vv != nil {
-		v = [][]byte{_key, vv}
-	}
+	err = s.view(func(tx *boltdb.Tx) error {
+		vv := s.getRow(tx, key, resource)
+		if vv == nil {
+			return gostore.ErrNotFound
+		}
+		v = [][]byte{[]byte(key), append([]byte{}, vv...)}
+		return nil
+	})
 	return
 }
 
@@ -241,66 +301,44 @@ func (s *BoltStore) _PrefixGet(prefix []byte, resource string) (v [][]byte, err
 }
 
 func (s *BoltStore) _Save(key []byte, data []byte, resource string) error {
-	err := s.Db.Batch(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(resource))
-		err := b.Put(key, data)
-		return err
+	err := s.batch(func(tx *boltdb.Tx) error {
+		return s.putRow(tx, string(key), resource, data, nil)
 	})
 	return err
 }
 func (s *BoltStore) _SaveTx(key []byte, data []byte, resource string) func(tx *boltdb.Tx) error {
 	return func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(resource))
-		err := b.Put(key, data)
-		return err
+		return s.putRow(tx, string(key), resource, data, nil)
 	}
 }
 
 func (s *BoltStore) _Delete(key string, resource string) error {
 	logger.Info("_Delete", "key", key, "bucket", resource)
-	err := s.Db.Batch(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(resource))
-		err := b.Delete([]byte(key))
-		return err
+	err := s.batch(func(tx *boltdb.Tx) error {
+		return s.deleteRow(tx, key, resource)
 	})
 	return err
 }
 
 func (s *BoltStore) DeleteAll(resource string) error {
-	err := s.Db.Update(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(resource))
-		c := b.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			b.Delete(k)
+	path := s.getBucketPath(resource)
+	err := s.update(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	return err
-}
-
-func (s *BoltStore) _NestedGet(key []byte, bucket []string) (v [][]byte, err error) {
-	_key := []byte(key)
-	vv, err := NestedGet(_key, bucket, s.Db)
-	if vv != nil {
-		v = [][]byte{_key, vv}
-	}
-	return
-}
-func (s *BoltStore) _NestedSave(key []byte, data []byte, bucket []string) error {
-	err := s.Db.Batch(func(tx *boltdb.Tx) error {
-		nbkt, err := getBucket(tx, bucket)
-		if err == nil {
-			err = nbkt.Put(key, data)
+		// rows are deleted after the walk since deletes move the cursor
+		var keys []string
+		walkBucket(b, false, func(k, v []byte) bool {
+			keys = append(keys, string(k))
+			return true
+		})
+		for _, key := range keys {
+			if err := s.deleteRow(tx, key, path[0]); err != nil {
+				return err
+			}
 		}
-		return err
-	})
-	return err
-}
-func (s *BoltStore) _NestedDelete(key string, resource string) error {
-	err := s.Db.Batch(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(resource))
-		err := b.Delete([]byte(key))
-		return err
+		return nil
 	})
 	return err
 }
@@ -318,40 +356,40 @@ func (s *BoltStore) All(count int, skip int, store string) (gostore.ObjectRows,
 	return newSyncRows(_rows), nil
 }
 
-// AllCursor returns all entries in a store.
+// AllCursor returns all entries in a store. Entries of nested buckets are
+// included, a store such as orders/tenant1 iterates a single sub bucket
 func (s *BoltStore) AllCursor(store string) (gostore.ObjectRows, error) {
 	rows := common.NewCursorRows()
 	go func(rows *common.CursorRows) {
 		defer func() {
 			rows.Done() <- true
 		}()
-		err := s.Db.View(func(tx *boltdb.Tx) error {
-			nbkt := tx.Bucket([]byte(store))
+		err := s.view(func(tx *boltdb.Tx) error {
+			nbkt := bucketAt(tx, s.getBucketPath(store))
 			if nbkt == nil {
 				return ErrNoNestedBuckets
 			}
-			c := nbkt.Cursor()
-			//no skip needed. Get first item
-			k, v := c.First()
-			if k == nil {
-				return gostore.ErrEOF
-			}
 			// listen to chan for next request
-		OUTER:
+			next := func(k, v []byte) bool {
+				select {
+				case <-rows.Exit():
+					return false
+				case <-rows.NextChan():
+					rows.OnNext([][]byte{k, v})
+					return true
+				}
+			}
+			if !walkBucket(nbkt, false, next) {
+				return nil
+			}
 			for {
 				select {
 				case <-rows.Exit():
-					break OUTER
+					return nil
 				case <-rows.NextChan():
-					if k == nil {
-						rows.OnNext(nil)
-					} else {
-						rows.OnNext([][]byte{k, v})
-					}
-					k, v = c.Next()
+					rows.OnNext(nil)
 				}
 			}
-			return nil
 		})
 		if err != nil {
 			logger.Error("cursor rows for "+store+" failed", "err", err.Error())
@@ -360,169 +398,64 @@ func (s *BoltStore) AllCursor(store string) (gostore.ObjectRows, error) {
 	return rows, nil
 }
 
+// GetAll returns up to count rows of a bucket after skipping skip rows, the
+// last keys first. Rows of nested buckets are included
 func (s *BoltStore) GetAll(count int, skip int, bucket string) (objs [][][]byte, err error) {
-
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		nbkt := tx.Bucket([]byte(bucket))
-		if err != nil {
-			return err
-		}
-		c := nbkt.Cursor()
-		var skip_lim int = 1
-
-		var lim int = 0
-		//Skip a certain amount
-		if skip > 0 {
-			//make sure we hit the database once
-			var target_count int = skip - 1
-			for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
-				if skip_lim >= target_count {
-					break
-				}
-				skip_lim++
-			}
-		} else {
-			//no skip needed. Get first item
-			k, v := c.Last()
-			if k == nil {
-				return err
-			}
-			objs = append(objs, [][]byte{k, v})
-			lim++
-			if lim == count {
-				// logger.Info("count reached", "lim", lim, "count", count)
-				return nil
-			}
-		}
-		//Get next items after skipping or getting first item
-		for k, v := c.Prev(); k != nil; k, v = c.Prev() {
-			objs = append(objs, [][]byte{k, v})
-			lim++
-			if lim == count {
-				// logger.Info("count reached", "lim", lim, "count", count)
-				break
-			}
+	err = s.view(func(tx *boltdb.Tx) error {
+		b := bucketAt(tx, s.getBucketPath(bucket))
+		if b == nil {
+			return nil
 		}
-		return err
+		walkBucket(b, true, collectRows(&objs, count, skip))
+		return nil
 	})
 	logger.Info("_GetAll done")
 	return
 }
 
+// _GetAllAfter returns up to count rows from key onwards after skipping skip
+// rows, rows of nested buckets are included
 func (s *BoltStore) _GetAllAfter(key []byte, count int, skip int, resource string) (objs [][][]byte, err error) {
 	s.CreateBucket(resource)
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		c := tx.Bucket([]byte(resource)).Cursor()
-		var lim int = 0
-		if skip > 0 {
-			var skip_lim int = 1
-			var target_count int = skip - 1
-			for k, _ := c.Seek(key); k != nil; k, _ = c.Next() {
-				logger.Info("Skipped ", string(k), "Current lim is ", skip_lim, " target count is ", target_count)
-				if skip_lim >= target_count {
-					break
-				}
-				skip_lim++
-			}
-		} else {
-			//no skip needed. Get first item
-			k, v := c.Seek(key)
-			if k != nil {
-				objs = append(objs, [][]byte{k, v})
-				lim++
-			} else {
-				return err
-			}
-			if lim == count {
-				return nil
-			}
-		}
-		for k, v := c.Next(); k != nil; k, v = c.Next() {
-			objs = append(objs, [][]byte{k, v})
-			lim++
-			if lim == count {
-				break
-			}
+	path := s.getBucketPath(resource)
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
 		}
-		return err
+		since := indexer.Range{Min: string(key), MinInclusive: true}
+		walkRange(tx, b, path[0], key, since.CompareKey, false, collectRows(&objs, count, skip))
+		return nil
 	})
 	return
 }
 
+// GetAllBefore returns up to count rows from key backwards after skipping skip
+// rows, rows of nested buckets are included
 func (s *BoltStore) GetAllBefore(key []byte, count int, skip int, resource string) (objs [][][]byte, err error) {
 	s.CreateBucket(resource)
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		c := tx.Bucket([]byte(resource)).Cursor()
-		var lim int = 0
-		if skip > 0 {
-			var skip_lim int = 1
-			var target_count int = skip - 1
-			for k, _ := c.Seek(key); k != nil; k, _ = c.Prev() {
-				if skip_lim >= target_count {
-					break
-				}
-				skip_lim++
-			}
-		} else {
-			//no skip needed. Get first item
-			k, v := c.Seek(key)
-			if k != nil {
-				objs = append(objs, [][]byte{k, v})
-				lim++
-			} else {
-				return err
-			}
-			if lim == count {
-				return nil
-			}
-		}
-		for k, v := c.Prev(); k != nil; k, v = c.Prev() {
-			objs = append(objs, [][]byte{k, v})
-			lim++
-			if lim == count {
-				break
-			}
+	path := s.getBucketPath(resource)
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
 		}
-		return err
+		before := indexer.Range{Max: string(key), MaxInclusive: true}
+		walkRange(tx, b, path[0], key, before.CompareKey, true, collectRows(&objs, count, skip))
+		return nil
 	})
 	return
 }
 
 func (s *BoltStore) _Filter(prefix []byte, count int, skip int, resource string) (objs [][][]byte, err error) {
 	s.CreateBucket(resource)
-	b_prefix := []byte(prefix)
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		var lim int = 1
-		c := tx.Bucket([]byte(resource)).Cursor()
-		if skip > 0 {
-			var skip_lim int = 1
-			var target_count int = skip - 1
-			for k, _ := c.Seek(b_prefix); k != nil; k, _ = c.Next() {
-				if skip_lim >= target_count {
-					break
-				}
-				skip_lim++
-			}
-		} else {
-			//no skip needed. Get first item
-			k, v := c.Seek(b_prefix)
-			if k != nil {
-				objs = append(objs, [][]byte{k, v})
-			} else {
-				return err
-			}
-			if lim == count {
-				return nil
-			}
-		}
-
-		for k, v := c.Next(); bytes.HasPrefix(k, b_prefix); k, v = c.Next() {
-			objs = append(objs, [][]byte{k, v})
-			lim++
-			if lim == count {
-				break
-			}
+	path := s.getBucketPath(resource)
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
 		}
+		walkRange(tx, b, path[0], prefix, hasPrefix(prefix), false, collectRows(&objs, count, skip))
 		return nil
 	})
 	return
@@ -530,17 +463,16 @@ func (s *BoltStore) _Filter(prefix []byte, count int, skip int, resource string)
 
 func (s *BoltStore) FilterSuffix(suffix []byte, count int, resource string) (objs [][]byte, err error) {
 	s.CreateBucket(resource)
-	b_prefix := []byte(suffix)
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		var lim int = 1
-		c := tx.Bucket([]byte(resource)).Cursor()
-		for k, v := c.Seek(b_prefix); bytes.HasPrefix(k, b_prefix); k, v = c.Next() {
-			objs = append(objs, v)
-			if lim == count {
-				break
-			}
-			lim++
+	path := s.getBucketPath(resource)
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
 		}
+		walkRange(tx, b, path[0], suffix, hasPrefix(suffix), false, func(k, v []byte) bool {
+			objs = append(objs, append([]byte{}, v...))
+			return len(objs) != count
+		})
 		return nil
 	})
 	return
@@ -549,20 +481,21 @@ func (s *BoltStore) FilterSuffix(suffix []byte, count int, resource string) (obj
 func (s *BoltStore) StreamFilter(key []byte, count int, resource string) chan []byte {
 
 	s.CreateBucket(resource)
+	path := s.getBucketPath(resource)
 	//Uses channels to stream filtered keys
 	ch := make(chan []byte)
 	go func() {
-		b_prefix := []byte(key)
-		s.Db.View(func(tx *boltdb.Tx) error {
-			var lim int = 1
-			c := tx.Bucket([]byte(resource)).Cursor()
-			for k, v := c.Seek(b_prefix); bytes.HasPrefix(k, b_prefix); k, v = c.Next() {
-				ch <- v
-				if lim == count {
-					break
-				}
-				lim++
+		s.view(func(tx *boltdb.Tx) error {
+			b, err := getBucket(tx, path)
+			if err != nil {
+				return err
 			}
+			sent := 0
+			walkRange(tx, b, path[0], key, hasPrefix(key), false, func(k, v []byte) bool {
+				ch <- append([]byte{}, v...)
+				sent++
+				return sent != count
+			})
 			return nil
 		})
 		close(ch)
@@ -576,36 +509,120 @@ func (s *BoltStore) StreamAll(count int, resource string) chan [][]byte {
 	//Uses channels to stream filtered keys
 	ch := make(chan [][]byte)
 	go func() {
-		s.Db.View(func(tx *boltdb.Tx) error {
-			var lim int = 1
-			c := tx.Bucket([]byte(resource)).Cursor()
-			for k, v := c.Last(); k != nil; k, v = c.Prev() {
-				ch <- [][]byte{k, v}
-				if lim == count {
-					break
-				}
-				lim++
+		s.view(func(tx *boltdb.Tx) error {
+			defer close(ch)
+			b := bucketAt(tx, s.getBucketPath(resource))
+			if b == nil {
+				return gostore.ErrNotFound
 			}
-			close(ch)
+			sent := 0
+			walkBucket(b, true, func(k, v []byte) bool {
+				ch <- [][]byte{append([]byte{}, k...), append([]byte{}, v...)}
+				sent++
+				return sent != count
+			})
 			return nil
 		})
 	}()
 	return ch
 }
 
+// Stats returns the number of rows in a bucket and its nested buckets
 func (s *BoltStore) Stats(bucket string) (data map[string]interface{}, err error) {
 	data = make(map[string]interface{})
-	err = s.Db.View(func(tx *boltdb.Tx) error {
-		v := tx.Bucket([]byte(bucket)).Stats()
-		data["total_count"] = v.KeyN
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, s.getBucketPath(bucket))
+		if err != nil {
+			return err
+		}
+		total := 0
+		walkBucket(b, false, func(k, v []byte) bool {
+			total++
+			return true
+		})
+		data["total_count"] = total
 		return nil
 	})
 	return
 }
 
+// AllWithinRange gets entries whose key or indexed fields fall within a range.
+// A range on the primary key "_id" is served by a cursor, other fields are
+// served by the index
 func (s *BoltStore) AllWithinRange(filter map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
-	return nil, gostore.ErrNotImplemented
+	ranges, err := indexer.ParseRanges(filter)
+	if err != nil {
+		return nil, err
+	}
+	var orderBy []string
+	if opts != nil {
+		orderBy = opts.GetOrderBy()
+	}
+	keyRange, fieldRanges := indexer.SplitKeyRange(ranges)
+	if keyRange != nil {
+		_rows, err := s.keyRange(*keyRange, fieldRanges, count, skip, store, indexer.IsReverseOrder(orderBy))
+		if err != nil {
+			return nil, err
+		}
+		if len(_rows) == 0 {
+			return nil, gostore.ErrNotFound
+		}
+		return newSyncRows(_rows), nil
+	}
+	if len(orderBy) == 0 {
+		orderBy = []string{"data." + fieldRanges[0].Field, "_id"}
+	}
+	q := indexer.GetQueryString(store, nil)
+	logger.Info("AllWithinRange", "count", count, "skip", skip, "Store", store, "query", q, "ranges", fieldRanges)
+	res, err := s.Indexer.RangeQuery(q, fieldRanges, count, skip, false, []string{"*"}, indexer.OrderRequest(orderBy))
+	if err != nil {
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, nil
 }
+
+// keyRange walks a bucket cursor over the keys within a range, filtering
+// values with any extra field ranges
+func (s *BoltStore) keyRange(keyRange indexer.Range, fieldRanges []indexer.Range, count int, skip int, resource string, reverse bool) (objs [][][]byte, err error) {
+	s.CreateBucket(resource)
+	path := s.getBucketPath(resource)
+	err = s.view(func(tx *boltdb.Tx) error {
+		b, err := getBucket(tx, path)
+		if err != nil {
+			return err
+		}
+		bound := keyRange.Min
+		if reverse {
+			bound = keyRange.Max
+		}
+		var seek []byte
+		if bound != nil {
+			seek = []byte(fmt.Sprintf("%v", bound))
+		}
+		collect := collectRows(&objs, count, skip)
+		walkRange(tx, b, path[0], seek, keyRange.CompareKey, reverse, func(k, v []byte) bool {
+			if len(objs) >= count {
+				return false
+			}
+			if len(fieldRanges) > 0 {
+				var doc map[string]interface{}
+				if err = json.Unmarshal(v, &doc); err != nil {
+					return false
+				}
+				if !indexer.MatchesRanges(doc, fieldRanges) {
+					return true
+				}
+			}
+			return collect(k, v)
+		})
+		return err
+	})
+	return
+}
+
 func (s *BoltStore) Since(id string, count int, skip int, store string) (gostore.ObjectRows, error) {
 	_rows, err := s._GetAllAfter([]byte(id), count, skip, store)
 	if err != nil {
@@ -652,9 +669,8 @@ func (s *BoltStore) Save(key, store string, src interface{}) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	err = s.Db.Update(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(store))
-		err := b.Put([]byte(key), data)
+	err = s.update(func(tx *boltdb.Tx) error {
+		err := s.putRow(tx, key, store, data, src)
 		if err != nil {
 			return err
 		}
@@ -662,6 +678,35 @@ func (s *BoltStore) Save(key, store string, src interface{}) (string, error) {
 	})
 	return key, err
 }
+
+// SaveWithGeo saves a row with the location at field copied to _location,
+// the location is indexed so the row can be found with geo queries
+func (s *BoltStore) SaveWithGeo(key, store string, src interface{}, field string) (string, error) {
+	srcMap, ok := src.(map[string]interface{})
+	if !ok {
+		return key, errors.New("unable to save")
+	}
+	if len(field) == 0 {
+		return s.Save(key, store, src)
+	}
+	location, err := common.ValForPath(field, srcMap)
+	if err != nil {
+		return key, err
+	}
+	srcMap["_location"] = location
+	data, err := json.Marshal(srcMap)
+	if err != nil {
+		return key, err
+	}
+	err = s.update(func(tx *boltdb.Tx) error {
+		if err := s.putRow(tx, key, store, data, srcMap); err != nil {
+			return err
+		}
+		return s.Indexer.IndexDocument(key, map[string]interface{}{"bucket": store, "data": srcMap, "location": location})
+	})
+	return key, err
+}
+
 func (s *BoltStore) SaveAll(store string, src ...interface{}) (keys []string, err error) {
 	return nil, gostore.ErrNotImplemented
 }
@@ -685,9 +730,8 @@ func (s *BoltStore) Update(key string, store string, src interface{}) error {
 		return err
 	}
 
-	err = s.Db.Update(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(store))
-		err := b.Put([]byte(key), data)
+	err = s.update(func(tx *boltdb.Tx) error {
+		err := s.putRow(tx, key, store, data, existing)
 		if err != nil {
 			return err
 		}
@@ -700,9 +744,8 @@ func (s *BoltStore) Replace(key string, store string, src interface{}) error {
 	if err != nil {
 		return err
 	}
-	err = s.Db.Update(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(store))
-		err := b.Put([]byte(key), data)
+	err = s.update(func(tx *boltdb.Tx) error {
+		err := s.putRow(tx, key, store, data, src)
 		if err != nil {
 			return err
 		}
@@ -712,9 +755,8 @@ func (s *BoltStore) Replace(key string, store string, src interface{}) error {
 }
 func (s *BoltStore) Delete(key string, store string) error {
 	logger.Info("_Delete", "key", key, "bucket", store)
-	err := s.Db.Update(func(tx *boltdb.Tx) error {
-		b := tx.Bucket([]byte(store))
-		err := b.Delete([]byte(key))
+	err := s.update(func(tx *boltdb.Tx) error {
+		err := s.deleteRow(tx, key, store)
 		if err != nil {
 			return err
 		}
@@ -750,11 +792,8 @@ func (s *BoltStore) FilterGet(filter map[string]interface{}, store string, dst i
 		// if res.Total > 1 {
 		// 	return gostore.ErrDuplicatePk
 		// }
-		if bucket, err := s.getBucketList(store, filter); err == nil {
-			data, err = s._NestedGet([]byte(res.Hits[0].ID), bucket)
-		} else {
-			data, err = s._Get(res.Hits[0].ID, store)
-		}
+		// rows in nested buckets are located by the key to path index
+		data, err = s._Get(res.Hits[0].ID, store)
 		if err != nil {
 			return err
 		}
@@ -765,6 +804,99 @@ func (s *BoltStore) FilterGet(filter map[string]interface{}, store string, dst i
 	return gostore.ErrNotFound
 
 }
+
+// GeoQuery query a geocapable indexer for rows within a distance of lon, lat.
+// Rows are ordered by opts, "_distance" orders by the distance from lon, lat
+// and each row has its distance in the unit of distance as "_distance"
+func (s *BoltStore) GeoQuery(lon, lat float64, distance string, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	q := "*"
+	if len(query) > 0 {
+		q = indexer.GetQueryString(store, query)
+	}
+	geoIndexer, ok := s.Indexer.(indexer.GeoCapableIndexer)
+	if !ok {
+		return nil, gostore.ErrNotImplemented
+	}
+	unit, err := indexer.DistanceUnit(distance)
+	if err != nil {
+		return nil, err
+	}
+	orderBy := []string{"-_score", "-_id"}
+	if opts != nil {
+		if o := opts.GetOrderBy(); len(o) > 0 {
+			orderBy = o
+		}
+	}
+	// the distance is always sorted on so it can be returned with each row
+	distanceAt := indexer.DistanceSortIndex(orderBy)
+	if distanceAt < 0 {
+		orderBy = append(append([]string{}, orderBy...), indexer.DistanceField)
+		distanceAt = len(orderBy) - 1
+	}
+	logger.Info("GeoQuery", "count", count, "skip", skip, "Store", store, "lat", lat, "lon", lon, "distance", distance, "query", q, "orderBy", orderBy)
+	res, err := geoIndexer.GeoDistanceQuery(q, lon, lat, distance, count, skip, true, []string{}, indexer.GeoOrderRequest(orderBy, geoIndexer.GeoField(), lon, lat, unit))
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	hitDistance := func(h *search.DocumentMatch) (float64, bool) {
+		return indexer.HitDistance(h, distanceAt)
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: hitDistance}, nil
+}
+
+// GeoBoundingBoxQuery query a geocapable indexer for rows of a store within a bounding box.
+// Rows are ordered by opts, "_distance" orders by the distance in meters from
+// the center of the box and adds it to each row
+func (s *BoltStore) GeoBoundingBoxQuery(topLeftLon, topLeftLat, bottomRightLon, bottomRightLat float64, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	res, distance, err := indexer.GeoBoundingBoxSearch(s.Indexer, store, query, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat, count, skip, opts)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: distance}, nil
+}
+
+// GeoPolygonQuery query a geocapable indexer for rows of a store within a polygon.
+// Rows are ordered by opts, "_distance" orders by the distance in meters from
+// the mean of the polygon's points and adds it to each row
+func (s *BoltStore) GeoPolygonQuery(polygon []geo.Point, query map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
+	res, distance, err := indexer.GeoPolygonSearch(s.Indexer, store, query, polygon, count, skip, opts)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s, distance: distance}, nil
+}
+
+// SimilarTo returns up to n rows of a store similar to the row with id,
+// compared by the significant terms of fields. The row itself is excluded
+func (s *BoltStore) SimilarTo(store, id string, fields []string, n int) (gostore.ObjectRows, error) {
+	logger.Info("SimilarTo", "store", store, "id", id, "fields", fields, "n", n)
+	var doc map[string]interface{}
+	if err := s.Get(id, store, &doc); err != nil {
+		return nil, err
+	}
+	res, err := s.Indexer.SimilarTo(store, id, doc, fields, n)
+	if err != nil {
+		logger.Warn("err", "error", err)
+		return nil, err
+	}
+	if res.Total == 0 {
+		return nil, gostore.ErrNotFound
+	}
+	return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, nil
+}
+
 func (s *BoltStore) FilterGetAll(filter map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
 
 	if query, ok := filter["q"].(map[string]interface{}); ok {
@@ -786,7 +918,58 @@ func (s *BoltStore) FilterGetAll(filter map[string]interface{}, count int, skip
 }
 
 func (s *BoltStore) Query(filter, aggregates map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, gostore.AggregateResult, error) {
-
+	if len(filter) > 0 {
+		var err error
+		var res *bleve.SearchResult
+		q := indexer.GetQueryString(store, filter)
+		order := indexer.OrderRequest([]string{"-_score", "-_id"})
+		if opts != nil {
+			if orderBy := opts.GetOrderBy(); len(orderBy) > 0 {
+				order = indexer.OrderRequest(orderBy)
+			}
+		}
+		facets, aggs := indexer.ParseAggregates(aggregates)
+		if len(aggregates) == 0 {
+			logger.Info("Query", "count", count, "skip", skip, "Store", store, "query", q, "order", order)
+			res, err = s.Indexer.QueryWithOptions(q, count, skip, true, []string{}, order)
+		} else {
+			logger.Info("Query", "count", count, "skip", skip, "Store", store, "query", q, "facets", facets, "orderBy", order)
+			res, err = s.Indexer.FacetedQuery(q, facets, count, skip, true, []string{}, order)
+		}
+		if err != nil {
+			logger.Warn("err", "error", err)
+			return nil, nil, err
+		}
+		agg, err := indexer.FacetResults(res, facets)
+		if err != nil {
+			return nil, nil, err
+		}
+		if res.Total > 0 && !aggs.Empty() {
+			metrics, err := indexer.Aggregate(s.Indexer, q, aggs, func(id string) (map[string]interface{}, error) {
+				row, err := s._Get(id, store)
+				if err != nil {
+					return nil, err
+				}
+				if row == nil {
+					return nil, gostore.ErrNotFound
+				}
+				var doc map[string]interface{}
+				err = json.Unmarshal(row[1], &doc)
+				return doc, err
+			})
+			if err != nil {
+				logger.Warn("err", "error", err)
+				return nil, nil, err
+			}
+			for k, v := range metrics {
+				agg[k] = v
+			}
+		}
+		if res.Total == 0 {
+			return nil, agg, gostore.ErrNotFound
+		}
+		return &SyncIndexRows{name: store, length: res.Total, result: res, bs: s}, agg, nil
+	}
 	return nil, nil, gostore.ErrNotFound
 }
 func (s *BoltStore) FilterDelete(filter map[string]interface{}, store string, opts gostore.ObjectStoreOptions) error {
@@ -812,9 +995,8 @@ func (s *BoltStore) FilterDelete(filter map[string]interface{}, store string, op
 				// 	break
 				// }
 				logger.Info("_Delete", "key", v.ID, "bucket", store)
-				err = s.Db.Update(func(tx *boltdb.Tx) error {
-					b := tx.Bucket([]byte(store))
-					err := b.Delete([]byte(v.ID))
+				err = s.update(func(tx *boltdb.Tx) error {
+					err := s.deleteRow(tx, v.ID, store)
 					if err != nil {
 						return err
 					}
@@ -848,42 +1030,145 @@ func (s *BoltStore) GetByFieldsByField(name, val, store string, fields []string,
 	return gostore.ErrNotImplemented
 }
 
+// batchKey returns the key of a row passed to a batch write, rows without an
+// id are given a new one
+func batchKey(src interface{}) string {
+	if _v, ok := src.(map[string]interface{}); ok {
+		if k, ok := _v["id"].(string); ok {
+			return k
+		}
+		key := gostore.NewObjectId().String()
+		_v["id"] = key
+		return key
+	} else if _v, ok := src.(HasID); ok {
+		return _v.GetId()
+	}
+	return gostore.NewObjectId().String()
+}
+
+// deleteKeys deletes keys from a store and the index in one transaction
+func (s *BoltStore) deleteKeys(keys []string, store string) error {
+	return s.update(func(tx *boltdb.Tx) error {
+		if bucketAt(tx, s.getBucketPath(store)) == nil {
+			return gostore.ErrNotFound
+		}
+		b := s.Indexer.BatchIndex()
+		for _, key := range keys {
+			if err := s.deleteRow(tx, key, store); err != nil {
+				return err
+			}
+			b.Delete(key)
+		}
+		return s.Indexer.Batch(b)
+	})
+}
+
+// BatchDelete deletes rows by id in a single transaction
 func (s *BoltStore) BatchDelete(ids []interface{}, store string, opts gostore.ObjectStoreOptions) (err error) {
-	return gostore.ErrNotImplemented
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		if k, ok := id.(string); ok {
+			keys[i] = k
+		} else {
+			keys[i] = fmt.Sprintf("%v", id)
+		}
+	}
+	logger.Info("BatchDelete", "store", store, "count", len(keys))
+	return s.deleteKeys(keys, store)
 }
 
+// BatchUpdate merges data into existing rows in a single transaction. Rows are
+// keyed by id when given, otherwise by the id of each row. A missing row
+// fails the whole batch
 func (s *BoltStore) BatchUpdate(id []interface{}, data []interface{}, store string, opts gostore.ObjectStoreOptions) error {
-	return gostore.ErrNotImplemented
+	if len(id) > 0 && len(id) != len(data) {
+		return fmt.Errorf("%d ids given for %d rows", len(id), len(data))
+	}
+	return s.update(func(tx *boltdb.Tx) error {
+		b := s.Indexer.BatchIndex()
+		for i, src := range data {
+			var key string
+			if len(id) > 0 {
+				key = fmt.Sprintf("%v", id[i])
+			} else {
+				key = batchKey(src)
+			}
+			current := s.getRow(tx, key, store)
+			if current == nil {
+				return gostore.ErrNotFound
+			}
+			var existing map[string]interface{}
+			if err := json.Unmarshal(current, &existing); err != nil {
+				return err
+			}
+			update, err := json.Marshal(src)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(update, &existing); err != nil {
+				return err
+			}
+			row, err := json.Marshal(existing)
+			if err != nil {
+				return err
+			}
+			if err := s.putRow(tx, key, store, row, existing); err != nil {
+				return err
+			}
+			if err := b.Index(key, IndexedData{store, existing}); err != nil {
+				return err
+			}
+		}
+		return s.Indexer.Batch(b)
+	})
 }
 
+// BatchFilterDelete deletes every row matching any of the filters in a single
+// transaction, each filter has its query under "q". ErrNotFound is returned
+// when no row matches
 func (s *BoltStore) BatchFilterDelete(filter []map[string]interface{}, store string, opts gostore.ObjectStoreOptions) error {
-	return gostore.ErrNotImplemented
+	keys := []string{}
+	seen := map[string]bool{}
+	for i, f := range filter {
+		query, ok := f["q"].(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("filter %d has no q query", i)
+		}
+		q := indexer.GetQueryString(store, query)
+		for from := 0; ; from += indexer.AggregatePageSize {
+			res, err := s.Indexer.QueryWithOptions(q, indexer.AggregatePageSize, from, false, []string{}, indexer.OrderRequest([]string{"_id"}))
+			if err != nil {
+				return err
+			}
+			for _, h := range res.Hits {
+				if !seen[h.ID] {
+					seen[h.ID] = true
+					keys = append(keys, h.ID)
+				}
+			}
+			if len(res.Hits) < indexer.AggregatePageSize {
+				break
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return gostore.ErrNotFound
+	}
+	logger.Info("BatchFilterDelete", "store", store, "count", len(keys))
+	return s.deleteKeys(keys, store)
 }
 
 func (s *BoltStore) BatchInsert(data []interface{}, store string, opts gostore.ObjectStoreOptions) (keys []string, err error) {
 	keys = make([]string, len(data))
-	err = s.Db.Update(func(tx *boltdb.Tx) error {
+	err = s.update(func(tx *boltdb.Tx) error {
 		b := s.Indexer.BatchIndex()
-		bkt := tx.Bucket([]byte(store))
 		for i, src := range data {
-			var key string
-			if _v, ok := src.(map[string]interface{}); ok {
-				if k, ok := _v["id"].(string); ok {
-					key = k
-				} else {
-					key = gostore.NewObjectId().String()
-					_v["id"] = key
-				}
-			} else if _v, ok := src.(HasID); ok {
-				key = _v.GetId()
-			} else {
-				key = gostore.NewObjectId().String()
-			}
+			key := batchKey(src)
 			data, err := json.Marshal(src)
 			if err != nil {
 				return err
 			}
-			err = bkt.Put([]byte(key), data)
+			err = s.putRow(tx, key, store, data, src)
 			if err != nil {
 				return err
 			}
@@ -898,13 +1183,51 @@ func (s *BoltStore) BatchInsert(data []interface{}, store string, opts gostore.O
 	})
 	return
 }
+
+// BatchInsertKV inserts raw key values in a single transaction without indexing them
 func (s *BoltStore) BatchInsertKV(rows [][][]byte, store string, opts gostore.ObjectStoreOptions) (keys []string, err error) {
-	return nil, gostore.ErrNotImplemented
+	keys = make([]string, len(rows))
+	err = s.update(func(tx *boltdb.Tx) error {
+		for i, row := range rows {
+			if err := s.putRow(tx, string(row[0]), store, row[1], nil); err != nil {
+				return err
+			}
+			keys[i] = string(row[0])
+		}
+		logger.Debug("copied", "rows", len(keys))
+		return nil
+	})
+	return
 }
+
+// BatchInsertKVAndIndex inserts raw key values in a single transaction, values
+// are parsed as json to index them in one batch
 func (s *BoltStore) BatchInsertKVAndIndex(rows [][][]byte, store string, opts gostore.ObjectStoreOptions) (keys []string, err error) {
-	return nil, gostore.ErrNotImplemented
+	keys = make([]string, len(rows))
+	err = s.update(func(tx *boltdb.Tx) error {
+		b := s.Indexer.BatchIndex()
+		for i, row := range rows {
+			key := string(row[0])
+			var iData map[string]interface{}
+			if err := json.Unmarshal(row[1], &iData); err != nil {
+				return err
+			}
+			if err := s.putRow(tx, key, store, row[1], iData); err != nil {
+				return err
+			}
+			if err := b.Index(key, IndexedData{store, iData}); err != nil {
+				return err
+			}
+			keys[i] = key
+		}
+		logger.Debug("copied", "rows", len(keys))
+		return s.Indexer.Batch(b)
+	})
+	return
 }
 func (s *BoltStore) Close() {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
 	if s.Db != nil {
 		s.Db.Close()
 	}
@@ -2,13 +2,22 @@ package bolt_test
 
 import (
 	"fmt"
+	boltdb "github.com/boltdb/bolt"
 	"github.com/osiloke/gostore"
+	"github.com/osiloke/gostore-contrib/backup"
 	. "github.com/osiloke/gostore-contrib/bolt"
+	"github.com/osiloke/gostore-contrib/common"
+	"github.com/osiloke/gostore-contrib/indexer"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func tempPath() string {
@@ -423,3 +432,667 @@ func TestAll(t *testing.T) {
 	}
 
 }
+
+func TestAllWithinRange(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	store := "data"
+	DB.CreateTable(store, nil)
+	for i, key := range []string{"a", "b", "c", "d", "e"} {
+		DB.Save(key, store, map[string]interface{}{
+			"id":    key,
+			"count": i,
+		})
+	}
+	collect := func(rows gostore.ObjectRows) []string {
+		ids := []string{}
+		for {
+			var row map[string]interface{}
+			if ok, _ := rows.Next(&row); !ok {
+				break
+			}
+			ids = append(ids, row["id"].(string))
+		}
+		return ids
+	}
+	Convey("Given a key range", t, func() {
+		rows, err := DB.AllWithinRange(map[string]interface{}{
+			"_id": map[string]interface{}{"gt": "a", "lte": "c"},
+		}, 10, 0, store, nil)
+		So(err, ShouldBeNil)
+		So(collect(rows), ShouldResemble, []string{"b", "c"})
+	})
+	Convey("Given a reverse key range", t, func() {
+		rows, err := DB.AllWithinRange(map[string]interface{}{
+			"_id": map[string]interface{}{"gte": "c"},
+		}, 2, 0, store, gostore.DefaultObjectStoreOptions{OrderBy: []string{"-_id"}})
+		So(err, ShouldBeNil)
+		So(collect(rows), ShouldResemble, []string{"e", "d"})
+	})
+	Convey("Given an indexed field range", t, func() {
+		rows, err := DB.AllWithinRange(map[string]interface{}{
+			"count": map[string]interface{}{"gte": 3},
+		}, 10, 0, store, nil)
+		So(err, ShouldBeNil)
+		So(collect(rows), ShouldResemble, []string{"d", "e"})
+	})
+}
+
+func TestSimilarTo(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("articles", nil)
+	DB.Save("1", "articles", map[string]interface{}{"id": "1", "title": "golang search engine"})
+	DB.Save("2", "articles", map[string]interface{}{"id": "2", "title": "building a search engine in golang"})
+	DB.Save("3", "articles", map[string]interface{}{"id": "3", "title": "baking bread"})
+
+	rows, err := DB.SimilarTo("articles", "1", []string{"title"}, 10)
+	assert.Nil(t, err)
+	var dst map[string]interface{}
+	ok, err := rows.Next(&dst)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, "2", dst["id"])
+	ok, _ = rows.Next(&dst)
+	assert.False(t, ok)
+
+	_, err = DB.SimilarTo("articles", "3", []string{"title"}, 10)
+	assert.Equal(t, gostore.ErrNotFound, err)
+}
+
+func TestQuery(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("orders", nil)
+	DB.Save("1", "orders", map[string]interface{}{"id": "1", "type": "order", "status": "paid", "amount": 10})
+	DB.Save("2", "orders", map[string]interface{}{"id": "2", "type": "order", "status": "paid", "amount": 30})
+	DB.Save("3", "orders", map[string]interface{}{"id": "3", "type": "order", "status": "open", "amount": 20})
+	DB.Save("4", "orders", map[string]interface{}{"id": "4", "type": "refund", "status": "paid", "amount": 5})
+
+	aggregates := map[string]interface{}{
+		"top": map[string]interface{}{
+			"status": map[string]interface{}{"field": "status", "count": 5},
+		},
+		"metrics": map[string]interface{}{
+			"total": map[string]interface{}{"field": "amount", "type": "sum"},
+		},
+	}
+	opts := gostore.DefaultObjectStoreOptions{OrderBy: []string{"-data.amount"}}
+	rows, agg, err := DB.Query(map[string]interface{}{"type": "order"}, aggregates, 10, 0, "orders", opts)
+	assert.Nil(t, err)
+	ids := []string{}
+	var dst map[string]interface{}
+	for {
+		if ok, _ := rows.Next(&dst); !ok {
+			break
+		}
+		ids = append(ids, dst["id"].(string))
+	}
+	assert.Equal(t, []string{"2", "3", "1"}, ids)
+
+	status := agg["status"].(gostore.Match)
+	assert.Equal(t, "status", status.Field)
+	assert.Equal(t, 3, status.Matched)
+	assert.Equal(t, 60.0, agg["total"].(indexer.MetricResult).Value)
+
+	_, _, err = DB.Query(map[string]interface{}{"type": "invoice"}, nil, 10, 0, "orders", nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+}
+
+func TestGeoQuery(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB, err := NewGeoWithPaths(boltPath, indexPath, nil)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("drivers", nil)
+	drivers := []struct {
+		id       string
+		lon, lat float64
+	}{
+		{"fremont", -121.989, 37.5483},
+		{"sunnyvale", -122.03, 37.3775},
+		{"sanjose", -121.8863, 37.3382},
+		{"washington", -77.0272, 38.8999},
+	}
+	for _, d := range drivers {
+		_, err := DB.SaveWithGeo(d.id, "drivers", map[string]interface{}{
+			"id":   d.id,
+			"type": "driver",
+			"home": map[string]interface{}{
+				"location": map[string]interface{}{"lat": d.lat, "lon": d.lon},
+			},
+		}, "home.location")
+		assert.Nil(t, err)
+	}
+	var saved map[string]interface{}
+	assert.Nil(t, DB.Get("fremont", "drivers", &saved))
+	assert.Equal(t, map[string]interface{}{"lat": 37.5483, "lon": -121.989}, saved["_location"])
+
+	type driver struct {
+		ID       string  `json:"id"`
+		Distance float64 `json:"_distance"`
+	}
+	opts := gostore.DefaultObjectStoreOptions{OrderBy: []string{"_distance"}}
+	rows, err := DB.GeoQuery(-121.989, 37.5483, "50km", map[string]interface{}{"type": "driver"}, 10, 0, "drivers", opts)
+	assert.Nil(t, err)
+	found := []driver{}
+	for {
+		var dst driver
+		if ok, _ := rows.Next(&dst); !ok {
+			break
+		}
+		found = append(found, dst)
+	}
+	if assert.Len(t, found, 3) {
+		assert.Equal(t, "fremont", found[0].ID)
+		assert.Equal(t, "sunnyvale", found[1].ID)
+		assert.Equal(t, "sanjose", found[2].ID)
+		assert.InDelta(t, 19.3, found[1].Distance, 0.1)
+		assert.InDelta(t, 25.1, found[2].Distance, 0.1)
+	}
+
+	_, err = DB.GeoQuery(-121.989, 37.5483, "50km", nil, 10, 0, "drivers", nil)
+	assert.Nil(t, err)
+
+	plainBolt, plainIndex := tempPath(), tempPath()
+	plain := getDB(plainBolt, plainIndex)
+	defer func() {
+		plain.Close()
+		os.Remove(plainBolt)
+		os.RemoveAll(plainIndex)
+	}()
+	_, err = plain.GeoQuery(-121.989, 37.5483, "50km", nil, 10, 0, "drivers", nil)
+	assert.Equal(t, gostore.ErrNotImplemented, err)
+}
+
+func TestBatchWrites(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	store := "people"
+	count := func(query map[string]interface{}) uint64 {
+		res, err := DB.Indexer.Query(indexer.GetQueryString(store, query))
+		assert.Nil(t, err)
+		return res.Total
+	}
+
+	// raw rows are only indexed by the AndIndex variant
+	keys, err := DB.BatchInsertKV([][][]byte{
+		{[]byte("1"), []byte(`{"id":"1","name":"osiloke","team":"red"}`)},
+		{[]byte("2"), []byte(`{"id":"2","name":"emike","team":"red"}`)},
+	}, store, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"1", "2"}, keys)
+	assert.Equal(t, uint64(0), count(map[string]interface{}{"team": "red"}))
+	_, err = DB.BatchInsertKVAndIndex([][][]byte{
+		{[]byte("1"), []byte(`{"id":"1","name":"osiloke","team":"red"}`)},
+		{[]byte("2"), []byte(`{"id":"2","name":"emike","team":"red"}`)},
+		{[]byte("3"), []byte(`{"id":"3","name":"oduffa","team":"blue"}`)},
+		{[]byte("4"), []byte(`{"id":"4","name":"tony","team":"blue"}`)},
+	}, store, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count(map[string]interface{}{"team": "red"}))
+
+	err = DB.BatchUpdate([]interface{}{"1", "3"}, []interface{}{
+		map[string]interface{}{"team": "green"},
+		map[string]interface{}{"team": "green"},
+	}, store, nil)
+	assert.Nil(t, err)
+	var row map[string]interface{}
+	assert.Nil(t, DB.Get("1", store, &row))
+	assert.Equal(t, map[string]interface{}{"id": "1", "name": "osiloke", "team": "green"}, row)
+	assert.Equal(t, uint64(2), count(map[string]interface{}{"team": "green"}))
+
+	// a missing row fails the whole batch
+	err = DB.BatchUpdate(nil, []interface{}{
+		map[string]interface{}{"id": "2", "team": "gold"},
+		map[string]interface{}{"id": "9", "team": "gold"},
+	}, store, nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+	assert.Nil(t, DB.Get("2", store, &row))
+	assert.Equal(t, "red", row["team"])
+
+	assert.Nil(t, DB.BatchDelete([]interface{}{"1", "2"}, store, nil))
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("1", store, &row))
+	assert.Equal(t, uint64(0), count(map[string]interface{}{"team": "red"}))
+
+	err = DB.BatchFilterDelete([]map[string]interface{}{
+		{"q": map[string]interface{}{"team": "blue"}},
+		{"q": map[string]interface{}{"team": "green"}},
+	}, store, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("3", store, &row))
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("4", store, &row))
+	err = DB.BatchFilterDelete([]map[string]interface{}{{"q": map[string]interface{}{"team": "blue"}}}, store, nil)
+	assert.Equal(t, gostore.ErrNotFound, err)
+	// filters must have their query under q
+	err = DB.BatchFilterDelete([]map[string]interface{}{{"team": "blue"}}, store, nil)
+	assert.EqualError(t, err, "filter 0 has no q query")
+}
+
+func TestNestedBuckets(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	// rows of tables without nested buckets have no key paths
+	DB.Save("1", "plain", map[string]interface{}{"id": "1"})
+	DB.Db.View(func(tx *boltdb.Tx) error {
+		assert.Nil(t, tx.Bucket([]byte("_paths")))
+		return nil
+	})
+	store := "orders"
+	err := DB.CreateTable(store, map[string]interface{}{
+		"nested": map[string]interface{}{"tenant": "[a-z]+"},
+	})
+	assert.Nil(t, err)
+	DB.Save("1", store, map[string]interface{}{"id": "1", "tenant": "acme", "item": "anvil"})
+	DB.Save("2", store, map[string]interface{}{"id": "2", "tenant": "globex", "item": "laser"})
+	DB.Save("3", store, map[string]interface{}{"id": "3", "tenant": "acme", "item": "rocket"})
+	DB.Save("4", store, map[string]interface{}{"id": "4", "item": "untenanted"})
+
+	cursor := func(store string) []string {
+		rows, err := DB.AllCursor(store)
+		assert.Nil(t, err)
+		cursorRows := rows.(*common.CursorRows)
+		defer cursorRows.Close()
+		keys := []string{}
+		for {
+			kv, err := cursorRows.NextKV()
+			if err != nil {
+				assert.Equal(t, gostore.ErrEOF, err)
+				return keys
+			}
+			keys = append(keys, string(kv[0]))
+		}
+	}
+	assert.Equal(t, []string{"1", "3"}, cursor("orders/acme"))
+	assert.Equal(t, []string{"4", "1", "3", "2"}, cursor(store))
+	all := func(count, skip int) []string {
+		rows, err := DB.All(count, skip, store)
+		assert.Nil(t, err)
+		keys := []string{}
+		for {
+			var row map[string]interface{}
+			if ok, _ := rows.Next(&row); !ok {
+				return keys
+			}
+			keys = append(keys, row["id"].(string))
+		}
+	}
+	assert.Equal(t, []string{"2", "3", "1", "4"}, all(10, 0))
+	assert.Equal(t, []string{"3", "1"}, all(2, 1))
+
+	var row map[string]interface{}
+	assert.Nil(t, DB.Get("1", store, &row))
+	assert.Equal(t, "anvil", row["item"])
+	assert.Nil(t, DB.Get("1", "orders/acme", &row))
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("1", "orders/globex", &row))
+
+	// moving a row to another tenant removes it from the previous sub bucket
+	assert.Nil(t, DB.Update("3", store, map[string]interface{}{"tenant": "globex"}))
+	assert.Equal(t, []string{"1"}, cursor("orders/acme"))
+	assert.Equal(t, []string{"2", "3"}, cursor("orders/globex"))
+
+	rows, _, err := DB.Query(map[string]interface{}{"item": "rocket"}, nil, 10, 0, store, nil)
+	assert.Nil(t, err)
+	ok, _ := rows.Next(&row)
+	assert.True(t, ok)
+	assert.Equal(t, "globex", row["tenant"])
+
+	assert.Nil(t, DB.Delete("1", store))
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("1", store, &row))
+	assert.Equal(t, []string{}, cursor("orders/acme"))
+
+	// the nested config and key paths survive reopening the store
+	DB.Close()
+	DB = getDB(boltPath, indexPath)
+	assert.Nil(t, DB.Get("3", store, &row))
+	DB.Save("5", store, map[string]interface{}{"id": "5", "tenant": "acme", "item": "magnet"})
+	assert.Equal(t, []string{"5"}, cursor("orders/acme"))
+}
+
+func TestNestedBucketRanges(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	store := "orders"
+	err := DB.CreateTable(store, map[string]interface{}{
+		"nested": map[string]interface{}{"tenant": "[a-z]+"},
+	})
+	assert.Nil(t, err)
+	DB.Save("1", store, map[string]interface{}{"id": "1", "tenant": "acme"})
+	DB.Save("2", store, map[string]interface{}{"id": "2", "tenant": "globex"})
+	DB.Save("3", store, map[string]interface{}{"id": "3", "tenant": "acme"})
+	DB.Save("4", store, map[string]interface{}{"id": "4"})
+
+	ids := func(rows gostore.ObjectRows, err error) []string {
+		assert.Nil(t, err)
+		keys := []string{}
+		for {
+			var row map[string]interface{}
+			if ok, _ := rows.Next(&row); !ok {
+				return keys
+			}
+			keys = append(keys, row["id"].(string))
+		}
+	}
+	// rows of nested buckets are included whatever the name of their bucket
+	assert.Equal(t, []string{"4", "3", "2"}, ids(DB.Since("2", 10, 0, store)))
+	assert.Equal(t, []string{"3"}, ids(DB.Since("2", 10, 0, "orders/acme")))
+	assert.Equal(t, []string{"3", "2"}, ids(DB.Since("2", 2, 1, store)))
+	assert.Equal(t, []string{"2", "3", "1"}, ids(DB.Before("3", 10, 0, store)))
+	assert.Equal(t, []string{"3", "1"}, ids(DB.Before("3", 10, 0, "orders/acme")))
+
+	keyRange := map[string]interface{}{
+		"_id": map[string]interface{}{"gte": "2", "lte": "3"},
+	}
+	assert.Equal(t, []string{"3", "2"}, ids(DB.AllWithinRange(keyRange, 10, 0, store, nil)))
+	assert.Equal(t, []string{"3"}, ids(DB.AllWithinRange(keyRange, 10, 0, "orders/acme", nil)))
+
+	total := func(bucket string) interface{} {
+		stats, err := DB.Stats(bucket)
+		assert.Nil(t, err)
+		return stats["total_count"]
+	}
+	assert.Equal(t, 4, total(store))
+	assert.Equal(t, 2, total("orders/acme"))
+	_, err = DB.Stats("orders/missing")
+	assert.Equal(t, gostore.ErrNotFound, err)
+
+	assert.Nil(t, DB.DeleteAll("orders/acme"))
+	var row map[string]interface{}
+	assert.Equal(t, gostore.ErrNotFound, DB.Get("1", store, &row))
+	assert.Equal(t, 0, total("orders/acme"))
+	assert.Equal(t, 2, total(store))
+	assert.Nil(t, DB.DeleteAll(store))
+	assert.Equal(t, 0, total(store))
+	assert.Equal(t, gostore.ErrNotFound, DB.DeleteAll("orders/missing"))
+}
+
+func TestCompact(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	compactPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.Remove(compactPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("orders", map[string]interface{}{
+		"nested": map[string]interface{}{"tenant": "[a-z]+"},
+	})
+	DB.CreateTable("empty", nil)
+	rows := make([]interface{}, 2000)
+	for i := range rows {
+		tenant := "acme"
+		if i%2 == 0 {
+			tenant = "globex"
+		}
+		rows[i] = map[string]interface{}{"id": fmt.Sprintf("%05d", i), "tenant": tenant, "note": strings.Repeat("x", 512)}
+	}
+	_, err := DB.BatchInsert(rows, "orders", nil)
+	assert.Nil(t, err)
+	ids := make([]interface{}, 1900)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%05d", i)
+	}
+	assert.Nil(t, DB.BatchDelete(ids, "orders", nil))
+
+	_, err = DB.Compact(compactPath, CompactFillPercent(2))
+	assert.NotNil(t, err)
+	report, err := DB.Compact(compactPath, CompactTxMaxSize(16<<10), CompactSwap())
+	assert.Nil(t, err)
+	assert.True(t, report.DstSize < report.SrcSize, "compacted file is not smaller")
+	assert.Equal(t, 50, report.Rows["orders/acme"])
+	assert.Equal(t, 50, report.Rows["orders/globex"])
+	assert.Equal(t, 0, report.Rows["empty"])
+	_, err = os.Stat(compactPath)
+	assert.True(t, os.IsNotExist(err))
+
+	info, err := os.Stat(boltPath)
+	assert.Nil(t, err)
+	assert.Equal(t, report.DstSize, info.Size())
+	var row map[string]interface{}
+	assert.Nil(t, DB.Get("01999", "orders", &row))
+	assert.Equal(t, "acme", row["tenant"])
+	_, err = DB.Save("02000", "orders", map[string]interface{}{"id": "02000", "tenant": "acme"})
+	assert.Nil(t, err)
+
+	// rows saved while compacting are in the swapped file
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 2001; i <= 2050; i++ {
+			id := fmt.Sprintf("%05d", i)
+			_, err := DB.Save(id, "orders", map[string]interface{}{"id": id, "tenant": "globex"})
+			assert.Nil(t, err)
+		}
+	}()
+	_, err = DB.Compact(compactPath, CompactSwap())
+	assert.Nil(t, err)
+	wg.Wait()
+	for i := 2001; i <= 2050; i++ {
+		assert.Nil(t, DB.Get(fmt.Sprintf("%05d", i), "orders", &row))
+	}
+	_, err = os.Stat(boltPath + ".uncompacted")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestBackup(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	archivePath := tempPath()
+	restoredPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.Remove(archivePath)
+		os.Remove(restoredPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("orders", map[string]interface{}{
+		"nested": map[string]interface{}{"tenant": "[a-z]+"},
+	})
+	DB.Save("o1", "orders", map[string]interface{}{"id": "o1", "tenant": "acme"})
+	DB.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+
+	_, err := DB.BackupToFile(archivePath, backup.Options{Since: 1})
+	assert.Equal(t, backup.ErrIncrementalNotSupported, err)
+	m, err := DB.BackupToFile(archivePath, backup.Options{Gzip: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "bolt", m.Store)
+	assert.Equal(t, []string{"orders", "users"}, m.Tables)
+	assert.True(t, m.Version > 0)
+	assert.NotNil(t, m.Index)
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = backup.Extract(f, func(m *backup.Manifest, data io.Reader) error {
+		dst, err := os.Create(restoredPath)
+		if err != nil {
+			return err
+		}
+		defer dst.Close()
+		_, err = io.Copy(dst, data)
+		return err
+	})
+	assert.Nil(t, err)
+	restored, err := NewDBOnly(restoredPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	var dst map[string]interface{}
+	assert.Nil(t, restored.Get("o1", "orders", &dst))
+	assert.Equal(t, "acme", dst["tenant"])
+	assert.Nil(t, restored.Get("u1", "users", &dst))
+	assert.Equal(t, "ada", dst["name"])
+}
+
+func TestRestore(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	archivePath := tempPath()
+	rawPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.Remove(archivePath)
+		os.Remove(rawPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.CreateTable("orders", map[string]interface{}{
+		"nested": map[string]interface{}{"tenant": "[a-z]+"},
+	})
+	DB.Save("o1", "orders", map[string]interface{}{"id": "o1", "tenant": "acme", "item": "anvil"})
+	DB.Save("o2", "orders", map[string]interface{}{"id": "o2", "tenant": "globex", "item": "rocket"})
+	DB.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+
+	iter, err := DB.Cursor()
+	assert.Nil(t, err)
+	keys := []string{}
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	assert.Equal(t, []string{"t$orders|o1", "t$orders|o2", "t$users|u1"}, keys)
+	iter.Seek([]byte("t$orders|"))
+	assert.Equal(t, "t$orders|o1", string(iter.Key()))
+	iter.Seek([]byte("t$users|u1"))
+	assert.Equal(t, "t$users|u1", string(iter.Key()))
+	iter.Seek([]byte("t$products|"))
+	assert.Equal(t, "t$users|u1", string(iter.Key()))
+	assert.Nil(t, iter.Close())
+
+	_, err = DB.BackupToFile(archivePath, backup.Options{Gzip: true})
+	assert.Nil(t, err)
+	f, err := os.Create(rawPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, DB.Db.View(func(tx *boltdb.Tx) error {
+		_, err := tx.WriteTo(f)
+		return err
+	}))
+	f.Close()
+
+	for _, path := range []string{archivePath, rawPath} {
+		restoredPath := tempPath()
+		restoredIndexPath := tempPath()
+		restored := getDB(restoredPath, restoredIndexPath)
+		restored.Save("s1", "orders", map[string]interface{}{"id": "s1", "item": "lantern"})
+		m, err := restored.RestoreBackup(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "bolt", m.Store)
+		var dst map[string]interface{}
+		// rows missing from the backup are removed from the index
+		doc, err := restored.Indexer.Index().Document("s1")
+		assert.Nil(t, err)
+		assert.Nil(t, doc)
+		docs, _ := restored.Indexer.Index().DocCount()
+		assert.Equal(t, uint64(3), docs)
+		assert.Nil(t, restored.FilterGet(map[string]interface{}{"q": map[string]interface{}{"item": "rocket"}}, "orders", &dst, nil))
+		assert.Equal(t, "o2", dst["id"])
+		// rows keep being saved in their nested bucket
+		restored.Save("o3", "orders", map[string]interface{}{"id": "o3", "tenant": "acme", "item": "magnet"})
+		rows, err := restored.AllCursor("orders/acme")
+		assert.Nil(t, err)
+		cursorRows := rows.(*common.CursorRows)
+		count := 0
+		for _, err := cursorRows.NextKV(); err == nil; _, err = cursorRows.NextKV() {
+			count++
+		}
+		cursorRows.Close()
+		assert.Equal(t, 2, count)
+		restored.Close()
+		os.Remove(restoredPath)
+		os.RemoveAll(restoredIndexPath)
+	}
+
+	// the geo field is recorded so restored stores keep geo search
+	geoPath := tempPath()
+	geoIndexPath := tempPath()
+	geoDB, err := NewGeoWithPaths(geoPath, geoIndexPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		geoDB.Close()
+		os.Remove(geoPath)
+		os.RemoveAll(geoIndexPath)
+	}()
+	m, err := geoDB.Backup(ioutil.Discard, backup.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, "location", m.Index.GeoField)
+}
+
+func TestBackupScheduler(t *testing.T) {
+	boltPath := tempPath()
+	indexPath := tempPath()
+	DB := getDB(boltPath, indexPath)
+	defer func() {
+		DB.Close()
+		os.Remove(boltPath)
+		os.RemoveAll(indexPath)
+	}()
+	DB.Save("u1", "users", map[string]interface{}{"id": "u1", "name": "ada"})
+
+	dest, err := backup.NewLocalDestination(t.TempDir())
+	assert.Nil(t, err)
+	s := backup.NewScheduler(DB, dest, backup.Every(time.Hour), backup.SchedulerName("bolt"), backup.SchedulerRetention(backup.Retention{Hourly: 1}))
+	m, err := s.RunNow()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"users"}, m.Tables)
+	status := s.Status()
+	assert.Equal(t, 1, status.Successes)
+	assert.Equal(t, m.Version, status.LastVersion)
+	_, err = backup.VerifyFile(filepath.Join(dest.Dir, status.LastArchive))
+	assert.Nil(t, err)
+}
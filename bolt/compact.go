@@ -0,0 +1,318 @@
+package bolt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	boltdb "github.com/boltdb/bolt"
+)
+
+// DefaultCompactFillPercent packs pages fuller than bolt does by default since
+// keys are copied in order
+const DefaultCompactFillPercent = 0.9
+
+// bolt clamps fill percents to this range
+const (
+	minFillPercent = 0.1
+	maxFillPercent = 1.0
+)
+
+// DefaultCompactTxMaxSize bytes copied before the compacted file is committed
+const DefaultCompactTxMaxSize = 64 << 20
+
+// CompactOption configures Compact
+type CompactOption func(*compactConfig)
+
+type compactConfig struct {
+	fillPercent float64
+	txMaxSize   int64
+	swap        bool
+}
+
+// CompactFillPercent sets how full the pages of the compacted file are,
+// between 0.1 and 1
+func CompactFillPercent(fillPercent float64) CompactOption {
+	return func(c *compactConfig) {
+		c.fillPercent = fillPercent
+	}
+}
+
+// CompactTxMaxSize sets the bytes copied per transaction, 0 copies everything
+// in a single transaction
+func CompactTxMaxSize(size int64) CompactOption {
+	return func(c *compactConfig) {
+		c.txMaxSize = size
+	}
+}
+
+// CompactSwap replaces the store file with the compacted file and reopens it.
+// Reads and writes wait until the file is compacted and swapped
+func CompactSwap() CompactOption {
+	return func(c *compactConfig) {
+		c.swap = true
+	}
+}
+
+// CompactReport the sizes of a store before and after compacting it and the
+// rows in each bucket, nested buckets are keyed by their path
+type CompactReport struct {
+	SrcSize int64          `json:"srcSize"`
+	DstSize int64          `json:"dstSize"`
+	Rows    map[string]int `json:"rows"`
+}
+
+// Buckets returns the bucket paths of the report in order
+func (r *CompactReport) Buckets() []string {
+	buckets := make([]string, 0, len(r.Rows))
+	for bucket := range r.Rows {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+// Compact copies every bucket, including nested buckets, into a new file at
+// dstPath. The copy is verified by counting the rows of each bucket in both
+// files, with CompactSwap the compacted file then replaces the store file
+func (s *BoltStore) Compact(dstPath string, opts ...CompactOption) (*CompactReport, error) {
+	config := &compactConfig{fillPercent: DefaultCompactFillPercent, txMaxSize: DefaultCompactTxMaxSize}
+	for _, opt := range opts {
+		opt(config)
+	}
+	if config.fillPercent < minFillPercent || config.fillPercent > maxFillPercent {
+		return nil, fmt.Errorf("fill percent %v is not between %v and %v", config.fillPercent, minFillPercent, maxFillPercent)
+	}
+	if _, err := os.Stat(dstPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", dstPath)
+	}
+	// rows written after the copy would be lost by the swap
+	if config.swap {
+		s.dbMu.Lock()
+		defer s.dbMu.Unlock()
+	} else {
+		s.dbMu.RLock()
+		defer s.dbMu.RUnlock()
+	}
+	srcPath := s.Db.Path()
+	srcInfo, err := os.Stat(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := boltdb.Open(dstPath, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("compacting", "src", srcPath, "dst", dstPath, "fillPercent", config.fillPercent)
+	report := &CompactReport{SrcSize: srcInfo.Size()}
+	err = s.Db.View(func(tx *boltdb.Tx) error {
+		c := &compactor{dst: dst, fillPercent: config.fillPercent, txMaxSize: config.txMaxSize}
+		if err := c.copy(tx); err != nil {
+			return err
+		}
+		report.Rows = countRows(tx)
+		return nil
+	})
+	if err == nil {
+		err = dst.View(func(tx *boltdb.Tx) error {
+			return verifyRows(report.Rows, countRows(tx))
+		})
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dstPath)
+		return nil, err
+	}
+	dstInfo, err := os.Stat(dstPath)
+	if err != nil {
+		return nil, err
+	}
+	report.DstSize = dstInfo.Size()
+	logger.Info("compacted", "src", srcPath, "srcSize", report.SrcSize, "dst", dstPath, "dstSize", report.DstSize)
+	if config.swap {
+		if err := s.swapFile(dstPath); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// swapFile replaces the store file with path and reopens the store, the
+// uncompacted file is kept until the compacted file opens and restored when
+// it does not. Callers hold dbMu for writing
+func (s *BoltStore) swapFile(path string) error {
+	srcPath := s.Db.Path()
+	oldPath := srcPath + ".uncompacted"
+	if err := s.Db.Close(); err != nil {
+		return err
+	}
+	reopen := func(err error) error {
+		// keep serving the uncompacted file
+		db, oerr := boltdb.Open(srcPath, 0600, nil)
+		if oerr != nil {
+			return fmt.Errorf("%v, reopening %s failed: %v", err, srcPath, oerr)
+		}
+		s.Db = db
+		return err
+	}
+	if err := os.Rename(srcPath, oldPath); err != nil {
+		return reopen(err)
+	}
+	if err := os.Rename(path, srcPath); err != nil {
+		if rerr := os.Rename(oldPath, srcPath); rerr != nil {
+			return fmt.Errorf("%v, restoring %s failed: %v", err, oldPath, rerr)
+		}
+		return reopen(err)
+	}
+	db, err := boltdb.Open(srcPath, 0600, nil)
+	if err != nil {
+		if rerr := os.Rename(oldPath, srcPath); rerr != nil {
+			return fmt.Errorf("%v, restoring %s failed: %v", err, oldPath, rerr)
+		}
+		return reopen(err)
+	}
+	s.Db = db
+	if err := os.Remove(oldPath); err != nil {
+		logger.Warn("unable to remove uncompacted file", "path", oldPath, "err", err)
+	}
+	logger.Info("swapped store file", "path", srcPath)
+	return nil
+}
+
+// compactor writes buckets to the compacted file, committing whenever
+// txMaxSize bytes were written
+type compactor struct {
+	dst         *boltdb.DB
+	tx          *boltdb.Tx
+	size        int64
+	fillPercent float64
+	txMaxSize   int64
+}
+
+func (c *compactor) copy(src *boltdb.Tx) (err error) {
+	if c.tx, err = c.dst.Begin(true); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			c.tx.Rollback()
+		}
+	}()
+	err = src.ForEach(func(name []byte, b *boltdb.Bucket) error {
+		return c.copyBucket([][]byte{name}, b)
+	})
+	if err != nil {
+		return err
+	}
+	return c.tx.Commit()
+}
+
+func (c *compactor) copyBucket(path [][]byte, src *boltdb.Bucket) error {
+	b, err := c.bucket(path)
+	if err != nil {
+		return err
+	}
+	if err := b.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	cur := src.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		if v == nil {
+			if nested := src.Bucket(k); nested != nil {
+				if err := c.copyBucket(append(path[:len(path):len(path)], k), nested); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if err := c.put(path, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucket returns the bucket at path in the current transaction
+func (c *compactor) bucket(path [][]byte) (*boltdb.Bucket, error) {
+	b, err := c.tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b.FillPercent = c.fillPercent
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.FillPercent = c.fillPercent
+	return b, nil
+}
+
+func (c *compactor) put(path [][]byte, k, v []byte) error {
+	size := int64(len(k) + len(v))
+	if c.txMaxSize > 0 && c.size+size > c.txMaxSize {
+		if err := c.tx.Commit(); err != nil {
+			return err
+		}
+		tx, err := c.dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		c.tx, c.size = tx, 0
+	}
+	b, err := c.bucket(path)
+	if err != nil {
+		return err
+	}
+	c.size += size
+	return b.Put(k, v)
+}
+
+// countRows counts the rows of every bucket keyed by bucket path
+func countRows(tx *boltdb.Tx) map[string]int {
+	rows := map[string]int{}
+	var count func(path string, b *boltdb.Bucket)
+	count = func(path string, b *boltdb.Bucket) {
+		// empty buckets are counted too
+		rows[path] = 0
+		b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				if nested := b.Bucket(k); nested != nil {
+					count(path+pathSeparator+string(k), nested)
+					return nil
+				}
+			}
+			rows[path]++
+			return nil
+		})
+	}
+	tx.ForEach(func(name []byte, b *boltdb.Bucket) error {
+		count(string(name), b)
+		return nil
+	})
+	return rows
+}
+
+// verifyRows checks two files have the same rows in each bucket
+func verifyRows(src, dst map[string]int) error {
+	mismatched := []string{}
+	for bucket, n := range src {
+		if dst[bucket] != n {
+			mismatched = append(mismatched, fmt.Sprintf("%s has %d rows instead of %d", bucket, dst[bucket], n))
+		}
+	}
+	for bucket := range dst {
+		if _, ok := src[bucket]; !ok {
+			mismatched = append(mismatched, fmt.Sprintf("%s is not in the source", bucket))
+		}
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("compacted file does not match: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
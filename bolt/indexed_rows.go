@@ -6,8 +6,9 @@ import (
 	"sync"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search"
 	"github.com/osiloke/gostore"
-	// "github.com/osiloke/gostore-contrib/indexer"
+	"github.com/osiloke/gostore-contrib/indexer"
 )
 
 type NextItem struct {
@@ -134,6 +135,17 @@ type SyncIndexRows struct {
 	bs        *BoltStore
 	ci        uint64
 	lastError error
+	distance  func(*search.DocumentMatch) (float64, bool)
+}
+
+// addDistance adds the distance of the hit to its row when rows are from a geo query
+func (s *SyncIndexRows) addDistance(h *search.DocumentMatch, row [][]byte) {
+	if s.distance == nil {
+		return
+	}
+	if d, ok := s.distance(h); ok {
+		row[1] = indexer.WithField(row[1], indexer.DistanceField, d)
+	}
 }
 
 // Next get next item
@@ -143,6 +155,7 @@ func (s *SyncIndexRows) Next(dst interface{}) (bool, error) {
 		h := s.result.Hits[s.ci]
 		row, err := s.bs._Get(h.ID, s.name)
 		if err == nil {
+			s.addDistance(h, row)
 			err = json.Unmarshal(row[1], dst)
 			if err == nil {
 				s.ci++
@@ -170,6 +183,7 @@ func (s *SyncIndexRows) NextRaw() ([]byte, bool) {
 		row, err := s.bs._Get(h.ID, s.name)
 		if err == nil {
 			s.ci++
+			s.addDistance(h, row)
 			return row[1], true
 		}
 		if err == gostore.ErrNotFound {
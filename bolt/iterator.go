@@ -1 +1,132 @@
 package bolt
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	boltdb "github.com/boltdb/bolt"
+	"github.com/osiloke/gostore-contrib/common"
+)
+
+// Iterator iterates the rows of every table, including rows of nested
+// buckets, in a read transaction. Keys have the t$<table>|<id> form of badger
+// keys so the indexer can reindex a bolt store
+type Iterator struct {
+	tx      *boltdb.Tx
+	dbMu    *sync.RWMutex
+	tables  []string
+	table   int
+	cursors []*boltdb.Cursor
+	key     []byte
+	value   []byte
+}
+
+// Cursor returns an iterator over the rows of every table, Close releases its
+// read transaction
+func (s *BoltStore) Cursor() (common.Iterator, error) {
+	s.dbMu.RLock()
+	tx, err := s.Db.Begin(false)
+	if err != nil {
+		s.dbMu.RUnlock()
+		return nil, err
+	}
+	i := &Iterator{tx: tx, dbMu: s.dbMu, tables: tables(tx), table: -1}
+	i.nextTable()
+	return i, nil
+}
+
+// nextTable moves to the first row of the next table with rows
+func (i *Iterator) nextTable() {
+	for i.table++; i.table < len(i.tables); i.table++ {
+		c := i.tx.Bucket([]byte(i.tables[i.table])).Cursor()
+		i.cursors = []*boltdb.Cursor{c}
+		if i.settle(c.First()) {
+			return
+		}
+	}
+	i.cursors, i.key, i.value = nil, nil, nil
+}
+
+// settle moves from k into nested buckets and out of exhausted ones until it
+// lands on a row, it returns false when the table has no more rows
+func (i *Iterator) settle(k, v []byte) bool {
+	for len(i.cursors) > 0 {
+		c := i.cursors[len(i.cursors)-1]
+		if k == nil {
+			i.cursors = i.cursors[:len(i.cursors)-1]
+			if len(i.cursors) > 0 {
+				k, v = i.cursors[len(i.cursors)-1].Next()
+			}
+			continue
+		}
+		if v == nil {
+			if nested := c.Bucket().Bucket(k); nested != nil {
+				nc := nested.Cursor()
+				i.cursors = append(i.cursors, nc)
+				k, v = nc.First()
+				continue
+			}
+		}
+		i.key = []byte("t$" + i.tables[i.table] + "|" + string(k))
+		i.value = v
+		return true
+	}
+	return false
+}
+
+// Seek moves to the row of a t$<table>|<id> key or the row after it in the
+// table, the first row of the next table when the table has no such row. Ids
+// are compared with the keys of the table bucket, rows of nested buckets are
+// found by seeking to the t$<table>| prefix
+func (i *Iterator) Seek(key []byte) {
+	table, id, _ := strings.Cut(strings.TrimPrefix(string(key), "t$"), "|")
+	i.table = sort.SearchStrings(i.tables, table)
+	if i.table < len(i.tables) && i.tables[i.table] == table {
+		c := i.tx.Bucket([]byte(table)).Cursor()
+		i.cursors = []*boltdb.Cursor{c}
+		if !i.settle(c.Seek([]byte(id))) {
+			i.nextTable()
+		}
+		return
+	}
+	// nextTable moves on to the first table after key
+	i.table--
+	i.nextTable()
+}
+
+func (i *Iterator) Next() {
+	if len(i.cursors) == 0 {
+		return
+	}
+	if !i.settle(i.cursors[len(i.cursors)-1].Next()) {
+		i.nextTable()
+	}
+}
+
+func (i *Iterator) Current() ([]byte, []byte, bool) {
+	if i.Valid() {
+		return i.Key(), i.Value(), true
+	}
+	return nil, nil, false
+}
+
+func (i *Iterator) Key() []byte {
+	return i.key
+}
+
+func (i *Iterator) Value() []byte {
+	return append([]byte{}, i.value...)
+}
+
+func (i *Iterator) Valid() bool {
+	return i.key != nil
+}
+
+func (i *Iterator) Close() error {
+	err := i.tx.Rollback()
+	if err != boltdb.ErrTxClosed {
+		i.dbMu.RUnlock()
+	}
+	return err
+}
@@ -0,0 +1,303 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	boltdb "github.com/boltdb/bolt"
+	"github.com/osiloke/gostore-contrib/common"
+)
+
+// tablesBucket persists the nested bucket config of tables
+const tablesBucket = "_tables"
+
+// pathsBucket holds a bucket per table mapping the keys of rows saved in
+// nested buckets to the path of their bucket
+const pathsBucket = "_paths"
+
+// pathSeparator separates the buckets of a nested bucket path such as
+// orders/tenant1
+const pathSeparator = "/"
+
+// loadTableConfig loads the nested bucket config of every table
+func (s *BoltStore) loadTableConfig() error {
+	return s.view(func(tx *boltdb.Tx) error {
+		b := tx.Bucket([]byte(tablesBucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var nested map[string]string
+			if err := json.Unmarshal(v, &nested); err != nil {
+				return err
+			}
+			config, err := newTableConfig(nested)
+			if err != nil {
+				return err
+			}
+			s.tableConfig[string(k)] = config
+			return nil
+		})
+	})
+}
+
+// saveTableConfig persists the nested bucket config of a table
+func (s *BoltStore) saveTableConfig(table string, nested map[string]string) error {
+	data, err := json.Marshal(nested)
+	if err != nil {
+		return err
+	}
+	return s.update(func(tx *boltdb.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(tablesBucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(table), data)
+	})
+}
+
+func newTableConfig(nested map[string]string) (*TableConfig, error) {
+	nbfm := make(map[string]*regexp.Regexp)
+	for field, expr := range nested {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("nested field %s: %w", field, err)
+		}
+		nbfm[field] = re
+	}
+	return &TableConfig{NestedBucketFieldMatcher: nbfm}, nil
+}
+
+// getBucketList returns the nested bucket path of a document, each match of
+// the regex of a nested field in its value is a sub bucket. Fields are tried
+// in name order and documents without a match are not nested
+func (s *BoltStore) getBucketList(store string, data map[string]interface{}) (bucket []string, err error) {
+	config, ok := s.tableConfig[store]
+	if !ok {
+		return nil, ErrNoNestedBuckets
+	}
+	fields := make([]string, 0, len(config.NestedBucketFieldMatcher))
+	for field := range config.NestedBucketFieldMatcher {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value, err := common.ValForPath(field, data)
+		if err != nil || value == nil {
+			continue
+		}
+		matches := config.NestedBucketFieldMatcher[field].FindAllString(fmt.Sprintf("%v", value), -1)
+		if len(matches) > 0 {
+			return append([]string{store}, matches...), nil
+		}
+	}
+	return nil, ErrNoNestedBuckets
+}
+
+// bucketAt returns the bucket at a path or nil when it does not exist
+func bucketAt(tx *boltdb.Tx, path []string) *boltdb.Bucket {
+	b := tx.Bucket([]byte(path[0]))
+	for _, name := range path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(name))
+	}
+	return b
+}
+
+// createBucketAt returns the bucket at a path, creating missing buckets
+func createBucketAt(tx *boltdb.Tx, path []string) (*boltdb.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+	for _, name := range path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b, err = b.CreateBucketIfNotExists([]byte(name))
+	}
+	return b, err
+}
+
+// rowPath returns the nested bucket path of a row or nil if it is not nested
+func rowPath(tx *boltdb.Tx, table, key string) []string {
+	b := bucketAt(tx, []string{pathsBucket, table})
+	if b == nil {
+		return nil
+	}
+	if v := b.Get([]byte(key)); v != nil {
+		return strings.Split(string(v), pathSeparator)
+	}
+	return nil
+}
+
+// locate returns the bucket path of a row in store, rows of a table are
+// looked up in the key to path index
+func (s *BoltStore) locate(tx *boltdb.Tx, key, store string) []string {
+	path := s.getBucketPath(store)
+	if len(path) == 1 {
+		if nested := rowPath(tx, store, key); nested != nil {
+			return nested
+		}
+	}
+	return path
+}
+
+// getRow returns the value of a row in store or nil
+func (s *BoltStore) getRow(tx *boltdb.Tx, key, store string) []byte {
+	b := bucketAt(tx, s.locate(tx, key, store))
+	if b == nil {
+		return nil
+	}
+	return b.Get([]byte(key))
+}
+
+// putRow saves a row in the nested bucket of src or in store, a row moved to
+// another bucket is removed from its previous one
+func (s *BoltStore) putRow(tx *boltdb.Tx, key, store string, data []byte, src interface{}) error {
+	path := s.getBucketPath(store)
+	if _, ok := s.tableConfig[store]; ok && len(path) == 1 {
+		doc, ok := src.(map[string]interface{})
+		if !ok {
+			if err := json.Unmarshal(data, &doc); err != nil {
+				return err
+			}
+		}
+		if nested, err := s.getBucketList(store, doc); err == nil {
+			path = nested
+		}
+	}
+	table := path[0]
+	previous := rowPath(tx, table, key)
+	if previous == nil {
+		previous = []string{table}
+	}
+	if strings.Join(previous, pathSeparator) != strings.Join(path, pathSeparator) {
+		if err := deleteKey(bucketAt(tx, previous), key); err != nil {
+			return err
+		}
+	}
+	b, err := createBucketAt(tx, path)
+	if err != nil {
+		return err
+	}
+	if err := b.Put([]byte(key), data); err != nil {
+		return err
+	}
+	if len(path) == 1 {
+		return deleteKey(bucketAt(tx, []string{pathsBucket, table}), key)
+	}
+	paths, err := createBucketAt(tx, []string{pathsBucket, table})
+	if err != nil {
+		return err
+	}
+	return paths.Put([]byte(key), []byte(strings.Join(path, pathSeparator)))
+}
+
+// deleteRow deletes a row from the bucket it was saved in
+func (s *BoltStore) deleteRow(tx *boltdb.Tx, key, store string) error {
+	path := s.locate(tx, key, store)
+	if err := deleteKey(bucketAt(tx, path), key); err != nil {
+		return err
+	}
+	return deleteKey(bucketAt(tx, []string{pathsBucket, path[0]}), key)
+}
+
+// deleteKey deletes a key from a bucket if it holds a value. Bolt fails to
+// delete a missing key when the cursor lands on a nested bucket
+func deleteKey(b *boltdb.Bucket, key string) error {
+	if b == nil || b.Get([]byte(key)) == nil {
+		return nil
+	}
+	return b.Delete([]byte(key))
+}
+
+// walkBucket calls fn with every row of a bucket and its nested buckets, in
+// reverse key order if reverse, until fn returns false. It returns false when
+// the walk was stopped
+func walkBucket(b *boltdb.Bucket, reverse bool, fn func(k, v []byte) bool) bool {
+	c := b.Cursor()
+	first, next := c.First, c.Next
+	if reverse {
+		first, next = c.Last, c.Prev
+	}
+	for k, v := first(); k != nil; k, v = next() {
+		if v == nil {
+			if nested := b.Bucket(k); nested != nil {
+				if !walkBucket(nested, reverse, fn) {
+					return false
+				}
+				continue
+			}
+		}
+		if !fn(k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// walkRange calls fn with the rows of a bucket whose keys are within a range,
+// in reverse key order if reverse, until fn returns false. cmp returns -1 for
+// keys below the range, 1 for keys above it and 0 for keys within it. The
+// cursor starts at seek unless rows of table are saved in nested buckets,
+// those are walked whole since the keys of their rows are unrelated to the
+// names of the buckets
+func walkRange(tx *boltdb.Tx, b *boltdb.Bucket, table string, seek []byte, cmp func([]byte) int, reverse bool, fn func(k, v []byte) bool) {
+	if bucketAt(tx, []string{pathsBucket, table}) != nil {
+		walkBucket(b, reverse, func(k, v []byte) bool {
+			return cmp(k) != 0 || fn(k, v)
+		})
+		return
+	}
+	c := b.Cursor()
+	var k, v []byte
+	if seek != nil {
+		k, v = c.Seek(seek)
+	}
+	next, past := c.Next, 1
+	if reverse {
+		next, past = c.Prev, -1
+		if k == nil {
+			k, v = c.Last()
+		}
+	} else if seek == nil {
+		k, v = c.First()
+	}
+	for ; k != nil; k, v = next() {
+		at := cmp(k)
+		if at == past {
+			return
+		}
+		// nested buckets have no value
+		if at == 0 && v != nil && !fn(k, v) {
+			return
+		}
+	}
+}
+
+// hasPrefix compares keys to the range of keys starting with prefix
+func hasPrefix(prefix []byte) func([]byte) int {
+	return func(k []byte) int {
+		if bytes.HasPrefix(k, prefix) {
+			return 0
+		}
+		return bytes.Compare(k, prefix)
+	}
+}
+
+// collectRows returns a walk func which appends copies of count rows to objs
+// after skipping skip rows
+func collectRows(objs *[][][]byte, count, skip int) func(k, v []byte) bool {
+	return func(k, v []byte) bool {
+		if skip > 0 {
+			skip--
+			return true
+		}
+		*objs = append(*objs, [][]byte{append([]byte{}, k...), append([]byte{}, v...)})
+		return len(*objs) != count
+	}
+}
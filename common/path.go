@@ -1,4 +1,4 @@
-package badger
+package common
 
 import (
 	"fmt"
@@ -6,7 +6,9 @@ import (
 	"strings"
 )
 
-func valForPath(key string, s interface{}) (v interface{}, err error) {
+// ValForPath gets the value at a dotted path such as home.location, numeric
+// keys index arrays
+func ValForPath(key string, s interface{}) (v interface{}, err error) {
 	keys := strings.Split(key, ".")
 
 	var value interface{} = s
@@ -20,6 +22,7 @@ func valForPath(key string, s interface{}) (v interface{}, err error) {
 	}
 	return nil, err
 }
+
 func getPath(key string, s interface{}) (v interface{}, err error) {
 	var (
 		i  int64
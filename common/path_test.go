@@ -1,4 +1,4 @@
-package badger
+package common
 
 import (
 	"reflect"
@@ -57,7 +57,7 @@ func Test_getPath(t *testing.T) {
 	}
 }
 
-func Test_valForPath(t *testing.T) {
+func TestValForPath(t *testing.T) {
 	type args struct {
 		key string
 		s   interface{}
@@ -96,13 +96,13 @@ func Test_valForPath(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotV, err := valForPath(tt.args.key, tt.args.s)
+			gotV, err := ValForPath(tt.args.key, tt.args.s)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("valForPath() error = %v, wantErr %v", err, tt.wantErr)
+				t.Errorf("ValForPath() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			if !reflect.DeepEqual(gotV, tt.wantV) {
-				t.Errorf("valForPath() = %v, want %v", gotV, tt.wantV)
+				t.Errorf("ValForPath() = %v, want %v", gotV, tt.wantV)
 			}
 		})
 	}
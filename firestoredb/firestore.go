@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 
 	"cloud.google.com/go/firestore"
@@ -141,8 +142,32 @@ func (k *Firestore) AllCursor(store string) (gostore.ObjectRows, error) {
 
 // AllWithinRange returns all entries in a collection within a range
 func (k *Firestore) AllWithinRange(filter map[string]interface{}, count int, skip int, store string, opts gostore.ObjectStoreOptions) (gostore.ObjectRows, error) {
-
-	return nil, gostore.ErrNotImplemented
+	ranges, err := indexer.ParseRanges(filter)
+	if err != nil {
+		return nil, err
+	}
+	col := k.fs.Collection(store)
+	q := col.Query
+	for _, v := range RangeQueries(ranges) {
+		if v.field == firestore.DocumentID {
+			v.val = col.Doc(fmt.Sprintf("%v", v.val))
+		}
+		q = q.Where(v.field, v.op, v.val)
+	}
+	var orderBy []string
+	if opts != nil {
+		orderBy = opts.GetOrderBy()
+	}
+	if len(orderBy) > 0 {
+		col.Query = q
+		col = OrderQuery(orderBy, col)
+		q = col.Query
+	} else {
+		// firestore requires the first order by to match the range field
+		q = q.OrderBy(rangeField(ranges[0]), firestore.Asc)
+	}
+	iter := q.Limit(count).Offset(skip).Documents(k.ctx)
+	return &TransactionRows{iter, nil}, nil
 }
 
 // Since returns rows greater than a specific row
@@ -5,6 +5,9 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/osiloke/gostore-contrib/indexer"
 )
 
 func reduceValueLenght(v string) string {
@@ -116,3 +119,33 @@ func floatVal(v interface{}) float64 {
 	}
 	return float64(v.(int))
 }
+
+func rangeField(r indexer.Range) string {
+	if r.Field == indexer.KeyField {
+		return firestore.DocumentID
+	}
+	return r.Field
+}
+
+// RangeQueries converts ranges to firestore where clauses
+func RangeQueries(ranges []indexer.Range) []query {
+	queries := []query{}
+	for _, r := range ranges {
+		field := rangeField(r)
+		if r.Min != nil {
+			op := ">"
+			if r.MinInclusive {
+				op = ">="
+			}
+			queries = append(queries, query{field, op, r.Min})
+		}
+		if r.Max != nil {
+			op := "<"
+			if r.MaxInclusive {
+				op = "<="
+			}
+			queries = append(queries, query{field, op, r.Max})
+		}
+	}
+	return queries
+}
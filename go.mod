@@ -5,8 +5,8 @@ go 1.19
 require (
 	cloud.google.com/go/firestore v1.4.0
 	firebase.google.com/go v3.8.1+incompatible
-	github.com/blevesearch/bleve v1.0.14
 	github.com/blevesearch/bleve/v2 v2.3.7
+	github.com/blevesearch/upsidedown_store_api v1.0.2
 	github.com/boltdb/bolt v1.3.1
 	github.com/cznic/kv v0.0.0-20181122101858-e9cdcade440e
 	github.com/dgraph-io/badger v1.6.2
@@ -22,6 +22,7 @@ require (
 	github.com/stretchr/testify v1.8.1
 	github.com/ungerik/go-dry v0.0.0-20180411133923-654ae31114c8
 	google.golang.org/api v0.36.0
+	gopkg.in/yaml.v2 v2.2.8
 )
 
 require (
@@ -39,7 +40,6 @@ require (
 	github.com/blevesearch/scorch_segment_api/v2 v2.1.4 // indirect
 	github.com/blevesearch/segment v0.9.1 // indirect
 	github.com/blevesearch/snowballstem v0.9.0 // indirect
-	github.com/blevesearch/upsidedown_store_api v1.0.2 // indirect
 	github.com/blevesearch/vellum v1.0.9 // indirect
 	github.com/blevesearch/zapx/v11 v11.3.7 // indirect
 	github.com/blevesearch/zapx/v12 v12.3.7 // indirect
@@ -126,6 +126,5 @@ require (
 	google.golang.org/protobuf v1.30.0 // indirect
 	gopkg.in/fatih/pool.v2 v2.0.0 // indirect
 	gopkg.in/gorethink/gorethink.v4 v4.1.0 // indirect
-	gopkg.in/yaml.v2 v2.2.8 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
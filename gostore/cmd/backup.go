@@ -15,35 +15,78 @@ package cmd
 
 import (
 	"fmt"
+	"path/filepath"
 
+	"github.com/osiloke/gostore-contrib/backup"
+	"github.com/osiloke/gostore-contrib/badger"
+	"github.com/osiloke/gostore-contrib/bolt"
 	"github.com/spf13/cobra"
 )
 
+var (
+	backupDbType, backupPath, backupOutput string
+	backupSince                            uint64
+	backupGzip, backupIncremental          bool
+)
+
 // backupCmd represents the backup command
 var backupCmd = &cobra.Command{
 	Use:   "backup",
-	Short: "A brief description of your command",
-	Long: `A longer description that spans multiple lines and likely contains examples
-and usage of using your command. For example:
-
-Cobra is a CLI library for Go that empowers applications.
-This application is a tool to generate the needed files
-to quickly create a Cobra application.`,
+	Short: "Backup a badger or bolt store to a file",
+	Long: `Backup a badger or bolt store to a portable archive. The archive holds a
+manifest with the store type, version watermark, tables and checksum followed
+by the native backup of the store. Pass the version of a badger backup as
+--since to only backup newer entries, or --incremental to continue from the
+last backup of the store`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("backup called")
+		opts := backup.Options{Since: backupSince, Gzip: backupGzip}
+		var m *backup.Manifest
+		switch backupDbType {
+		case "badger":
+			db, err := badger.NewDBOnly(filepath.Join(backupPath, "db"))
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			defer db.Close()
+			if backupIncremental {
+				if opts.Since, err = db.BackupWatermark(); err != nil {
+					println("ERROR " + err.Error())
+					return
+				}
+			}
+			m, err = db.BackupToFile(backupOutput, opts)
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+		case "bolt":
+			db, err := bolt.NewDBOnly(backupPath)
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			defer db.Close()
+			m, err = db.BackupToFile(backupOutput, opts)
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+		default:
+			println("ERROR unknown db type " + backupDbType)
+			return
+		}
+		fmt.Printf("backed up %d tables to %s\nsince: %d\nversion: %d\nsize: %d bytes\nchecksum: %s\n", len(m.Tables), backupOutput, m.Since, m.Version, m.Size, m.Checksum)
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(backupCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// backupCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// backupCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	backupCmd.Flags().StringVarP(&backupDbType, "db-type", "d", "badger", "database type, badger or bolt")
+	backupCmd.Flags().StringVarP(&backupPath, "path", "p", "./db", "badger store folder or bolt file")
+	backupCmd.Flags().StringVarP(&backupOutput, "output", "o", "./backup.tar", "archive to write")
+	backupCmd.Flags().Uint64VarP(&backupSince, "since", "s", 0, "only backup badger entries newer than this version")
+	backupCmd.Flags().BoolVarP(&backupIncremental, "incremental", "i", false, "only backup badger entries newer than the last backup")
+	backupCmd.Flags().BoolVarP(&backupGzip, "gzip", "z", false, "gzip the archive")
 }
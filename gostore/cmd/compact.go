@@ -0,0 +1,71 @@
+// Copyright © 2017 NAME HERE <EMAIL ADDRESS>
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/osiloke/gostore-contrib/bolt"
+	"github.com/spf13/cobra"
+)
+
+var (
+	compactPath, compactTarget string
+	compactFillPercent         float64
+	compactTxMaxSize           int64
+	compactSwap                bool
+)
+
+// compactCmd represents the compact command
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Compact a bolt store",
+	Long: `Compact a bolt store by copying every bucket into a new file. Row counts
+of each bucket are verified before the compacted file is kept, with --swap it
+replaces the store file`,
+	Run: func(cmd *cobra.Command, args []string) {
+		db, err := bolt.NewDBOnly(compactPath)
+		if err != nil {
+			println("ERROR " + err.Error())
+			return
+		}
+		defer db.Close()
+		opts := []bolt.CompactOption{bolt.CompactFillPercent(compactFillPercent), bolt.CompactTxMaxSize(compactTxMaxSize)}
+		if compactSwap {
+			opts = append(opts, bolt.CompactSwap())
+		}
+		report, err := db.Compact(compactTarget, opts...)
+		if err != nil {
+			println("ERROR " + err.Error())
+			return
+		}
+		for _, bucket := range report.Buckets() {
+			fmt.Printf("%s: %d rows verified\n", bucket, report.Rows[bucket])
+		}
+		fmt.Printf("before: %d bytes\nafter: %d bytes\n", report.SrcSize, report.DstSize)
+		if compactSwap {
+			println("replaced " + compactPath)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(compactCmd)
+
+	compactCmd.Flags().StringVarP(&compactPath, "path", "p", "./db", "bolt file to compact")
+	compactCmd.Flags().StringVarP(&compactTarget, "target-path", "a", "./db.compact", "compacted file")
+	compactCmd.Flags().Float64VarP(&compactFillPercent, "fill-percent", "f", bolt.DefaultCompactFillPercent, "how full pages of the compacted file are, between 0.1 and 1")
+	compactCmd.Flags().Int64VarP(&compactTxMaxSize, "tx-max-size", "m", bolt.DefaultCompactTxMaxSize, "bytes copied per transaction")
+	compactCmd.Flags().BoolVarP(&compactSwap, "swap", "w", false, "replace the store file with the compacted file")
+}
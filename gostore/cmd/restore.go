@@ -14,49 +14,129 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/osiloke/gostore-contrib/backup"
 	badger "github.com/osiloke/gostore-contrib/badger"
+	"github.com/osiloke/gostore-contrib/bolt"
+	"github.com/osiloke/gostore-contrib/indexer"
 	"github.com/spf13/cobra"
 )
 
+var (
+	restoreDbType, restoreTarget, restoreIndex string
+	restoreFilenames                           []string
+)
+
 // restoreCmd represents the restore command
 var restoreCmd = &cobra.Command{
 	Use:   "restore",
-	Short: "Restore a badger backup. Note use tar to extract compressed backup file",
-	Long:  `.`,
+	Short: "Restore a badger or bolt backup into a new store",
+	Long: `Restore a backup archive, a raw badger backup or a bolt file, gzipped or
+not, into a new store in the target folder. Incremental badger backups are
+restored after their base backup by passing every file in order. The restored
+rows are indexed with the index mapping recorded in the backup`,
 	Run: func(cmd *cobra.Command, args []string) {
-		filename, _ := cmd.Flags().GetString("filename")
-		// TODO: if filename is gz, use tar to extract
-		mode := int(0777)
-		rootPath := fmt.Sprintf("./%s_restored/", filename)
-		os.RemoveAll(rootPath)
-		os.Mkdir(rootPath, os.FileMode(mode))
-		db, err := badger.NewWithIndex(rootPath, "", bleve.NewIndexMapping())
-		if err != nil {
-			panic(err)
+		if len(restoreFilenames) == 0 {
+			println("ERROR no backup to restore")
+			return
 		}
-		err = db.Restore(filename)
+		m, err := backup.ReadManifestFile(restoreFilenames[0])
 		if err != nil {
-			panic(err)
+			println("ERROR " + err.Error())
+			return
+		}
+		if restoreDbType == "" {
+			restoreDbType = m.Store
+		} else if restoreDbType != m.Store {
+			println(fmt.Sprintf("ERROR %s is a %s backup", restoreFilenames[0], m.Store))
+			return
+		}
+		target := restoreTarget
+		if target == "" {
+			target = fmt.Sprintf("./%s_restored/", filepath.Base(restoreFilenames[0]))
 		}
-		db.Close()
+		if entries, err := os.ReadDir(target); err == nil && len(entries) > 0 {
+			println("ERROR " + target + " is not empty")
+			return
+		}
+		var indexMapping mapping.IndexMapping
+		var indexOpts []indexer.IndexOptions
+		index := restoreIndex
+		if m.Index != nil {
+			if len(m.Index.Mapping) > 0 {
+				im := bleve.NewIndexMapping()
+				if err := json.Unmarshal(m.Index.Mapping, im); err != nil {
+					println("ERROR invalid index mapping " + err.Error())
+					return
+				}
+				indexMapping = im
+			}
+			if index == "" {
+				index = m.Index.Type
+			}
+			if m.Index.GeoField != "" {
+				indexOpts = append(indexOpts, indexer.WithGeoField(m.Index.GeoField))
+			}
+		}
+		switch restoreDbType {
+		case backup.StoreBadger:
+			db, err := badger.NewWithIndex(target, index, indexMapping, indexOpts...)
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			defer db.Close()
+			manifests, err := db.RestoreBackups(restoreFilenames...)
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			m = manifests[len(manifests)-1]
+		case backup.StoreBolt:
+			if len(restoreFilenames) > 1 {
+				println("ERROR bolt backups are always full, restore a single backup")
+				return
+			}
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			var db *bolt.BoltStore
+			if len(indexOpts) > 0 {
+				db, err = bolt.NewGeoWithPaths(filepath.Join(target, "db"), filepath.Join(target, "db.index"), indexMapping, indexOpts...)
+			} else if indexMapping != nil {
+				db, err = bolt.NewWithIndex(filepath.Join(target, "db"), indexer.NewIndexer(filepath.Join(target, "db.index"), indexMapping))
+			} else {
+				db, err = bolt.New(target)
+			}
+			if err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+			defer db.Close()
+			if m, err = db.RestoreBackup(restoreFilenames[0]); err != nil {
+				println("ERROR " + err.Error())
+				return
+			}
+		default:
+			println("ERROR unknown db type " + restoreDbType)
+			return
+		}
+		fmt.Printf("restored %d backups into %s\nversion: %d\n", len(restoreFilenames), target, m.Version)
 	},
 }
 
 func init() {
 	RootCmd.AddCommand(restoreCmd)
 
-	// Here you will define your flags and configuration settings.
-
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// restoreCmd.PersistentFlags().String("foo", "", "A help for foo")
-
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	restoreCmd.Flags().StringP("db-type", "d", "badger", "Database type")
-	restoreCmd.Flags().StringP("filename", "b", "./badger.bak", "Database type")
+	restoreCmd.Flags().StringVarP(&restoreDbType, "db-type", "d", "", "database type, badger or bolt, detected from the backup when empty")
+	restoreCmd.Flags().StringSliceVarP(&restoreFilenames, "filename", "b", []string{"./badger.bak"}, "backup to restore, followed by incremental badger backups in order")
+	restoreCmd.Flags().StringVarP(&restoreTarget, "target", "t", "", "folder of the restored store, defaults to <filename>_restored")
+	restoreCmd.Flags().StringVarP(&restoreIndex, "index", "i", "", "index type of a restored badger store, defaults to the index of the backup")
 }
@@ -122,7 +122,7 @@ func TestCopyRowsBoltToBadger(t *testing.T) {
 	}
 	dst.Close()
 	dst = openBadgerDb(badgerPath)
-	rrows, err := dst.FilterGetAll(map[string]interface{}{"type": "data"}, 10, 0, store, nil)
+	rrows, err := dst.FilterGetAll(map[string]interface{}{"q": map[string]interface{}{"type": "data"}}, 10, 0, store, nil)
 	assert.Nil(t, err, "error encountered while returning all entries in destination store")
 	if err != nil {
 		return
@@ -0,0 +1,238 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AggregatePageSize number of matches loaded per search while aggregating
+const AggregatePageSize = 1000
+
+// Metric aggregates a field over all matches. Type is one of
+// sum, avg, min, max or cardinality
+type Metric struct {
+	Field string `json:"field"`
+	Type  string `json:"type"`
+}
+
+// GroupBy buckets matches by the value of a field and computes metrics per bucket
+type GroupBy struct {
+	Field   string            `json:"field"`
+	Size    int               `json:"size"`
+	Metrics map[string]Metric `json:"metrics"`
+}
+
+// Aggregations metrics and group bys computed from stored documents
+type Aggregations struct {
+	Metrics map[string]Metric  `json:"metrics"`
+	GroupBy map[string]GroupBy `json:"groupBy"`
+}
+
+// MetricResult the value of a metric aggregation
+type MetricResult struct {
+	Field string  `json:"field"`
+	Type  string  `json:"type"`
+	Value float64 `json:"value"`
+	Count int     `json:"count"`
+}
+
+// GroupBucket a single group and its metrics
+type GroupBucket struct {
+	Key     string                  `json:"key"`
+	Count   int                     `json:"count"`
+	Metrics map[string]MetricResult `json:"metrics,omitempty"`
+}
+
+// GroupByResult the buckets of a group by, largest first
+type GroupByResult struct {
+	Field   string        `json:"field"`
+	Buckets []GroupBucket `json:"buckets"`
+}
+
+// DocumentLoader loads a matched document by id
+type DocumentLoader func(id string) (map[string]interface{}, error)
+
+// Empty checks if there is nothing to aggregate
+func (a *Aggregations) Empty() bool {
+	return a == nil || (len(a.Metrics) == 0 && len(a.GroupBy) == 0)
+}
+
+// Aggregate computes metrics and group bys over every document matching q,
+// not just a single page of results
+func Aggregate(ix Indexer, q string, aggs *Aggregations, load DocumentLoader) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	if aggs.Empty() {
+		return result, nil
+	}
+	metrics := map[string]*metricAcc{}
+	for name, m := range aggs.Metrics {
+		metrics[name] = newMetricAcc(m)
+	}
+	groups := map[string]map[string]*groupAcc{}
+	for name := range aggs.GroupBy {
+		groups[name] = map[string]*groupAcc{}
+	}
+	for from := 0; ; from += AggregatePageSize {
+		res, err := ix.QueryWithOptions(q, AggregatePageSize, from, false, []string{}, OrderRequest([]string{"_id"}))
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range res.Hits {
+			doc, err := load(h.ID)
+			if err != nil {
+				logger.Warn("Aggregate failed to load document", "id", h.ID, "err", err)
+				continue
+			}
+			for _, m := range metrics {
+				m.add(doc)
+			}
+			for name, g := range aggs.GroupBy {
+				v := FieldValue(doc, g.Field)
+				if v == nil {
+					continue
+				}
+				key := fmt.Sprintf("%v", v)
+				acc, ok := groups[name][key]
+				if !ok {
+					acc = &groupAcc{metrics: map[string]*metricAcc{}}
+					for mn, m := range g.Metrics {
+						acc.metrics[mn] = newMetricAcc(m)
+					}
+					groups[name][key] = acc
+				}
+				acc.count++
+				for _, m := range acc.metrics {
+					m.add(doc)
+				}
+			}
+		}
+		if len(res.Hits) < AggregatePageSize || uint64(from+len(res.Hits)) >= res.Total {
+			break
+		}
+	}
+	for name, m := range metrics {
+		result[name] = m.result()
+	}
+	for name, g := range aggs.GroupBy {
+		result[name] = groupResult(g, groups[name])
+	}
+	return result, nil
+}
+
+type groupAcc struct {
+	count   int
+	metrics map[string]*metricAcc
+}
+
+func groupResult(g GroupBy, groups map[string]*groupAcc) GroupByResult {
+	buckets := make([]GroupBucket, 0, len(groups))
+	for key, acc := range groups {
+		b := GroupBucket{Key: key, Count: acc.count}
+		if len(acc.metrics) > 0 {
+			b.Metrics = map[string]MetricResult{}
+			for mn, m := range acc.metrics {
+				b.Metrics[mn] = m.result()
+			}
+		}
+		buckets = append(buckets, b)
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		if buckets[i].Count == buckets[j].Count {
+			return buckets[i].Key < buckets[j].Key
+		}
+		return buckets[i].Count > buckets[j].Count
+	})
+	if g.Size > 0 && len(buckets) > g.Size {
+		buckets = buckets[:g.Size]
+	}
+	return GroupByResult{Field: g.Field, Buckets: buckets}
+}
+
+type metricAcc struct {
+	metric   Metric
+	sum      float64
+	min      float64
+	max      float64
+	count    int
+	distinct map[string]struct{}
+}
+
+func newMetricAcc(m Metric) *metricAcc {
+	return &metricAcc{metric: m, min: math.Inf(1), max: math.Inf(-1), distinct: map[string]struct{}{}}
+}
+
+func (m *metricAcc) add(doc map[string]interface{}) {
+	v := FieldValue(doc, m.metric.Field)
+	if v == nil {
+		return
+	}
+	if m.metric.Type == "cardinality" {
+		m.distinct[fmt.Sprintf("%v", v)] = struct{}{}
+		m.count++
+		return
+	}
+	f, ok := numberVal(v)
+	if !ok {
+		return
+	}
+	m.sum += f
+	m.min = math.Min(m.min, f)
+	m.max = math.Max(m.max, f)
+	m.count++
+}
+
+func (m *metricAcc) result() MetricResult {
+	r := MetricResult{Field: m.metric.Field, Type: m.metric.Type, Count: m.count}
+	switch m.metric.Type {
+	case "sum":
+		r.Value = m.sum
+	case "avg":
+		if m.count > 0 {
+			r.Value = m.sum / float64(m.count)
+		}
+	case "min":
+		if m.count > 0 {
+			r.Value = m.min
+		}
+	case "max":
+		if m.count > 0 {
+			r.Value = m.max
+		}
+	case "cardinality":
+		r.Value = float64(len(m.distinct))
+	}
+	return r
+}
+
+// FieldValue gets a value from a document using a dotted path
+func FieldValue(doc map[string]interface{}, path string) interface{} {
+	var current interface{} = doc
+	for _, p := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[p]
+	}
+	return current
+}
+
+func numberVal(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -1,7 +1,7 @@
 package badger
 
 import (
-	"github.com/blevesearch/bleve/index/store"
+	store "github.com/blevesearch/upsidedown_store_api"
 	"github.com/dgraph-io/badger"
 )
 
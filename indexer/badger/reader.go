@@ -1,7 +1,7 @@
 package badger
 
 import (
-	"github.com/blevesearch/bleve/index/store"
+	store "github.com/blevesearch/upsidedown_store_api"
 	"github.com/dgraph-io/badger"
 )
 
@@ -29,8 +29,18 @@ func (r *Reader) Get(k []byte) ([]byte, error) {
 	return valCopy, err
 }
 
+// MultiGet gets the values of keys, store.MultiGet is not used since it
+// indexes into an empty slice
 func (r *Reader) MultiGet(keys [][]byte) ([][]byte, error) {
-	return store.MultiGet(r, keys)
+	vals := make([][]byte, len(keys))
+	for i, key := range keys {
+		val, err := r.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = val
+	}
+	return vals, nil
 }
 
 func (r *Reader) PrefixIterator(k []byte) store.KVIterator {
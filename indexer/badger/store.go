@@ -26,8 +26,8 @@ import (
 	"fmt"
 	"os"
 
-	"github.com/blevesearch/bleve/index/store"
-	"github.com/blevesearch/bleve/registry"
+	"github.com/blevesearch/bleve/v2/registry"
+	store "github.com/blevesearch/upsidedown_store_api"
 	"github.com/dgraph-io/badger"
 	log "github.com/mgutz/logxi/v1"
 )
@@ -1,13 +1,25 @@
 package badger
 
 import (
+	"encoding/binary"
 	"os"
 	"testing"
 
 	"log"
 
-	"github.com/blevesearch/bleve/index/store"
-	"github.com/blevesearch/bleve/index/store/test"
+	"github.com/blevesearch/bleve/v2/registry"
+	store "github.com/blevesearch/upsidedown_store_api"
+	"github.com/blevesearch/upsidedown_store_api/test"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	_ store.KVStore    = &Store{}
+	_ store.KVReader   = &Reader{}
+	_ store.KVWriter   = &Writer{}
+	_ store.KVBatch    = &Batch{}
+	_ store.KVIterator = &PrefixIterator{}
+	_ store.KVIterator = &RangeIterator{}
 )
 
 func open(t *testing.T, mo store.MergeOperator) store.KVStore {
@@ -86,3 +98,76 @@ func TestBadgerMerge(t *testing.T) {
 	defer cleanup(t, s)
 	test.CommonTestMerge(t, s)
 }
+
+func TestBadgerRegistered(t *testing.T) {
+	constructor := registry.KVStoreConstructorByName(Name)
+	if !assert.NotNil(t, constructor) {
+		return
+	}
+	s, err := constructor(nil, map[string]interface{}{
+		"path":              "testbadger",
+		"create_if_missing": true,
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer cleanup(t, s)
+	_, ok := s.(*Store)
+	assert.True(t, ok)
+
+	_, err = constructor(nil, map[string]interface{}{})
+	assert.NotNil(t, err)
+	_, err = constructor(nil, map[string]interface{}{"path": ""})
+	assert.Equal(t, os.ErrInvalid, err)
+}
+
+func TestBadgerBatchContract(t *testing.T) {
+	s := open(t, &test.TestMergeCounter{})
+	defer cleanup(t, s)
+	writer, err := s.Writer()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer writer.Close()
+
+	buf, batch, err := writer.NewBatchEx(store.KVBatchOptions{TotalBytes: 16, NumSets: 2})
+	assert.Nil(t, err)
+	assert.Len(t, buf, 16)
+	batch.Set([]byte("a"), []byte("1"))
+	batch.Set([]byte("b"), []byte("2"))
+	batch.Set([]byte("c"), []byte("3"))
+	batch.Delete([]byte("c"))
+	batch.Merge([]byte("counter"), []byte{5, 0, 0, 0, 0, 0, 0, 0})
+	assert.Nil(t, writer.ExecuteBatch(batch))
+	assert.Nil(t, batch.Close())
+
+	// a reset batch drops the operations added before it
+	batch = writer.NewBatch()
+	batch.Set([]byte("d"), []byte("4"))
+	batch.Reset()
+	batch.Set([]byte("e"), []byte("5"))
+	assert.Nil(t, writer.ExecuteBatch(batch))
+	assert.Nil(t, batch.Close())
+
+	assert.NotNil(t, writer.ExecuteBatch(&wrongBatch{}))
+
+	reader, err := s.Reader()
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer reader.Close()
+	vals, err := reader.MultiGet([][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d"), []byte("e")})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("1"), []byte("2"), nil, nil, []byte("5")}, vals)
+	counter, err := reader.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(5), binary.LittleEndian.Uint64(counter))
+}
+
+type wrongBatch struct{}
+
+func (b *wrongBatch) Set(key, val []byte)   {}
+func (b *wrongBatch) Delete(key []byte)     {}
+func (b *wrongBatch) Merge(key, val []byte) {}
+func (b *wrongBatch) Reset()                {}
+func (b *wrongBatch) Close() error          { return nil }
@@ -3,7 +3,7 @@ package badger
 import (
 	"fmt"
 
-	"github.com/blevesearch/bleve/index/store"
+	store "github.com/blevesearch/upsidedown_store_api"
 	"github.com/dgraph-io/badger"
 )
 
@@ -0,0 +1,39 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/osiloke/gostore-contrib/indexer/badger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBadgerIndexer(t *testing.T) {
+	indexPath := "./test.badger.index"
+	os.RemoveAll(indexPath)
+	defer os.RemoveAll(indexPath)
+
+	ix := NewBadgerIndexerWithMapping(indexPath, bleve.NewIndexMapping())
+	if !assert.NotNil(t, ix) {
+		return
+	}
+	assert.Nil(t, ix.IndexDocument("a", IndexedData{"fruit", map[string]interface{}{"name": "apple"}}))
+	assert.Nil(t, ix.IndexDocument("b", IndexedData{"fruit", map[string]interface{}{"name": "banana"}}))
+	res, err := ix.Query("+data.name:banana")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+	ix.Close()
+
+	meta, err := os.ReadFile(filepath.Join(indexPath, "index_meta.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(meta), `"storage":"`+badger.Name+`"`)
+
+	// the index is reopened from the badger kv store
+	ix = NewBadgerIndexerWithMapping(indexPath, bleve.NewIndexMapping())
+	defer ix.Close()
+	count, err := ix.Index().DocCount()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), count)
+}
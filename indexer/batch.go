@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"github.com/blevesearch/bleve/v2"
+)
+
+type batchOp struct {
+	id     string
+	data   interface{}
+	delete bool
+}
+
+// Batch a bleve batch which records its operations so they can be
+// replayed into another index. The bleve batch is embedded so indexers
+// apply b.Batch to their bleve index
+type Batch struct {
+	*bleve.Batch
+	ops []batchOp
+}
+
+// NewBatch wraps a bleve batch
+func NewBatch(b *bleve.Batch) *Batch {
+	return &Batch{Batch: b}
+}
+
+// Index adds a document to the batch
+func (b *Batch) Index(id string, data interface{}) error {
+	if err := b.Batch.Index(id, data); err != nil {
+		return err
+	}
+	b.ops = append(b.ops, batchOp{id: id, data: data})
+	return nil
+}
+
+// Delete removes a document in the batch
+func (b *Batch) Delete(id string) {
+	b.Batch.Delete(id)
+	b.ops = append(b.ops, batchOp{id: id, delete: true})
+}
+
+// Reset empties the batch
+func (b *Batch) Reset() {
+	b.Batch.Reset()
+	b.ops = nil
+}
+
+// IDs returns the ids of every document in the batch
+func (b *Batch) IDs() []string {
+	ids := make([]string, len(b.ops))
+	for i, op := range b.ops {
+		ids[i] = op.id
+	}
+	return ids
+}
+
+// Replay adds the recorded operations to a batch of another index
+func (b *Batch) Replay(to *Batch) error {
+	for _, op := range b.ops {
+		if op.delete {
+			to.Delete(op.id)
+		} else if err := to.Index(op.id, op.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package indexer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+var benchIndexers = []struct {
+	name string
+	open func(path string) Indexer
+}{
+	{"scorch", func(path string) Indexer { ix, _ := NewScorchIndexer(path); return ix }},
+	{"moss-scorch", func(path string) Indexer { ix, _ := NewMossScorchIndexer(path); return ix }},
+	{"moss", func(path string) Indexer { ix, _ := NewMossIndexer(path); return ix }},
+	{"badger", func(path string) Indexer { return NewBadgerIndexer(path) }},
+	{"boltdb", func(path string) Indexer { return NewDefaultIndexer(path) }},
+	{"memory", func(path string) Indexer { ix, _ := NewMemIndexer(path); return ix }},
+}
+
+var benchCategories = []string{"fruit", "vegetable", "grain", "dairy"}
+
+func benchDocument(i int) IndexedData {
+	return IndexedData{"bench", map[string]interface{}{
+		"name":     fmt.Sprintf("item %d", i),
+		"category": benchCategories[i%len(benchCategories)],
+		"price":    float64(i % 100),
+	}}
+}
+
+// indexes are opened once per indexer since some take a while to create
+func BenchmarkIndexInsert(b *testing.B) {
+	for _, bi := range benchIndexers {
+		ix := bi.open(filepath.Join(b.TempDir(), "bench.index"))
+		n := 0
+		b.Run(bi.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := ix.IndexDocument(strconv.Itoa(n), benchDocument(n)); err != nil {
+					b.Fatal(err)
+				}
+				n++
+			}
+		})
+		ix.Close()
+	}
+}
+
+func BenchmarkIndexBatchInsert(b *testing.B) {
+	for _, bi := range benchIndexers {
+		ix := bi.open(filepath.Join(b.TempDir(), "bench.index"))
+		n := 0
+		b.Run(bi.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				batch := ix.BatchIndex()
+				for j := 0; j < 100; j++ {
+					batch.Index(strconv.Itoa(n), benchDocument(n))
+					n++
+				}
+				if err := ix.Batch(batch); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		ix.Close()
+	}
+}
+
+func BenchmarkIndexQuery(b *testing.B) {
+	for _, bi := range benchIndexers {
+		ix := bi.open(filepath.Join(b.TempDir(), "bench.index"))
+		batch := ix.BatchIndex()
+		for i := 0; i < 1000; i++ {
+			batch.Index(strconv.Itoa(i), benchDocument(i))
+		}
+		if err := ix.Batch(batch); err != nil {
+			b.Fatal(err)
+		}
+		b.Run(bi.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := ix.QueryWithOptions("+bucket:bench +data.category:fruit", 10, 0, false, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		ix.Close()
+	}
+}
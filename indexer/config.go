@@ -0,0 +1,267 @@
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/simple"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/standard"
+	"github.com/blevesearch/bleve/v2/analysis/lang/ar"
+	"github.com/blevesearch/bleve/v2/analysis/lang/da"
+	"github.com/blevesearch/bleve/v2/analysis/lang/de"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/analysis/lang/es"
+	"github.com/blevesearch/bleve/v2/analysis/lang/fi"
+	"github.com/blevesearch/bleve/v2/analysis/lang/fr"
+	"github.com/blevesearch/bleve/v2/analysis/lang/hu"
+	"github.com/blevesearch/bleve/v2/analysis/lang/it"
+	"github.com/blevesearch/bleve/v2/analysis/lang/nl"
+	"github.com/blevesearch/bleve/v2/analysis/lang/no"
+	"github.com/blevesearch/bleve/v2/analysis/lang/pt"
+	"github.com/blevesearch/bleve/v2/analysis/lang/ro"
+	"github.com/blevesearch/bleve/v2/analysis/lang/ru"
+	"github.com/blevesearch/bleve/v2/analysis/lang/sv"
+	"github.com/blevesearch/bleve/v2/analysis/lang/tr"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"gopkg.in/yaml.v2"
+)
+
+// ErrInvalidIndexConfig returned when an index configuration cannot be built
+var ErrInvalidIndexConfig = errors.New("invalid index config")
+
+// language stop word and stemmer filters, used to build synonym analyzers
+var languages = map[string][2]string{
+	ar.AnalyzerName: {ar.StopName, ar.StemmerName},
+	da.AnalyzerName: {da.StopName, da.SnowballStemmerName},
+	de.AnalyzerName: {de.StopName, de.LightStemmerName},
+	en.AnalyzerName: {en.StopName, en.SnowballStemmerName},
+	es.AnalyzerName: {es.StopName, es.LightStemmerName},
+	fi.AnalyzerName: {fi.StopName, fi.SnowballStemmerName},
+	fr.AnalyzerName: {fr.StopName, fr.LightStemmerName},
+	hu.AnalyzerName: {hu.StopName, hu.SnowballStemmerName},
+	it.AnalyzerName: {it.StopName, it.LightStemmerName},
+	nl.AnalyzerName: {nl.StopName, nl.SnowballStemmerName},
+	no.AnalyzerName: {no.StopName, no.SnowballStemmerName},
+	pt.AnalyzerName: {pt.StopName, pt.LightStemmerName},
+	ro.AnalyzerName: {ro.StopName, ro.SnowballStemmerName},
+	ru.AnalyzerName: {ru.StopName, ru.SnowballStemmerName},
+	sv.AnalyzerName: {sv.StopName, sv.SnowballStemmerName},
+	tr.AnalyzerName: {tr.StopName, tr.SnowballStemmerName},
+}
+
+// FieldConfig configures how a field of a table is indexed. Type is one of
+// text, keyword, numeric, datetime, bool or geopoint. Analyzer is one of
+// standard, simple, keyword or a language code such as en or fr
+type FieldConfig struct {
+	Type         string `json:"type" yaml:"type"`
+	Analyzer     string `json:"analyzer,omitempty" yaml:"analyzer,omitempty"`
+	Synonyms     string `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+	Store        bool   `json:"store,omitempty" yaml:"store,omitempty"`
+	Index        *bool  `json:"index,omitempty" yaml:"index,omitempty"`
+	IncludeInAll *bool  `json:"includeInAll,omitempty" yaml:"includeInAll,omitempty"`
+	Suggest      bool   `json:"suggest,omitempty" yaml:"suggest,omitempty"`
+}
+
+// TableConfig configures the fields of a table, fields may be dotted paths.
+// Fields which are not declared are indexed dynamically unless Dynamic is false
+type TableConfig struct {
+	Dynamic *bool                  `json:"dynamic,omitempty" yaml:"dynamic,omitempty"`
+	Fields  map[string]FieldConfig `json:"fields" yaml:"fields"`
+}
+
+// IndexConfig a declarative index mapping with a config per table
+type IndexConfig struct {
+	DefaultAnalyzer string                 `json:"defaultAnalyzer,omitempty" yaml:"defaultAnalyzer,omitempty"`
+	Dynamic         *bool                  `json:"dynamic,omitempty" yaml:"dynamic,omitempty"`
+	StoreDynamic    bool                   `json:"storeDynamic,omitempty" yaml:"storeDynamic,omitempty"`
+	Synonyms        map[string][][]string  `json:"synonyms,omitempty" yaml:"synonyms,omitempty"`
+	Tables          map[string]TableConfig `json:"tables" yaml:"tables"`
+}
+
+// ParseIndexConfig parses a json or yaml index config
+func ParseIndexConfig(data []byte, format string) (*IndexConfig, error) {
+	config := &IndexConfig{}
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		err = json.Unmarshal(data, config)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(data, config)
+	default:
+		return nil, fmt.Errorf("%w: unknown format %s", ErrInvalidIndexConfig, format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadIndexConfig loads an index config from a .json, .yaml or .yml file
+func LoadIndexConfig(path string) (*IndexConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIndexConfig(data, strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+func boolOr(v *bool, d bool) bool {
+	if v == nil {
+		return d
+	}
+	return *v
+}
+
+// Mapping builds an index mapping with a document mapping per table.
+// Documents are typed by their bucket
+func (c *IndexConfig) Mapping() (*mapping.IndexMappingImpl, error) {
+	im := bleve.NewIndexMapping()
+	im.TypeField = "bucket"
+	im.IndexDynamic = boolOr(c.Dynamic, true)
+	im.StoreDynamic = c.StoreDynamic
+	StoreBucket(im)
+	if c.DefaultAnalyzer != "" {
+		im.DefaultAnalyzer = c.DefaultAnalyzer
+	}
+	names := make([]string, 0, len(c.Synonyms))
+	for name := range c.Synonyms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := im.AddCustomTokenFilter(SynonymFilterName+"_"+name, map[string]interface{}{
+			"type":     SynonymFilterName,
+			"synonyms": c.Synonyms[name],
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	suggest := false
+	for table, tc := range c.Tables {
+		dm := bleve.NewDocumentMapping()
+		dm.Dynamic = boolOr(tc.Dynamic, im.IndexDynamic)
+		// the bucket is always indexed so tables can be queried
+		dm.AddFieldMappingsAt("bucket", BucketMapping())
+		data := bleve.NewDocumentMapping()
+		data.Dynamic = dm.Dynamic
+		for path, fc := range tc.Fields {
+			fm, err := c.fieldMapping(im, fc)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", table, path, err)
+			}
+			parent, field := subDocumentMapping(data, path)
+			parent.AddFieldMappingsAt(field, fm)
+			if fc.Suggest {
+				suggest = true
+				AddSuggestField(parent, field)
+			}
+		}
+		dm.AddSubDocumentMapping("data", data)
+		im.AddDocumentMapping(table, dm)
+	}
+	if suggest {
+		if err := AddSuggestAnalyzer(im); err != nil {
+			return nil, err
+		}
+	}
+	return im, im.Validate()
+}
+
+// subDocumentMapping walks a dotted path creating document mappings as needed
+func subDocumentMapping(dm *mapping.DocumentMapping, path string) (*mapping.DocumentMapping, string) {
+	parts := strings.Split(path, ".")
+	for _, p := range parts[:len(parts)-1] {
+		sub, ok := dm.Properties[p]
+		if !ok {
+			sub = bleve.NewDocumentMapping()
+			sub.Dynamic = dm.Dynamic
+			dm.AddSubDocumentMapping(p, sub)
+		}
+		dm = sub
+	}
+	return dm, parts[len(parts)-1]
+}
+
+func (c *IndexConfig) fieldMapping(im *mapping.IndexMappingImpl, fc FieldConfig) (*mapping.FieldMapping, error) {
+	var fm *mapping.FieldMapping
+	switch fc.Type {
+	case "text", "":
+		fm = bleve.NewTextFieldMapping()
+		analyzer, err := c.analyzer(im, fc)
+		if err != nil {
+			return nil, err
+		}
+		fm.Analyzer = analyzer
+	case "keyword":
+		fm = bleve.NewKeywordFieldMapping()
+	case "numeric":
+		fm = bleve.NewNumericFieldMapping()
+	case "datetime":
+		fm = bleve.NewDateTimeFieldMapping()
+	case "bool":
+		fm = bleve.NewBooleanFieldMapping()
+	case "geopoint":
+		fm = bleve.NewGeoPointFieldMapping()
+	default:
+		return nil, fmt.Errorf("%w: unknown field type %s", ErrInvalidIndexConfig, fc.Type)
+	}
+	fm.Store = fc.Store
+	fm.Index = boolOr(fc.Index, true)
+	fm.IncludeInAll = boolOr(fc.IncludeInAll, true)
+	return fm, nil
+}
+
+// analyzer returns the analyzer for a text field, registering a custom
+// analyzer when synonyms are used
+func (c *IndexConfig) analyzer(im *mapping.IndexMappingImpl, fc FieldConfig) (string, error) {
+	base := fc.Analyzer
+	switch base {
+	case "", standard.Name, simple.Name, keyword.Name:
+	default:
+		if _, ok := languages[base]; !ok {
+			return "", fmt.Errorf("%w: unknown analyzer %s", ErrInvalidIndexConfig, base)
+		}
+	}
+	if fc.Synonyms == "" {
+		return base, nil
+	}
+	if _, ok := c.Synonyms[fc.Synonyms]; !ok {
+		return "", fmt.Errorf("%w: unknown synonyms %s", ErrInvalidIndexConfig, fc.Synonyms)
+	}
+	if base == keyword.Name {
+		return "", fmt.Errorf("%w: synonyms cannot be used with the keyword analyzer", ErrInvalidIndexConfig)
+	}
+	if base == "" {
+		base = standard.Name
+	}
+	name := base + "_" + SynonymFilterName + "_" + fc.Synonyms
+	if _, ok := im.CustomAnalysis.Analyzers[name]; ok {
+		return name, nil
+	}
+	filters := []string{lowercase.Name}
+	lang, isLanguage := languages[base]
+	if isLanguage {
+		filters = append(filters, lang[0])
+	}
+	filters = append(filters, SynonymFilterName+"_"+fc.Synonyms)
+	if isLanguage {
+		filters = append(filters, lang[1])
+	}
+	err := im.AddCustomAnalyzer(name, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": filters,
+	})
+	return name, err
+}
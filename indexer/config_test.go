@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+var testIndexConfig = `
+synonyms:
+  colors:
+    - [red, crimson, scarlet]
+tables:
+  products:
+    fields:
+      title: {type: text, analyzer: en, synonyms: colors, store: true}
+      sku: {type: keyword}
+      price: {type: numeric}
+      created: {type: datetime}
+      active: {type: bool}
+      shop.location: {type: geopoint}
+  notes:
+    dynamic: false
+    fields:
+      body: {type: text, analyzer: fr}
+`
+
+func TestParseIndexConfig(t *testing.T) {
+	config, err := ParseIndexConfig([]byte(testIndexConfig), "yaml")
+	assert.Nil(t, err)
+	assert.Equal(t, "en", config.Tables["products"].Fields["title"].Analyzer)
+	assert.Equal(t, "geopoint", config.Tables["products"].Fields["shop.location"].Type)
+	assert.Equal(t, [][]string{{"red", "crimson", "scarlet"}}, config.Synonyms["colors"])
+
+	_, err = ParseIndexConfig([]byte(`{}`), "toml")
+	assert.ErrorIs(t, err, ErrInvalidIndexConfig)
+}
+
+func TestIndexConfigMapping(t *testing.T) {
+	config, _ := ParseIndexConfig([]byte(testIndexConfig), "yaml")
+	im, err := config.Mapping()
+	if !assert.Nil(t, err) {
+		return
+	}
+	ix, _ := NewMemIndexerWithMapping("", im)
+	defer ix.Close()
+	ix.IndexDocument("1", IndexedData{"products", map[string]interface{}{
+		"title": "Crimson running shoes", "sku": "SKU-Red-1", "price": 20,
+	}})
+	ix.IndexDocument("2", IndexedData{"products", map[string]interface{}{
+		"title": "Blue jacket", "sku": "SKU-Blue-1", "price": 40,
+	}})
+	ix.IndexDocument("3", IndexedData{"notes", map[string]interface{}{
+		"body": "Les chaussures rouges", "author": "osiloke",
+	}})
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+	}{
+		{"Synonyms and stemming", "+bucket:products +data.title:red +data.title:run", []string{"1"}},
+		{"Keyword field keeps case", `+bucket:products +data.sku:"SKU-Blue-1"`, []string{"2"}},
+		{"Numeric field", "+bucket:products +data.price:>30", []string{"2"}},
+		{"Language analyzer", "+bucket:notes +data.body:chaussure", []string{"3"}},
+		{"Dynamic fields disabled", "+bucket:notes +data.author:osiloke", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ix.Query(tt.query)
+			if !assert.Nil(t, err) {
+				return
+			}
+			ids := []string{}
+			for _, h := range res.Hits {
+				ids = append(ids, h.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
+
+func TestIndexConfigMappingErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config IndexConfig
+	}{
+		{"Unknown type", IndexConfig{Tables: map[string]TableConfig{
+			"t": {Fields: map[string]FieldConfig{"f": {Type: "money"}}},
+		}}},
+		{"Unknown analyzer", IndexConfig{Tables: map[string]TableConfig{
+			"t": {Fields: map[string]FieldConfig{"f": {Type: "text", Analyzer: "klingon"}}},
+		}}},
+		{"Unknown synonyms", IndexConfig{Tables: map[string]TableConfig{
+			"t": {Fields: map[string]FieldConfig{"f": {Type: "text", Synonyms: "colors"}}},
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.config.Mapping()
+			assert.ErrorIs(t, err, ErrInvalidIndexConfig)
+		})
+	}
+}
+
+func TestSynonymFilter(t *testing.T) {
+	im := bleve.NewIndexMapping()
+	err := im.AddCustomTokenFilter("colors", map[string]interface{}{
+		"type":     SynonymFilterName,
+		"synonyms": []interface{}{[]interface{}{"red", "crimson"}},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, im.Validate())
+}
@@ -2,33 +2,43 @@ package indexer
 
 import (
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
 	"github.com/blevesearch/bleve/v2/mapping"
 )
 
 // Indexer ...
 type Indexer interface {
 	Index() bleve.Index
-	BatchIndex() *bleve.Batch
-	Batch(b *bleve.Batch) error
+	// BatchIndex and Batch use Batch rather than a bleve batch so the swap
+	// and router indexers can replay or split its operations. Indexers
+	// backed by a bleve index wrap their batch with NewBatch
+	BatchIndex() *Batch
+	Batch(b *Batch) error
 	AddDocumentMapping(name string, dm *mapping.DocumentMapping)
 	IndexDocument(id string, data interface{}) error
 	UnIndexDocument(id string) error
 	QueryMap(q map[string]interface{}, opts ...RequestOpt) (*bleve.SearchResult, error)
 	Query(q string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	QueryWithOptions(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
+	RangeQuery(q string, ranges []Range, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	FacetedQuery(q string, facets *Facets, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	QueryWithOptionsHighlighted(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	MatchQuery(q, field string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	TermQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	MatchPhraseQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error)
+	Suggest(store, field, prefix string, n int) ([]Suggestion, error)
+	SimilarTo(store, id string, doc map[string]interface{}, fields []string, n int, opts ...RequestOpt) (*bleve.SearchResult, error)
 	Close()
 }
 
 // GeoCapableIndexer an indexer that can makle geo queries
 type GeoCapableIndexer interface {
 	SetField(field string)
+	GeoField() string
 	GeoDistance(lon, lat float64, distance string, opts ...RequestOpt) (*bleve.SearchResult, error)
 	GeoDistanceQuery(q string, lon, lat float64, distance string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
+	GeoBoundingBoxQuery(q string, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat float64, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
+	GeoPolygonQuery(q string, polygon []geo.Point, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error)
 }
 
 type IndexOptions func(Indexer)
@@ -1,5 +1,13 @@
 package indexer
 
+import (
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/gosexy/to"
+	"github.com/osiloke/gostore"
+)
+
 type TopFacet struct {
 	Name  string `json:"name"`
 	Field string `json:"field"`
@@ -10,7 +18,143 @@ type RangeFacet struct {
 	Ranges []interface{} `json:"ranges"`
 }
 
+// DateRangeFacet counts matches within named date ranges,
+// each range is a map of name, start and end
+type DateRangeFacet struct {
+	Field  string        `json:"field"`
+	Ranges []interface{} `json:"ranges"`
+}
+
+// DateHistogramFacet counts matches in fixed intervals between start and end
+type DateHistogramFacet struct {
+	Field    string `json:"field"`
+	Interval string `json:"interval"`
+	Start    string `json:"start"`
+	End      string `json:"end"`
+}
+
 type Facets struct {
-	Top   map[string]TopFacet   `json:"top"`
-	Range map[string]RangeFacet `json:"range"`
+	Top           map[string]TopFacet           `json:"top"`
+	Range         map[string]RangeFacet         `json:"range"`
+	DateRange     map[string]DateRangeFacet     `json:"dateRange"`
+	DateHistogram map[string]DateHistogramFacet `json:"dateHistogram"`
+}
+
+// ParseAggregates parses the aggregates of a store query into the facets
+// requested with the search and the aggregations computed from the matched
+// documents. Fields are relative to the data of a document
+func ParseAggregates(aggregates map[string]interface{}) (*Facets, *Aggregations) {
+	facets := &Facets{}
+	aggs := &Aggregations{}
+	for k, v := range aggregates {
+		if v == nil {
+			continue
+		}
+		switch k {
+		case "top":
+			facets.Top = make(map[string]TopFacet)
+			for kk, vv := range v.(map[string]interface{}) {
+				f := vv.(map[string]interface{})
+				name := kk
+				if n, ok := f["name"].(string); ok {
+					name = n
+				}
+				facets.Top[name] = TopFacet{
+					Name:  name,
+					Field: "data." + f["field"].(string),
+					Count: int(to.Int64(f["count"])),
+				}
+			}
+		case "range":
+			facets.Range = make(map[string]RangeFacet)
+			if ranges, ok := v.(map[string]interface{}); ok {
+				for kk, vv := range ranges {
+					f := vv.(map[string]interface{})
+					facets.Range[kk] = RangeFacet{
+						Field:  "data." + f["field"].(string),
+						Ranges: f["ranges"].([]interface{}),
+					}
+				}
+			} else if ranges, ok := v.([]interface{}); ok {
+				for _, vv := range ranges {
+					f := vv.(map[string]interface{})
+					facets.Range[f["name"].(string)] = RangeFacet{
+						Field:  "data." + f["field"].(string),
+						Ranges: f["ranges"].([]interface{}),
+					}
+				}
+			}
+		case "dateRange":
+			facets.DateRange = make(map[string]DateRangeFacet)
+			for kk, vv := range v.(map[string]interface{}) {
+				f := vv.(map[string]interface{})
+				facets.DateRange[kk] = DateRangeFacet{
+					Field:  "data." + f["field"].(string),
+					Ranges: f["ranges"].([]interface{}),
+				}
+			}
+		case "dateHistogram":
+			facets.DateHistogram = make(map[string]DateHistogramFacet)
+			for kk, vv := range v.(map[string]interface{}) {
+				f := vv.(map[string]interface{})
+				facets.DateHistogram[kk] = DateHistogramFacet{
+					Field:    "data." + f["field"].(string),
+					Interval: to.String(f["interval"]),
+					Start:    to.String(f["start"]),
+					End:      to.String(f["end"]),
+				}
+			}
+		case "metrics":
+			aggs.Metrics = parseMetrics(v.(map[string]interface{}))
+		case "groupBy":
+			aggs.GroupBy = make(map[string]GroupBy)
+			for kk, vv := range v.(map[string]interface{}) {
+				f := vv.(map[string]interface{})
+				groupBy := GroupBy{
+					Field: f["field"].(string),
+					Size:  int(to.Int64(f["size"])),
+				}
+				if metrics, ok := f["metrics"].(map[string]interface{}); ok {
+					groupBy.Metrics = parseMetrics(metrics)
+				}
+				aggs.GroupBy[kk] = groupBy
+			}
+		}
+	}
+	return facets, aggs
+}
+
+func parseMetrics(v map[string]interface{}) map[string]Metric {
+	metrics := make(map[string]Metric)
+	for k, vv := range v {
+		f := vv.(map[string]interface{})
+		metrics[k] = Metric{
+			Field: f["field"].(string),
+			Type:  f["type"].(string),
+		}
+	}
+	return metrics
+}
+
+// FacetResults maps the facets of a search requested with facets into an
+// aggregate result keyed by facet name
+func FacetResults(res *bleve.SearchResult, facets *Facets) (gostore.AggregateResult, error) {
+	agg := gostore.AggregateResult{}
+	for k, v := range res.Facets {
+		match := gostore.Match{
+			Field:     strings.TrimPrefix(v.Field, "data."),
+			Matched:   v.Total,
+			UnMatched: v.Other,
+			Missing:   v.Missing,
+		}
+		if len(v.NumericRanges) > 0 {
+			match.NumberRange = v.NumericRanges
+		} else if len(v.DateRanges) > 0 {
+			match.DateRange = SortDateRanges(v.DateRanges)
+		} else {
+			match.Top = v.Terms
+		}
+		agg[k] = match
+	}
+	return agg, nil
 }
@@ -0,0 +1,38 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAggregates(t *testing.T) {
+	facets, aggs := ParseAggregates(map[string]interface{}{
+		"top": map[string]interface{}{
+			"types": map[string]interface{}{"field": "type", "count": 5},
+		},
+		"range": []interface{}{
+			map[string]interface{}{"name": "price", "field": "price", "ranges": []interface{}{}},
+		},
+		"metrics": map[string]interface{}{
+			"total": map[string]interface{}{"field": "price", "type": "sum"},
+		},
+		"groupBy": map[string]interface{}{
+			"byType": map[string]interface{}{
+				"field":   "type",
+				"size":    "3",
+				"metrics": map[string]interface{}{"avg": map[string]interface{}{"field": "price", "type": "avg"}},
+			},
+		},
+	})
+	assert.Equal(t, TopFacet{Name: "types", Field: "data.type", Count: 5}, facets.Top["types"])
+	assert.Equal(t, "data.price", facets.Range["price"].Field)
+	assert.Nil(t, facets.DateRange)
+	assert.Equal(t, Metric{Field: "price", Type: "sum"}, aggs.Metrics["total"])
+	assert.Equal(t, 3, aggs.GroupBy["byType"].Size)
+	assert.Equal(t, Metric{Field: "price", Type: "avg"}, aggs.GroupBy["byType"].Metrics["avg"])
+
+	facets, aggs = ParseAggregates(nil)
+	assert.Nil(t, facets.Top)
+	assert.True(t, aggs.Empty())
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/osiloke/gostore"
+)
+
+// HitDistanceFunc returns the distance of a hit from a geo query point
+type HitDistanceFunc func(*search.DocumentMatch) (float64, bool)
+
+// GeoBoundingBoxSearch searches a geo capable index for documents of a store
+// matching filter within a bounding box. Hits are ordered by opts like
+// GeoOrderRequest, DistanceField orders by the distance in meters from the
+// center of the box and distance is set when hits are ordered by it
+func GeoBoundingBoxSearch(index Indexer, store string, filter map[string]interface{}, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat float64, count, skip int, opts gostore.ObjectStoreOptions) (res *bleve.SearchResult, distance HitDistanceFunc, err error) {
+	geoIndexer, ok := index.(GeoCapableIndexer)
+	if !ok {
+		return nil, nil, gostore.ErrNotImplemented
+	}
+	q := GetQueryString(store, filter)
+	orderBy := geoOrderBy(opts)
+	logger.Info("GeoBoundingBoxQuery", "count", count, "skip", skip, "Store", store, "topLeft", []float64{topLeftLon, topLeftLat}, "bottomRight", []float64{bottomRightLon, bottomRightLat}, "query", q, "orderBy", orderBy)
+	lon, lat := (topLeftLon+bottomRightLon)/2, (topLeftLat+bottomRightLat)/2
+	res, err = geoIndexer.GeoBoundingBoxQuery(q, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat, count, skip, true, []string{}, GeoOrderRequest(orderBy, geoIndexer.GeoField(), lon, lat, "m"))
+	return res, hitDistance(orderBy), err
+}
+
+// GeoPolygonSearch searches a geo capable index for documents of a store
+// matching filter within a polygon. Hits are ordered like GeoBoundingBoxSearch
+// with distances from the mean of the polygon's points
+func GeoPolygonSearch(index Indexer, store string, filter map[string]interface{}, polygon []geo.Point, count, skip int, opts gostore.ObjectStoreOptions) (res *bleve.SearchResult, distance HitDistanceFunc, err error) {
+	geoIndexer, ok := index.(GeoCapableIndexer)
+	if !ok {
+		return nil, nil, gostore.ErrNotImplemented
+	}
+	if len(polygon) < 3 {
+		return nil, nil, ErrInvalidPolygon
+	}
+	q := GetQueryString(store, filter)
+	orderBy := geoOrderBy(opts)
+	logger.Info("GeoPolygonQuery", "count", count, "skip", skip, "Store", store, "polygon", polygon, "query", q, "orderBy", orderBy)
+	var lon, lat float64
+	for _, p := range polygon {
+		lon += p.Lon / float64(len(polygon))
+		lat += p.Lat / float64(len(polygon))
+	}
+	res, err = geoIndexer.GeoPolygonQuery(q, polygon, count, skip, true, []string{}, GeoOrderRequest(orderBy, geoIndexer.GeoField(), lon, lat, "m"))
+	return res, hitDistance(orderBy), err
+}
+
+func geoOrderBy(opts gostore.ObjectStoreOptions) []string {
+	if opts != nil {
+		if orderBy := opts.GetOrderBy(); len(orderBy) > 0 {
+			return orderBy
+		}
+	}
+	return []string{"-_score", "-_id"}
+}
+
+func hitDistance(orderBy []string) HitDistanceFunc {
+	at := DistanceSortIndex(orderBy)
+	if at < 0 {
+		return nil
+	}
+	return func(h *search.DocumentMatch) (float64, bool) {
+		return HitDistance(h, at)
+	}
+}
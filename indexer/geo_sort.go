@@ -0,0 +1,73 @@
+package indexer
+
+import (
+	"math"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// DistanceField sorts by the distance from a geo query point when used in
+// an order, it is also the field rows return the distance in
+const DistanceField = "_distance"
+
+// GeoOrderRequest orders a request like OrderRequest, DistanceField sorts
+// nearest first and -DistanceField furthest first by the distance of field
+// from lon, lat in unit
+var GeoOrderRequest = func(orderBy []string, field string, lon, lat float64, unit string) RequestOpt {
+	return func(req *bleve.SearchRequest) error {
+		order := make(search.SortOrder, 0, len(orderBy))
+		for _, o := range orderBy {
+			if strings.TrimPrefix(o, "-") == DistanceField {
+				distance, err := search.NewSortGeoDistance(field, unit, lon, lat, strings.HasPrefix(o, "-"))
+				if err != nil {
+					return err
+				}
+				order = append(order, distance)
+				continue
+			}
+			order = append(order, search.ParseSearchSortString(o))
+		}
+		req.SortByCustom(order)
+		return nil
+	}
+}
+
+// DistanceSortIndex returns the position of the distance sort in orderBy or -1
+func DistanceSortIndex(orderBy []string) int {
+	for i, o := range orderBy {
+		if strings.TrimPrefix(o, "-") == DistanceField {
+			return i
+		}
+	}
+	return -1
+}
+
+// DistanceUnit returns the unit of a distance such as 10km, distances
+// without a unit are in meters
+func DistanceUnit(distance string) (string, error) {
+	unit := strings.TrimLeft(strings.TrimSpace(distance), "0123456789.")
+	if unit == "" {
+		unit = "m"
+	}
+	if _, err := geo.ParseDistanceUnit(unit); err != nil {
+		return "", err
+	}
+	return unit, nil
+}
+
+// HitDistance decodes the distance of a hit sorted with GeoOrderRequest from
+// its sort value at pos, hits without a location return false
+func HitDistance(hit *search.DocumentMatch, pos int) (float64, bool) {
+	if pos < 0 || pos >= len(hit.Sort) {
+		return 0, false
+	}
+	i64, err := numeric.PrefixCoded(hit.Sort[pos]).Int64()
+	if err != nil || i64 == math.MaxInt64 {
+		return 0, false
+	}
+	return numeric.Int64ToFloat64(i64), true
+}
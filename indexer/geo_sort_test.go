@@ -0,0 +1,35 @@
+package indexer
+
+import (
+	"math"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2/numeric"
+	"github.com/blevesearch/bleve/v2/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistanceUnit(t *testing.T) {
+	for distance, want := range map[string]string{"10km": "km", "1.5mi": "mi", "300": "m", "20m": "m"} {
+		unit, err := DistanceUnit(distance)
+		assert.Nil(t, err)
+		assert.Equal(t, want, unit, distance)
+	}
+	_, err := DistanceUnit("10parsecs")
+	assert.NotNil(t, err)
+}
+
+func TestHitDistance(t *testing.T) {
+	distance := string(numeric.MustNewPrefixCodedInt64(numeric.Float64ToInt64(12.5), 0))
+	missing := string(numeric.MustNewPrefixCodedInt64(math.MaxInt64, 0))
+	hit := &search.DocumentMatch{Sort: []string{"a", distance, missing}}
+	d, ok := HitDistance(hit, 1)
+	assert.True(t, ok)
+	assert.Equal(t, 12.5, d)
+	_, ok = HitDistance(hit, 2)
+	assert.False(t, ok)
+	_, ok = HitDistance(hit, 3)
+	assert.False(t, ok)
+	assert.Equal(t, 1, DistanceSortIndex([]string{"-_score", "-_distance"}))
+	assert.Equal(t, -1, DistanceSortIndex([]string{"-_score"}))
+}
@@ -0,0 +1,85 @@
+package indexer
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve/v2/search"
+)
+
+// MaxHistogramBuckets limits the number of intervals in a date histogram
+const MaxHistogramBuckets = 1000
+
+// ErrInvalidFacet returned when a facet cannot be parsed
+var ErrInvalidFacet = errors.New("invalid facet")
+
+// HistogramBucket a single interval of a date histogram
+type HistogramBucket struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// nextInterval returns a function which advances a time by an interval
+// such as minute, hour, day, week, month, year or a go duration
+func nextInterval(interval string) (func(time.Time) time.Time, error) {
+	switch interval {
+	case "minute":
+		return func(t time.Time) time.Time { return t.Add(time.Minute) }, nil
+	case "hour":
+		return func(t time.Time) time.Time { return t.Add(time.Hour) }, nil
+	case "day", "":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, nil
+	case "week":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }, nil
+	case "month":
+		return func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }, nil
+	case "year":
+		return func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }, nil
+	}
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, ErrInvalidFacet
+	}
+	return func(t time.Time) time.Time { return t.Add(d) }, nil
+}
+
+// HistogramBuckets splits a date histogram into date ranges named by their start
+func HistogramBuckets(facet DateHistogramFacet) ([]HistogramBucket, error) {
+	next, err := nextInterval(facet.Interval)
+	if err != nil {
+		return nil, err
+	}
+	start, err := parseTime(facet.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseTime(facet.End)
+	if err != nil {
+		return nil, err
+	}
+	buckets := []HistogramBucket{}
+	for t := start; t.Before(end); t = next(t) {
+		if len(buckets) == MaxHistogramBuckets {
+			return nil, ErrInvalidFacet
+		}
+		buckets = append(buckets, HistogramBucket{
+			Name:  t.Format(time.RFC3339),
+			Start: t,
+			End:   next(t),
+		})
+	}
+	return buckets, nil
+}
+
+// SortDateRanges orders date range facets by their start
+func SortDateRanges(ranges search.DateRangeFacets) search.DateRangeFacets {
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].Start == nil || ranges[j].Start == nil {
+			return ranges[i].Start == nil && ranges[j].Start != nil
+		}
+		return *ranges[i].Start < *ranges[j].Start
+	})
+	return ranges
+}
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	tests := []struct {
+		name     string
+		facet    DateHistogramFacet
+		wantErr  bool
+		expected []string
+	}{
+		{
+			"Daily buckets",
+			DateHistogramFacet{Interval: "day", Start: "2020-01-01T00:00:00Z", End: "2020-01-04T00:00:00Z"},
+			false,
+			[]string{"2020-01-01T00:00:00Z", "2020-01-02T00:00:00Z", "2020-01-03T00:00:00Z"},
+		},
+		{
+			"Duration buckets",
+			DateHistogramFacet{Interval: "12h", Start: "2020-01-01T00:00:00Z", End: "2020-01-02T00:00:00Z"},
+			false,
+			[]string{"2020-01-01T00:00:00Z", "2020-01-01T12:00:00Z"},
+		},
+		{
+			"Invalid interval",
+			DateHistogramFacet{Interval: "fortnight", Start: "2020-01-01T00:00:00Z", End: "2020-01-02T00:00:00Z"},
+			true,
+			nil,
+		},
+		{
+			"Too many buckets",
+			DateHistogramFacet{Interval: "minute", Start: "2020-01-01T00:00:00Z", End: "2020-02-01T00:00:00Z"},
+			true,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets, err := HistogramBuckets(tt.facet)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HistogramBuckets() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var names []string
+			for _, b := range buckets {
+				names = append(names, b.Name)
+			}
+			assert.Equal(t, tt.expected, names)
+		})
+	}
+}
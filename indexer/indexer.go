@@ -6,47 +6,24 @@ import (
 
 	log "github.com/mgutz/logxi/v1"
 
-	"encoding/json"
-	"strings"
-
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/geo"
 	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
 	// "github.com/blevesearch/blevex/regexp"
 )
 
-func ReIndex(provider ProviderStore, index Indexer) error {
-	iter, _ := provider.Cursor()
-	for iter.Valid() {
-		key := iter.Key()
-		val := iter.Value()
-		var v map[string]interface{}
-		if err := json.Unmarshal(val, &v); err == nil {
-			k := string(key)
-			u := strings.SplitN(k, "|", 2)
-			ID := u[1]
-			store := strings.TrimPrefix(u[0], "t$")
-			// logger.Debug("reindexing", "ID", ID, "val", v)
-			if ix, ok := index.(*GeoIndexer); ok {
-				d := map[string]interface{}{"bucket": store, "data": v}
-				if vv, ok := v["_"+ix.Field]; ok {
-					d[ix.Field] = vv
-				}
-				ix.IndexDocument(ID, d)
-			} else {
-				index.IndexDocument(ID, IndexedData{store, v})
-			}
-		}
-		iter.Next()
-	}
-	return nil
-}
-
 // IndexedData represents a stored row
 type IndexedData struct {
 	Bucket string      `json:"bucket"`
 	Data   interface{} `json:"data"`
 }
 
+// BleveType types indexed documents by their bucket
+func (d IndexedData) BleveType() string {
+	return d.Bucket
+}
+
 var logger = log.New("gostore-contrib.indexer")
 
 type RequestOpt func(*bleve.SearchRequest) error
@@ -72,11 +49,11 @@ type DefaultIndexer struct {
 func (i *DefaultIndexer) Index() bleve.Index {
 	return i.index
 }
-func (i *DefaultIndexer) BatchIndex() *bleve.Batch {
-	return i.index.NewBatch()
+func (i *DefaultIndexer) BatchIndex() *Batch {
+	return NewBatch(i.index.NewBatch())
 }
-func (i *DefaultIndexer) Batch(b *bleve.Batch) error {
-	return i.index.Batch(b)
+func (i *DefaultIndexer) Batch(b *Batch) error {
+	return i.index.Batch(b.Batch)
 }
 func (i *DefaultIndexer) AddDocumentMapping(name string, dm *mapping.DocumentMapping) {
 	// i.index.AddDocumentMapping(name, dm)
@@ -137,6 +114,27 @@ func (i *DefaultIndexer) QueryWithOptions(q string, size, from int, explain bool
 	return i.index.Search(searchRequest)
 }
 
+// RangeQuery combines a query string with numeric, date or term range queries
+func (i *DefaultIndexer) RangeQuery(q string, ranges []Range, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	if i.index == nil {
+		return nil, errors.New("no index")
+	}
+	query := bleve.NewConjunctionQuery(bleve.NewQueryStringQuery(q))
+	for _, r := range ranges {
+		query.AddQuery(r.Query())
+	}
+	searchRequest := bleve.NewSearchRequestOptions(query, size, from, explain)
+	if len(fields) > 0 {
+		searchRequest.Fields = fields
+	}
+	for _, opt := range opts {
+		if err := opt(searchRequest); err != nil {
+			logger.Warn("failed option passed")
+		}
+	}
+	return i.index.Search(searchRequest)
+}
+
 func (i *DefaultIndexer) FacetedQuery(q string, facets *Facets, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
 	if i.index == nil {
 		return nil, errors.New("no index")
@@ -290,6 +288,9 @@ func NewIndexer(indexPath string, indexMapping mapping.IndexMapping) *DefaultInd
 	return &DefaultIndexer{index: index}
 }
 
+// ErrInvalidPolygon returned when a polygon has fewer than 3 points
+var ErrInvalidPolygon = errors.New("polygon needs at least 3 points")
+
 // GeoIndexer an indexer that can handle geo queries
 type GeoIndexer struct {
 	Field string
@@ -300,6 +301,11 @@ func (g *GeoIndexer) SetField(field string) {
 	g.Field = field
 }
 
+// GeoField returns the field holding the location of documents
+func (g *GeoIndexer) GeoField() string {
+	return g.Field
+}
+
 // GeoDistance get results within a distance from a lon lat
 func (g *GeoIndexer) GeoDistance(lon, lat float64, distance string, opts ...RequestOpt) (*bleve.SearchResult, error) {
 
@@ -321,20 +327,38 @@ func (g *GeoIndexer) GeoDistance(lon, lat float64, distance string, opts ...Requ
 func (g *GeoIndexer) GeoDistanceQuery(q string, lon, lat float64, distance string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
 	//Search the index with GEO //https://github.com/blevesearch/bleve/issues/836
 	//https://github.com/blevesearch/bleve/issues/599
-	//term query
-	if g.Index() == nil {
-		return nil, errors.New("no index")
-	}
-	query := bleve.NewQueryStringQuery(q)
 	//distance query
 	distanceQuery := bleve.NewGeoDistanceQuery(lon, lat, distance)
 	distanceQuery.SetField(g.Field)
-	// fmt.Println("geofield", g.Field, "query", query, lon, lat, distance)
+	return g.geoQuery(q, distanceQuery, size, from, explain, fields, opts...)
+}
+
+// GeoBoundingBoxQuery get results matching q within a bounding box
+func (g *GeoIndexer) GeoBoundingBoxQuery(q string, topLeftLon, topLeftLat, bottomRightLon, bottomRightLat float64, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	boxQuery := bleve.NewGeoBoundingBoxQuery(topLeftLon, topLeftLat, bottomRightLon, bottomRightLat)
+	boxQuery.SetField(g.Field)
+	return g.geoQuery(q, boxQuery, size, from, explain, fields, opts...)
+}
+
+// GeoPolygonQuery get results matching q within a polygon
+func (g *GeoIndexer) GeoPolygonQuery(q string, polygon []geo.Point, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	if len(polygon) < 3 {
+		return nil, ErrInvalidPolygon
+	}
+	polygonQuery := query.NewGeoBoundingPolygonQuery(polygon)
+	polygonQuery.SetField(g.Field)
+	return g.geoQuery(q, polygonQuery, size, from, explain, fields, opts...)
+}
 
-	//Conjonction of the term and distance queries
+// geoQuery conjoins the query string with a geo query
+func (g *GeoIndexer) geoQuery(q string, geoQuery query.Query, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	if g.Index() == nil {
+		return nil, errors.New("no index")
+	}
+	//Conjonction of the term and geo queries
 	conRequest := bleve.NewConjunctionQuery()
-	conRequest.AddQuery(query)
-	conRequest.AddQuery(distanceQuery)
+	conRequest.AddQuery(bleve.NewQueryStringQuery(q))
+	conRequest.AddQuery(geoQuery)
 
 	//execute request on index
 	searchRequest := bleve.NewSearchRequestOptions(conRequest, size, from, explain)
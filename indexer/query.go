@@ -1,14 +1,39 @@
 package indexer
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
 )
 
+// BucketField is added to each row of a multi store query with the store
+// the row was retrieved from
+const BucketField = "_bucket"
+
+// WithField adds a field to a raw json object such as the distance of a geo
+// query hit, raw is returned as is when it is not an object
+func WithField(raw []byte, name string, value interface{}) []byte {
+	body := bytes.TrimSpace(raw)
+	if len(body) < 2 || body[0] != '{' {
+		return raw
+	}
+	rest := bytes.TrimSpace(body[1:])
+	field, _ := json.Marshal(map[string]interface{}{name: value})
+	out := append([]byte{}, field[:len(field)-1]...)
+	if rest[0] != '}' {
+		out = append(out, ',')
+	}
+	return append(out, rest...)
+}
+
 func reduceValueLenght(v string) string {
 	if len(v) > 100 {
 		return v[0:100]
@@ -103,7 +128,18 @@ func getQueryValue(store, k string, v interface{}) string {
 	return queryString
 }
 
+// GetQueryString returns a query string matching filter in a store
 func GetQueryString(store string, filter map[string]interface{}) string {
+	return strings.TrimSpace(fmt.Sprintf("+bucket:%s %s", store, filterString(store, filter)))
+}
+
+// GetFilterString returns a query string matching filter in any store, use
+// BucketsRequest to restrict it to some stores
+func GetFilterString(filter map[string]interface{}) string {
+	return filterString("", filter)
+}
+
+func filterString(store string, filter map[string]interface{}) string {
 	queryString := ""
 	for k, v := range filter {
 		if _v, ok := v.([]string); ok {
@@ -120,7 +156,42 @@ func GetQueryString(store string, filter map[string]interface{}) string {
 			}
 		}
 	}
-	return strings.TrimSpace(fmt.Sprintf("+bucket:%s %s", store, queryString))
+	return queryString
+}
+
+// BucketMapping maps the bucket of indexed documents, it is stored so hits
+// of a multi store query know which store they belong to
+func BucketMapping() *mapping.FieldMapping {
+	fm := bleve.NewTextFieldMapping()
+	fm.Store = true
+	return fm
+}
+
+// StoreBucket stores the bucket of documents without a document mapping
+func StoreBucket(im *mapping.IndexMappingImpl) {
+	im.DefaultMapping.AddFieldMappingsAt("bucket", BucketMapping())
+}
+
+// BucketsRequest restricts a request to documents in any of the buckets
+var BucketsRequest = func(buckets []string) RequestOpt {
+	return func(req *bleve.SearchRequest) error {
+		if len(buckets) == 0 {
+			return nil
+		}
+		disjunction := bleve.NewDisjunctionQuery()
+		for _, bucket := range buckets {
+			match := bleve.NewMatchQuery(bucket)
+			match.SetField("bucket")
+			match.SetOperator(query.MatchQueryOperatorAnd)
+			disjunction.AddQuery(match)
+		}
+		if q, ok := req.Query.(*query.QueryStringQuery); ok && strings.TrimSpace(q.Query) == "" {
+			req.Query = disjunction
+			return nil
+		}
+		req.Query = bleve.NewConjunctionQuery(req.Query, disjunction)
+		return nil
+	}
 }
 
 func floatVal(v interface{}) float64 {
@@ -146,6 +217,51 @@ func addRangeFacets(searchRequest *bleve.SearchRequest, facets *Facets) error {
 	return nil
 }
 
+// addDateRangeFacets add date range facets to request
+func addDateRangeFacets(searchRequest *bleve.SearchRequest, facets *Facets) error {
+	for k, facet := range facets.DateRange {
+		fieldFacet := bleve.NewFacetRequest(facet.Field, len(facet.Ranges))
+		for _, v := range facet.Ranges {
+			dateRange, ok := v.(map[string]interface{})
+			if !ok {
+				return ErrInvalidFacet
+			}
+			name, _ := dateRange["name"].(string)
+			var start, end time.Time
+			var err error
+			if dateRange["start"] != nil {
+				if start, err = parseTime(dateRange["start"]); err != nil {
+					return err
+				}
+			}
+			if dateRange["end"] != nil {
+				if end, err = parseTime(dateRange["end"]); err != nil {
+					return err
+				}
+			}
+			fieldFacet.AddDateTimeRange(name, start, end)
+		}
+		searchRequest.AddFacet(k, fieldFacet)
+	}
+	return nil
+}
+
+// addDateHistogramFacets add a date range facet with a range per interval
+func addDateHistogramFacets(searchRequest *bleve.SearchRequest, facets *Facets) error {
+	for k, facet := range facets.DateHistogram {
+		buckets, err := HistogramBuckets(facet)
+		if err != nil {
+			return err
+		}
+		fieldFacet := bleve.NewFacetRequest(facet.Field, len(buckets))
+		for _, b := range buckets {
+			fieldFacet.AddDateTimeRange(b.Name, b.Start, b.End)
+		}
+		searchRequest.AddFacet(k, fieldFacet)
+	}
+	return nil
+}
+
 // AddFacets facets to a request
 func AddFacets(searchRequest *bleve.SearchRequest, facets *Facets) error {
 	for _, facet := range facets.Top {
@@ -153,5 +269,8 @@ func AddFacets(searchRequest *bleve.SearchRequest, facets *Facets) error {
 		searchRequest.AddFacet(facet.Name, fieldFacet)
 	}
 	addRangeFacets(searchRequest, facets)
-	return nil
+	if err := addDateRangeFacets(searchRequest, facets); err != nil {
+		return err
+	}
+	return addDateHistogramFacets(searchRequest, facets)
 }
@@ -264,3 +264,29 @@ func Test_getQueryValue(t *testing.T) {
 		})
 	}
 }
+
+func TestBucketsRequest(t *testing.T) {
+	index, _ := NewMemIndexer("")
+	defer index.Close()
+	docs := map[string]IndexedData{
+		"1": {"products", map[string]interface{}{"name": "blue shirt"}},
+		"2": {"customers", map[string]interface{}{"name": "blue man"}},
+		"3": {"orders", map[string]interface{}{"name": "blue order"}},
+		"4": {"products", map[string]interface{}{"name": "red shirt"}},
+	}
+	for id, doc := range docs {
+		assert.Nil(t, index.IndexDocument(id, doc))
+	}
+
+	res, err := index.QueryWithOptions(GetFilterString(map[string]interface{}{"name": "blue"}), 10, 0, false, nil,
+		BucketsRequest([]string{"products", "customers"}), OrderRequest([]string{"_id"}))
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, res.Hits.Len()) {
+		assert.Equal(t, "1", res.Hits[0].ID)
+		assert.Equal(t, "2", res.Hits[1].ID)
+	}
+
+	res, err = index.QueryWithOptions(GetFilterString(nil), 10, 0, false, nil, BucketsRequest([]string{"products"}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+}
@@ -0,0 +1,242 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/gosexy/to"
+)
+
+// KeyField is the range filter field which targets the primary key of a row
+const KeyField = "_id"
+
+// ErrInvalidRange returned when a range filter cannot be parsed
+var ErrInvalidRange = errors.New("invalid range")
+
+// Range bounds on a single field. A nil Min or Max is unbounded
+type Range struct {
+	Field        string
+	Min          interface{}
+	Max          interface{}
+	MinInclusive bool
+	MaxInclusive bool
+}
+
+// ParseRanges parses a range filter such as
+// {"_id": {"gte": "a", "lt": "m"}, "price": {"gt": 10, "lte": 20}}
+func ParseRanges(filter map[string]interface{}) ([]Range, error) {
+	ranges := []Range{}
+	for field, v := range filter {
+		bounds, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s must be a map of bounds", ErrInvalidRange, field)
+		}
+		r := Range{Field: field}
+		for op, bound := range bounds {
+			switch op {
+			case "gt":
+				r.Min, r.MinInclusive = bound, false
+			case "gte":
+				r.Min, r.MinInclusive = bound, true
+			case "lt":
+				r.Max, r.MaxInclusive = bound, false
+			case "lte":
+				r.Max, r.MaxInclusive = bound, true
+			default:
+				return nil, fmt.Errorf("%w: unknown bound %s on %s", ErrInvalidRange, op, field)
+			}
+		}
+		if r.Min == nil && r.Max == nil {
+			return nil, fmt.Errorf("%w: %s has no bounds", ErrInvalidRange, field)
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		return nil, ErrInvalidRange
+	}
+	return ranges, nil
+}
+
+// SplitKeyRange separates the primary key range from ranges on indexed fields
+func SplitKeyRange(ranges []Range) (key *Range, fields []Range) {
+	for i := range ranges {
+		if ranges[i].Field == KeyField {
+			key = &ranges[i]
+		} else {
+			fields = append(fields, ranges[i])
+		}
+	}
+	return
+}
+
+// IsReverseOrder checks if the first order by field is descending
+func IsReverseOrder(orderBy []string) bool {
+	return len(orderBy) > 0 && strings.HasPrefix(orderBy[0], "-")
+}
+
+// CompareKey returns -1 if key is below the lower bound, 1 if it is above the
+// upper bound and 0 if it is within the range
+func (r Range) CompareKey(key []byte) int {
+	k := string(key)
+	if r.Min != nil {
+		min := fmt.Sprintf("%v", r.Min)
+		if k < min || (k == min && !r.MinInclusive) {
+			return -1
+		}
+	}
+	if r.Max != nil {
+		max := fmt.Sprintf("%v", r.Max)
+		if k > max || (k == max && !r.MaxInclusive) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// MatchesRanges checks if the fields of a document fall within all ranges
+func MatchesRanges(doc map[string]interface{}, ranges []Range) bool {
+	for _, r := range ranges {
+		if !r.Matches(doc[r.Field]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches checks if a document value falls within the range
+func (r Range) Matches(v interface{}) bool {
+	if v == nil {
+		return false
+	}
+	if isNumber(r.Min) || isNumber(r.Max) {
+		if !isNumber(v) {
+			return false
+		}
+		return r.inBounds(func(bound interface{}) int {
+			return compareFloat(to.Float64(v), to.Float64(bound))
+		})
+	}
+	if start, end, ok := r.dates(); ok {
+		t, err := parseTime(v)
+		if err != nil {
+			return false
+		}
+		return r.inBounds(func(bound interface{}) int {
+			b := start
+			if bound == r.Max {
+				b = end
+			}
+			return compareTime(t, b)
+		})
+	}
+	return r.CompareKey([]byte(fmt.Sprintf("%v", v))) == 0
+}
+
+func (r Range) inBounds(cmp func(bound interface{}) int) bool {
+	if r.Min != nil {
+		c := cmp(r.Min)
+		if c < 0 || (c == 0 && !r.MinInclusive) {
+			return false
+		}
+	}
+	if r.Max != nil {
+		c := cmp(r.Max)
+		if c > 0 || (c == 0 && !r.MaxInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// dates parses both bounds as dates, returns false if either is not a date
+func (r Range) dates() (start, end time.Time, ok bool) {
+	var err error
+	if r.Min != nil {
+		if start, err = parseTime(r.Min); err != nil {
+			return
+		}
+	}
+	if r.Max != nil {
+		if end, err = parseTime(r.Max); err != nil {
+			return
+		}
+	}
+	ok = true
+	return
+}
+
+// Query converts the range into a bleve numeric, date or term range query
+// over the data field
+func (r Range) Query() query.Query {
+	field := "data." + r.Field
+	minInclusive, maxInclusive := r.MinInclusive, r.MaxInclusive
+	if isNumber(r.Min) || isNumber(r.Max) {
+		var min, max *float64
+		if r.Min != nil {
+			v := to.Float64(r.Min)
+			min = &v
+		}
+		if r.Max != nil {
+			v := to.Float64(r.Max)
+			max = &v
+		}
+		q := bleve.NewNumericRangeInclusiveQuery(min, max, &minInclusive, &maxInclusive)
+		q.SetField(field)
+		return q
+	}
+	if start, end, ok := r.dates(); ok {
+		q := bleve.NewDateRangeInclusiveQuery(start, end, &minInclusive, &maxInclusive)
+		q.SetField(field)
+		return q
+	}
+	min, max := "", ""
+	if r.Min != nil {
+		min = fmt.Sprintf("%v", r.Min)
+	}
+	if r.Max != nil {
+		max = fmt.Sprintf("%v", r.Max)
+	}
+	q := bleve.NewTermRangeInclusiveQuery(min, max, &minInclusive, &maxInclusive)
+	q.SetField(field)
+	return q
+}
+
+func isNumber(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, uint, uint32, uint64, float32, float64:
+		return true
+	}
+	return false
+}
+
+func parseTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		return time.Parse(time.RFC3339, t)
+	}
+	return time.Time{}, ErrInvalidRange
+}
+
+func compareFloat(a, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareTime(a, b time.Time) int {
+	if a.Before(b) {
+		return -1
+	} else if a.After(b) {
+		return 1
+	}
+	return 0
+}
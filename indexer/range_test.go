@@ -0,0 +1,80 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   map[string]interface{}
+		wantErr  bool
+		expected []Range
+	}{
+		{
+			"Inclusive and exclusive bounds",
+			map[string]interface{}{"price": map[string]interface{}{"gt": 10, "lte": 20}},
+			false,
+			[]Range{{Field: "price", Min: 10, Max: 20, MinInclusive: false, MaxInclusive: true}},
+		},
+		{
+			"Unknown bound",
+			map[string]interface{}{"price": map[string]interface{}{"between": 10}},
+			true,
+			nil,
+		},
+		{
+			"Not a map of bounds",
+			map[string]interface{}{"price": 10},
+			true,
+			nil,
+		},
+		{
+			"Empty filter",
+			map[string]interface{}{},
+			true,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRanges(tt.filter)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRanges() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestRangeMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        Range
+		v        interface{}
+		expected bool
+	}{
+		{"Number within range", Range{Min: 10, Max: 20, MinInclusive: true}, 10.0, true},
+		{"Number on exclusive bound", Range{Min: 10, Max: 20}, 20.0, false},
+		{"Date within range", Range{Min: "2020-01-01T00:00:00Z"}, "2020-02-01T00:00:00Z", true},
+		{"Date before range", Range{Min: "2020-01-01T00:00:00Z"}, "2019-12-31T00:00:00Z", false},
+		{"Term within range", Range{Min: "a", Max: "m", MaxInclusive: true}, "m", true},
+		{"Missing value", Range{Min: 1}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.r.Matches(tt.v))
+		})
+	}
+}
+
+func TestRangeCompareKey(t *testing.T) {
+	r := Range{Field: KeyField, Min: "b", Max: "d", MinInclusive: true}
+	assert.Equal(t, -1, r.CompareKey([]byte("a")))
+	assert.Equal(t, 0, r.CompareKey([]byte("b")))
+	assert.Equal(t, 0, r.CompareKey([]byte("c")))
+	assert.Equal(t, 1, r.CompareKey([]byte("d")))
+}
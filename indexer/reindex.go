@@ -0,0 +1,241 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// ReIndexOptions configures ReIndex
+type ReIndexOptions struct {
+	// BatchSize number of documents indexed per batch
+	BatchSize int
+	// Workers number of batches indexed concurrently
+	Workers int
+	// Stores limits the reindex to these stores, all stores are reindexed when empty
+	Stores []string
+	// Progress is called after every batch with the running totals
+	Progress func(indexed, failed int)
+}
+
+// ReIndexOpt sets a reindex option
+type ReIndexOpt func(*ReIndexOptions)
+
+// ReIndexBatchSize sets the number of documents indexed per batch
+func ReIndexBatchSize(size int) ReIndexOpt {
+	return func(o *ReIndexOptions) {
+		o.BatchSize = size
+	}
+}
+
+// ReIndexWorkers sets the number of batches indexed concurrently
+func ReIndexWorkers(workers int) ReIndexOpt {
+	return func(o *ReIndexOptions) {
+		o.Workers = workers
+	}
+}
+
+// ReIndexStores limits the reindex to the given stores
+func ReIndexStores(stores ...string) ReIndexOpt {
+	return func(o *ReIndexOptions) {
+		o.Stores = stores
+	}
+}
+
+// ReIndexOnProgress sets a callback which receives the running totals after every batch
+func ReIndexOnProgress(fn func(indexed, failed int)) ReIndexOpt {
+	return func(o *ReIndexOptions) {
+		o.Progress = fn
+	}
+}
+
+// ReIndexFailure a row which could not be indexed
+type ReIndexFailure struct {
+	Store string `json:"store"`
+	ID    string `json:"id"`
+	Err   error  `json:"-"`
+}
+
+// ReIndexReport summary of a reindex
+type ReIndexReport struct {
+	Indexed  int              `json:"indexed"`
+	Skipped  int              `json:"skipped"`
+	Failures []ReIndexFailure `json:"failures"`
+}
+
+type reIndexRow struct {
+	store string
+	id    string
+	val   []byte
+}
+
+// ClearIndex unindexes every document of index so rows which no longer exist
+// are not left behind by ReIndex
+func ClearIndex(index Indexer) error {
+	ix := index.Index()
+	if ix == nil {
+		return nil
+	}
+	ids := []string{}
+	for from := 0; ; from += AggregatePageSize {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), AggregatePageSize, from, false)
+		res, err := ix.Search(req)
+		if err != nil {
+			return err
+		}
+		for _, h := range res.Hits {
+			ids = append(ids, h.ID)
+		}
+		if len(res.Hits) < AggregatePageSize {
+			break
+		}
+	}
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > AggregatePageSize {
+			n = AggregatePageSize
+		}
+		b := index.BatchIndex()
+		for _, id := range ids[:n] {
+			b.Delete(id)
+		}
+		if err := index.Batch(b); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+// ReIndex indexes every table row of provider in batches using a pool of
+// workers. Rows which fail to index are collected in the returned report,
+// an error is only returned when the provider cannot be read
+func ReIndex(provider ProviderStore, index Indexer, opts ...ReIndexOpt) (*ReIndexReport, error) {
+	o := ReIndexOptions{BatchSize: DefaultRebuildBatchSize, Workers: runtime.NumCPU()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = DefaultRebuildBatchSize
+	}
+	if o.Workers <= 0 {
+		o.Workers = 1
+	}
+	iter, err := provider.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	report := &ReIndexReport{Failures: []ReIndexFailure{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	batches := make(chan []reIndexRow, o.Workers)
+	for w := 0; w < o.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				indexed, failures := reIndexBatch(index, batch)
+				mu.Lock()
+				report.Indexed += indexed
+				report.Failures = append(report.Failures, failures...)
+				if o.Progress != nil {
+					o.Progress(report.Indexed, len(report.Failures))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	skipped := 0
+	batch := make([]reIndexRow, 0, o.BatchSize)
+	visit := func(key, val []byte) {
+		store, id, ok := parseRowKey(key)
+		if !ok {
+			skipped++
+			return
+		}
+		batch = append(batch, reIndexRow{store, id, val})
+		if len(batch) == o.BatchSize {
+			batches <- batch
+			batch = make([]reIndexRow, 0, o.BatchSize)
+		}
+	}
+	if len(o.Stores) == 0 {
+		for ; iter.Valid(); iter.Next() {
+			visit(iter.Key(), iter.Value())
+		}
+	} else {
+		for _, store := range o.Stores {
+			prefix := []byte("t$" + store + "|")
+			for iter.Seek(prefix); iter.Valid() && bytes.HasPrefix(iter.Key(), prefix); iter.Next() {
+				visit(iter.Key(), iter.Value())
+			}
+		}
+	}
+	if len(batch) > 0 {
+		batches <- batch
+	}
+	close(batches)
+	wg.Wait()
+	report.Skipped = skipped
+	return report, nil
+}
+
+// reIndexBatch indexes a batch of rows returning the number indexed and the failures
+func reIndexBatch(index Indexer, rows []reIndexRow) (int, []ReIndexFailure) {
+	var failures []ReIndexFailure
+	added := make([]reIndexRow, 0, len(rows))
+	b := index.BatchIndex()
+	for _, r := range rows {
+		doc, err := reIndexData(index, r.store, r.val)
+		if err == nil {
+			err = b.Index(r.id, doc)
+		}
+		if err != nil {
+			failures = append(failures, ReIndexFailure{r.store, r.id, err})
+			continue
+		}
+		added = append(added, r)
+	}
+	if len(added) == 0 {
+		return 0, failures
+	}
+	if err := index.Batch(b); err != nil {
+		for _, r := range added {
+			failures = append(failures, ReIndexFailure{r.store, r.id, err})
+		}
+		return 0, failures
+	}
+	return len(added), failures
+}
+
+// parseRowKey splits a t$<store>|<id> key, other keys are not table rows
+func parseRowKey(key []byte) (store, id string, ok bool) {
+	u := strings.SplitN(string(key), "|", 2)
+	if len(u) != 2 || !strings.HasPrefix(u[0], "t$") {
+		return "", "", false
+	}
+	return strings.TrimPrefix(u[0], "t$"), u[1], true
+}
+
+// reIndexData builds the indexed document for a stored row
+func reIndexData(index Indexer, store string, val []byte) (interface{}, error) {
+	var v map[string]interface{}
+	if err := json.Unmarshal(val, &v); err != nil {
+		return nil, err
+	}
+	if ix, ok := index.(*GeoIndexer); ok {
+		d := map[string]interface{}{"bucket": store, "data": v}
+		if vv, ok := v["_"+ix.Field]; ok {
+			d[ix.Field] = vv
+		}
+		return d, nil
+	}
+	return IndexedData{store, v}, nil
+}
@@ -0,0 +1,58 @@
+package indexer
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func reIndexTestRows() [][2]string {
+	return [][2]string{
+		{"i$meta", `{}`},
+		{"t$fruit|a", `{"name":"apple"}`},
+		{"t$fruit|b", `not json`},
+		{"t$fruit|c", `{"name":"cherry"}`},
+		{"t$veg|d", `{"name":"daikon"}`},
+		{"t$veg|e", `{"name":"endive"}`},
+	}
+}
+
+func TestReIndex(t *testing.T) {
+	ix := newMemTestIndexer(t)
+	defer ix.Close()
+	var mu sync.Mutex
+	calls := 0
+	report, err := ReIndex(&sliceProvider{&sliceIterator{rows: reIndexTestRows()}}, ix,
+		ReIndexBatchSize(2), ReIndexWorkers(3), ReIndexOnProgress(func(indexed, failed int) {
+			mu.Lock()
+			calls++
+			mu.Unlock()
+		}))
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.Indexed)
+	assert.Equal(t, 1, report.Skipped)
+	if assert.Len(t, report.Failures, 1) {
+		assert.Equal(t, "fruit", report.Failures[0].Store)
+		assert.Equal(t, "b", report.Failures[0].ID)
+		assert.NotNil(t, report.Failures[0].Err)
+	}
+	assert.Equal(t, 3, calls)
+	count, _ := ix.Index().DocCount()
+	assert.Equal(t, uint64(4), count)
+}
+
+func TestReIndexStores(t *testing.T) {
+	ix := newMemTestIndexer(t)
+	defer ix.Close()
+	report, err := ReIndex(&sliceProvider{&sliceIterator{rows: reIndexTestRows()}}, ix, ReIndexStores("veg"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, report.Indexed)
+	assert.Empty(t, report.Failures)
+	res, err := ix.Query("+bucket:veg")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), res.Total)
+	res, err = ix.Query("+bucket:fruit")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+}
@@ -0,0 +1,323 @@
+package indexer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/osiloke/gostore-contrib/common"
+)
+
+// ErrUnknownIndex returned when routing to an index which was not added
+var ErrUnknownIndex = errors.New("unknown index")
+
+// RouterIndexer routes documents to named indexes by their bucket, buckets
+// without a route use the default index. Queries run against an IndexAlias
+// of every index so a query can match documents in any of them
+type RouterIndexer struct {
+	mu      sync.RWMutex
+	def     Indexer
+	indexes map[string]Indexer
+	routes  map[string]string
+}
+
+// NewRouterIndexer routes every bucket to def until routes are added
+func NewRouterIndexer(def Indexer) *RouterIndexer {
+	return &RouterIndexer{def: def, indexes: make(map[string]Indexer), routes: make(map[string]string)}
+}
+
+// AddIndex adds a named index, an index with the same name is replaced and returned
+func (r *RouterIndexer) AddIndex(name string, index Indexer) Indexer {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old := r.indexes[name]
+	r.indexes[name] = index
+	return old
+}
+
+// AddRoute routes documents of a bucket to a named index
+func (r *RouterIndexer) AddRoute(bucket, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.indexes[name]; !ok {
+		return ErrUnknownIndex
+	}
+	r.routes[bucket] = name
+	return nil
+}
+
+// RemoveRoute routes documents of a bucket back to the default index
+func (r *RouterIndexer) RemoveRoute(bucket string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.routes, bucket)
+}
+
+// Named returns a named index
+func (r *RouterIndexer) Named(name string) (Indexer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	index, ok := r.indexes[name]
+	return index, ok
+}
+
+// Default returns the index of buckets without a route
+func (r *RouterIndexer) Default() Indexer {
+	return r.def
+}
+
+// Route returns the index documents of a bucket are written to
+func (r *RouterIndexer) Route(bucket string) Indexer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.route(bucket)
+}
+
+func (r *RouterIndexer) route(bucket string) Indexer {
+	if name, ok := r.routes[bucket]; ok {
+		return r.indexes[name]
+	}
+	return r.def
+}
+
+// Routes returns the bucket to index name routes
+func (r *RouterIndexer) Routes() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	routes := make(map[string]string, len(r.routes))
+	for bucket, name := range r.routes {
+		routes[bucket] = name
+	}
+	return routes
+}
+
+// RoutedBuckets returns the buckets which are not in the default index
+func (r *RouterIndexer) RoutedBuckets() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	buckets := make([]string, 0, len(r.routes))
+	for bucket := range r.routes {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+// all returns the default index followed by the named indexes
+func (r *RouterIndexer) all() []Indexer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.indexes))
+	for name := range r.indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	all := []Indexer{r.def}
+	for _, name := range names {
+		all = append(all, r.indexes[name])
+	}
+	return all
+}
+
+// searcher returns the indexer queries run against, the alias is created per
+// query since a rebuild may swap the bleve index of an indexer
+func (r *RouterIndexer) searcher() Indexer {
+	all := r.all()
+	if len(all) == 1 {
+		return r.def
+	}
+	indexes := make([]bleve.Index, len(all))
+	for i, ix := range all {
+		indexes[i] = ix.Index()
+	}
+	return &DefaultIndexer{index: bleve.NewIndexAlias(indexes...)}
+}
+
+// bucketOf returns the bucket of an indexed document
+func bucketOf(data interface{}) string {
+	switch d := data.(type) {
+	case interface{ BleveType() string }:
+		return d.BleveType()
+	case map[string]interface{}:
+		bucket, _ := d["bucket"].(string)
+		return bucket
+	}
+	return ""
+}
+
+func (r *RouterIndexer) Index() bleve.Index {
+	return r.searcher().Index()
+}
+
+func (r *RouterIndexer) BatchIndex() *Batch {
+	return r.def.BatchIndex()
+}
+
+// Batch splits the batch by route, deletes are applied to every index
+// since the bucket of a deleted document is not known
+func (r *RouterIndexer) Batch(b *Batch) error {
+	all := r.all()
+	if len(all) == 1 {
+		return r.def.Batch(b)
+	}
+	batches := make(map[Indexer]*Batch, len(all))
+	batch := func(index Indexer) *Batch {
+		if _, ok := batches[index]; !ok {
+			batches[index] = index.BatchIndex()
+		}
+		return batches[index]
+	}
+	r.mu.RLock()
+	for _, op := range b.ops {
+		if op.delete {
+			for _, index := range all {
+				batch(index).Delete(op.id)
+			}
+			continue
+		}
+		if err := batch(r.route(bucketOf(op.data))).Index(op.id, op.data); err != nil {
+			r.mu.RUnlock()
+			return err
+		}
+	}
+	r.mu.RUnlock()
+	for _, index := range all {
+		if ib, ok := batches[index]; ok {
+			if err := index.Batch(ib); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *RouterIndexer) AddDocumentMapping(name string, dm *mapping.DocumentMapping) {
+	r.Route(name).AddDocumentMapping(name, dm)
+}
+
+func (r *RouterIndexer) IndexDocument(id string, data interface{}) error {
+	return r.Route(bucketOf(data)).IndexDocument(id, data)
+}
+
+func (r *RouterIndexer) UnIndexDocument(id string) error {
+	var err error
+	for _, index := range r.all() {
+		if e := index.UnIndexDocument(id); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (r *RouterIndexer) QueryMap(q map[string]interface{}, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().QueryMap(q, opts...)
+}
+
+func (r *RouterIndexer) Query(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().Query(q, opts...)
+}
+
+func (r *RouterIndexer) QueryWithOptions(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().QueryWithOptions(q, size, from, explain, fields, opts...)
+}
+
+func (r *RouterIndexer) RangeQuery(q string, ranges []Range, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().RangeQuery(q, ranges, size, from, explain, fields, opts...)
+}
+
+func (r *RouterIndexer) FacetedQuery(q string, facets *Facets, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().FacetedQuery(q, facets, size, from, explain, fields, opts...)
+}
+
+func (r *RouterIndexer) QueryWithOptionsHighlighted(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().QueryWithOptionsHighlighted(q, size, from, explain, fields, opts...)
+}
+
+func (r *RouterIndexer) MatchQuery(q, field string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().MatchQuery(q, field, opts...)
+}
+
+func (r *RouterIndexer) TermQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().TermQuery(q, opts...)
+}
+
+func (r *RouterIndexer) MatchPhraseQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.searcher().MatchPhraseQuery(q, opts...)
+}
+
+// Suggest uses the index of the store since term dictionaries cannot be read through an alias
+func (r *RouterIndexer) Suggest(store, field, prefix string, n int) ([]Suggestion, error) {
+	return r.Route(store).Suggest(store, field, prefix, n)
+}
+
+// SimilarTo uses the index of the store since term dictionaries cannot be read through an alias
+func (r *RouterIndexer) SimilarTo(store, id string, doc map[string]interface{}, fields []string, n int, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return r.Route(store).SimilarTo(store, id, doc, fields, n, opts...)
+}
+
+func (r *RouterIndexer) Close() {
+	for _, index := range r.all() {
+		index.Close()
+	}
+}
+
+// ExcludeStores wraps a provider so its cursor skips rows of the stores
+func ExcludeStores(provider ProviderStore, stores ...string) ProviderStore {
+	if len(stores) == 0 {
+		return provider
+	}
+	exclude := make(map[string]struct{}, len(stores))
+	for _, store := range stores {
+		exclude[store] = struct{}{}
+	}
+	return &excludeProvider{provider, exclude}
+}
+
+type excludeProvider struct {
+	ProviderStore
+	exclude map[string]struct{}
+}
+
+func (p *excludeProvider) Cursor() (common.Iterator, error) {
+	iter, err := p.ProviderStore.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	e := &excludeIterator{Iterator: iter, exclude: p.exclude}
+	e.skip()
+	return e, nil
+}
+
+// excludeIterator skips rows of excluded stores
+type excludeIterator struct {
+	common.Iterator
+	exclude map[string]struct{}
+}
+
+func (e *excludeIterator) skip() {
+	for ; e.Iterator.Valid(); e.Iterator.Next() {
+		store, _, ok := parseRowKey(e.Iterator.Key())
+		if _, excluded := e.exclude[store]; !ok || !excluded {
+			return
+		}
+	}
+}
+
+func (e *excludeIterator) Seek(key []byte) {
+	e.Iterator.Seek(key)
+	e.skip()
+}
+
+func (e *excludeIterator) Next() {
+	e.Iterator.Next()
+	e.skip()
+}
+
+func (e *excludeIterator) Current() ([]byte, []byte, bool) {
+	if e.Iterator.Valid() {
+		return e.Iterator.Key(), e.Iterator.Value(), true
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,63 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterIndexer(t *testing.T) {
+	def, named := newMemTestIndexer(t), newMemTestIndexer(t)
+	router := NewRouterIndexer(def)
+	defer router.Close()
+	assert.Equal(t, ErrUnknownIndex, router.AddRoute("sessions", "keyword"))
+	router.AddIndex("keyword", named)
+	assert.Nil(t, router.AddRoute("sessions", "keyword"))
+
+	assert.Nil(t, router.IndexDocument("u1", IndexedData{"users", map[string]interface{}{"name": "ada"}}))
+	b := router.BatchIndex()
+	b.Index("s1", IndexedData{"sessions", map[string]interface{}{"name": "ada"}})
+	b.Index("s2", map[string]interface{}{"bucket": "sessions", "data": map[string]interface{}{"name": "bob"}})
+	b.Index("u2", IndexedData{"users", map[string]interface{}{"name": "bob"}})
+	assert.Nil(t, router.Batch(b))
+
+	count := func(ix Indexer, q string) uint64 {
+		res, err := ix.Query(q)
+		assert.Nil(t, err)
+		return res.Total
+	}
+	assert.Equal(t, uint64(2), count(def, "+bucket:users"))
+	assert.Equal(t, uint64(0), count(def, "+bucket:sessions"))
+	assert.Equal(t, uint64(2), count(named, "+bucket:sessions"))
+	// queries run against every index
+	assert.Equal(t, uint64(2), count(router, `+data.name:"ada"`))
+
+	d := router.BatchIndex()
+	d.Delete("s1")
+	d.Delete("u1")
+	assert.Nil(t, router.Batch(d))
+	assert.Nil(t, router.UnIndexDocument("s2"))
+	assert.Equal(t, uint64(1), count(router, "bucket:users bucket:sessions"))
+	assert.Equal(t, []string{"sessions"}, router.RoutedBuckets())
+
+	router.RemoveRoute("sessions")
+	assert.Equal(t, def, router.Route("sessions"))
+}
+
+func TestExcludeStores(t *testing.T) {
+	iter := &sliceIterator{rows: [][2]string{
+		{"t$articles|1", "{}"},
+		{"t$sessions|1", "{}"},
+		{"t$sessions|2", "{}"},
+		{"t$users|1", "{}"},
+	}}
+	cursor, err := ExcludeStores(&sliceProvider{iter}, "sessions").Cursor()
+	assert.Nil(t, err)
+	keys := []string{}
+	for ; cursor.Valid(); cursor.Next() {
+		keys = append(keys, string(cursor.Key()))
+	}
+	assert.Equal(t, []string{"t$articles|1", "t$users|1"}, keys)
+	cursor.Seek([]byte("t$sessions|"))
+	assert.Equal(t, "t$users|1", string(cursor.Key()))
+}
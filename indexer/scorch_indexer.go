@@ -0,0 +1,104 @@
+package indexer
+
+import (
+	"fmt"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// ScorchOptions configures the persister and merger of a scorch index,
+// zero values keep the bleve defaults
+type ScorchOptions struct {
+	// PersisterNapTimeMSec delays persisting so more segments can be merged in memory
+	PersisterNapTimeMSec int `json:"persisterNapTimeMSec,omitempty"`
+	// PersisterNapUnderNumFiles the persister only naps while there are fewer files than this
+	PersisterNapUnderNumFiles int `json:"persisterNapUnderNumFiles,omitempty"`
+	// MaxSegmentsPerTier number of segments in a tier before they are merged
+	MaxSegmentsPerTier int `json:"maxSegmentsPerTier,omitempty"`
+	// MaxSegmentSize largest segment the merger will produce
+	MaxSegmentSize int64 `json:"maxSegmentSize,omitempty"`
+	// SegmentsPerMergeTask number of segments merged at once
+	SegmentsPerMergeTask int `json:"segmentsPerMergeTask,omitempty"`
+	// FloorSegmentSize segments smaller than this are treated as this size when merging
+	FloorSegmentSize int64 `json:"floorSegmentSize,omitempty"`
+	// NumSnapshotsToKeep number of persisted snapshots kept for rollback
+	NumSnapshotsToKeep int `json:"numSnapshotsToKeep,omitempty"`
+	// UnsafeBatch skips waiting for batches to be persisted
+	UnsafeBatch bool `json:"unsafeBatch,omitempty"`
+}
+
+// config returns the scorch runtime config for the options
+func (o ScorchOptions) config() map[string]interface{} {
+	config := map[string]interface{}{}
+	persister := map[string]interface{}{}
+	if o.PersisterNapTimeMSec > 0 {
+		persister["PersisterNapTimeMSec"] = o.PersisterNapTimeMSec
+	}
+	if o.PersisterNapUnderNumFiles > 0 {
+		persister["PersisterNapUnderNumFiles"] = o.PersisterNapUnderNumFiles
+	}
+	if len(persister) > 0 {
+		config["scorchPersisterOptions"] = persister
+	}
+	merger := map[string]interface{}{}
+	if o.MaxSegmentsPerTier > 0 {
+		merger["MaxSegmentsPerTier"] = o.MaxSegmentsPerTier
+	}
+	if o.MaxSegmentSize > 0 {
+		merger["MaxSegmentSize"] = o.MaxSegmentSize
+	}
+	if o.SegmentsPerMergeTask > 0 {
+		merger["SegmentsPerMergeTask"] = o.SegmentsPerMergeTask
+	}
+	if o.FloorSegmentSize > 0 {
+		merger["FloorSegmentSize"] = o.FloorSegmentSize
+	}
+	if len(merger) > 0 {
+		config["scorchMergePlanOptions"] = merger
+	}
+	if o.NumSnapshotsToKeep > 0 {
+		config["numSnapshotsToKeep"] = o.NumSnapshotsToKeep
+	}
+	if o.UnsafeBatch {
+		config["unsafe_batch"] = true
+	}
+	return config
+}
+
+// NewScorchIndexer creates a new scorch indexer
+func NewScorchIndexer(indexPath string) (Indexer, bool) {
+	indexMapping := bleve.NewIndexMapping()
+	return NewScorchIndexerWithMapping(indexPath, indexMapping)
+}
+
+// NewScorchIndexerWithMapping creates a new scorch indexer
+func NewScorchIndexerWithMapping(indexPath string, indexMapping mapping.IndexMapping) (Indexer, bool) {
+	return NewScorchIndexerWithOptions(indexPath, indexMapping, ScorchOptions{})
+}
+
+// NewScorchIndexerWithOptions creates or opens a scorch indexer which stores
+// zap segments on disk. The options also apply when an existing index is opened
+func NewScorchIndexerWithOptions(indexPath string, indexMapping mapping.IndexMapping, opts ScorchOptions) (Indexer, bool) {
+	config := opts.config()
+	index, err := bleve.OpenUsing(indexPath, config)
+	if err != nil {
+		logger.Debug("Error opening scorch indexpath", "path", indexPath, "verbose", string(err.Error()))
+		if err == bleve.ErrorIndexMetaMissing || err == bleve.ErrorIndexPathDoesNotExist {
+			logger.Debug(fmt.Sprintf("Creating new scorch index at %s ...", indexPath))
+			index, err = bleve.NewUsing(indexPath, indexMapping, scorch.Name, bleve.Config.DefaultKVStore, config)
+			if err != nil {
+				logger.Warn("scorch Index could not be created", "path", indexPath, "err", string(err.Error()))
+				if err != bleve.ErrorIndexPathExists {
+					panic(err)
+				}
+				return nil, false
+			}
+			return &DefaultIndexer{index: index}, true
+		}
+		panic(err)
+	}
+	logger.Debug("opening existing scorch index", "path", indexPath)
+	return &DefaultIndexer{index: index}, false
+}
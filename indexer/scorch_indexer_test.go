@@ -0,0 +1,44 @@
+package indexer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/index/scorch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScorchIndexer(t *testing.T) {
+	indexPath := filepath.Join(t.TempDir(), "scorch.index")
+	opts := ScorchOptions{PersisterNapTimeMSec: 10, MaxSegmentsPerTier: 5, NumSnapshotsToKeep: 2}
+
+	ix, created := NewScorchIndexerWithOptions(indexPath, bleve.NewIndexMapping(), opts)
+	assert.True(t, created)
+	if !assert.NotNil(t, ix) {
+		return
+	}
+	assert.Nil(t, ix.IndexDocument("a", IndexedData{"fruit", map[string]interface{}{"name": "apple"}}))
+	assert.Nil(t, ix.IndexDocument("b", IndexedData{"fruit", map[string]interface{}{"name": "banana"}}))
+	ix.Close()
+
+	meta, err := os.ReadFile(filepath.Join(indexPath, "index_meta.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(meta), `"index_type":"`+scorch.Name+`"`)
+
+	ix, created = NewScorchIndexerWithOptions(indexPath, bleve.NewIndexMapping(), opts)
+	assert.False(t, created)
+	defer ix.Close()
+	res, err := ix.Query("+data.name:banana")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+}
+
+func TestScorchOptionsConfig(t *testing.T) {
+	assert.Empty(t, ScorchOptions{}.config())
+	config := ScorchOptions{PersisterNapTimeMSec: 10, MaxSegmentSize: 1 << 20, UnsafeBatch: true}.config()
+	assert.Equal(t, map[string]interface{}{"PersisterNapTimeMSec": 10}, config["scorchPersisterOptions"])
+	assert.Equal(t, map[string]interface{}{"MaxSegmentSize": int64(1 << 20)}, config["scorchMergePlanOptions"])
+	assert.Equal(t, true, config["unsafe_batch"])
+}
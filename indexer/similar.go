@@ -0,0 +1,151 @@
+package indexer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/search/query"
+)
+
+// SimilarMaxTerms limits the significant terms used to find similar documents
+var SimilarMaxTerms = 25
+
+// SimilarTerm a significant term of a document and its tf-idf weight
+type SimilarTerm struct {
+	Field  string  `json:"field"`
+	Term   string  `json:"term"`
+	Weight float64 `json:"weight"`
+}
+
+// fieldText returns the text of a dotted field in a document, lists of
+// values are joined
+func fieldText(doc map[string]interface{}, field string) string {
+	var v interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[part]
+	}
+	switch vv := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return vv
+	case []interface{}:
+		values := make([]string, 0, len(vv))
+		for _, item := range vv {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return strings.Join(values, " ")
+	}
+	return ""
+}
+
+// SignificantTerms returns the most significant terms of the fields of doc.
+// Terms are analyzed with the analyzer of each field and weighted by their
+// frequency in doc and their document frequency in the term dictionary. The
+// dictionary counts terms across every store, terms which only appear in doc
+// are left out since they cannot match another document
+func (i *DefaultIndexer) SignificantTerms(doc map[string]interface{}, fields []string) ([]SimilarTerm, error) {
+	if i.index == nil {
+		return nil, errors.New("no index")
+	}
+	m := i.index.Mapping()
+	total, err := i.index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	terms := []SimilarTerm{}
+	for _, field := range fields {
+		text := fieldText(doc, field)
+		if text == "" {
+			continue
+		}
+		path := "data." + field
+		analyzer := m.AnalyzerNamed(m.AnalyzerNameForPath(path))
+		if analyzer == nil {
+			return nil, fmt.Errorf("no analyzer for field %s", field)
+		}
+		tf := map[string]int{}
+		for _, token := range analyzer.Analyze([]byte(text)) {
+			tf[string(token.Term)]++
+		}
+		for term, freq := range tf {
+			df, err := i.docFrequency(path, term)
+			if err != nil {
+				return nil, err
+			}
+			if df < 2 {
+				continue
+			}
+			idf := 1 + math.Log(float64(total)/float64(df+1))
+			terms = append(terms, SimilarTerm{Field: field, Term: term, Weight: float64(freq) * idf})
+		}
+	}
+	sort.Slice(terms, func(a, b int) bool {
+		if terms[a].Weight == terms[b].Weight {
+			return terms[a].Field+terms[a].Term < terms[b].Field+terms[b].Term
+		}
+		return terms[a].Weight > terms[b].Weight
+	})
+	if len(terms) > SimilarMaxTerms {
+		terms = terms[:SimilarMaxTerms]
+	}
+	return terms, nil
+}
+
+// docFrequency returns the number of documents containing term in a field
+func (i *DefaultIndexer) docFrequency(field, term string) (uint64, error) {
+	dict, err := i.index.FieldDictRange(field, []byte(term), []byte(term))
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	entry, err := dict.Next()
+	if err == nil && entry != nil && entry.Term == term {
+		count = entry.Count
+	}
+	if cerr := dict.Close(); err == nil {
+		err = cerr
+	}
+	return count, err
+}
+
+// SimilarTo returns up to n documents in store similar to doc, the
+// document with id is excluded from the results
+func (i *DefaultIndexer) SimilarTo(store, id string, doc map[string]interface{}, fields []string, n int, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	terms, err := i.SignificantTerms(doc, fields)
+	if err != nil {
+		return nil, err
+	}
+	var q query.Query = bleve.NewMatchNoneQuery()
+	if len(terms) > 0 {
+		similar := bleve.NewDisjunctionQuery()
+		for _, t := range terms {
+			term := bleve.NewTermQuery(t.Term)
+			term.SetField("data." + t.Field)
+			term.SetBoost(t.Weight)
+			similar.AddQuery(term)
+		}
+		boolean := bleve.NewBooleanQuery()
+		boolean.AddMust(bleve.NewQueryStringQuery(GetQueryString(store, nil)), similar)
+		boolean.AddMustNot(bleve.NewDocIDQuery([]string{id}))
+		q = boolean
+	}
+	logger.Debug("similar to", "store", store, "id", id, "terms", fmt.Sprintf("%v", terms))
+	searchRequest := bleve.NewSearchRequestOptions(q, n, 0, false)
+	for _, opt := range opts {
+		if err := opt(searchRequest); err != nil {
+			logger.Warn("failed option passed")
+		}
+	}
+	return i.index.Search(searchRequest)
+}
@@ -0,0 +1,42 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimilarTo(t *testing.T) {
+	ix := newMemTestIndexer(t)
+	defer ix.Close()
+	docs := map[string]IndexedData{
+		"1": {"articles", map[string]interface{}{"title": "golang search engine", "tags": []interface{}{"go", "search"}}},
+		"2": {"articles", map[string]interface{}{"title": "building a search engine in golang"}},
+		"3": {"articles", map[string]interface{}{"title": "search engine tuning"}},
+		"4": {"articles", map[string]interface{}{"title": "baking bread"}},
+		"5": {"recipes", map[string]interface{}{"title": "golang search engine recipes"}},
+	}
+	for id, doc := range docs {
+		assert.Nil(t, ix.IndexDocument(id, doc))
+	}
+
+	terms, err := ix.(*DefaultIndexer).SignificantTerms(docs["1"].Data.(map[string]interface{}), []string{"title", "tags"})
+	assert.Nil(t, err)
+	words := []string{}
+	for _, term := range terms {
+		words = append(words, term.Term)
+	}
+	// golang is rarer than search and engine, tags only appear in the source document
+	assert.Equal(t, []string{"golang", "engine", "search"}, words)
+
+	res, err := ix.SimilarTo("articles", "1", docs["1"].Data.(map[string]interface{}), []string{"title"}, 10)
+	assert.Nil(t, err)
+	if assert.Equal(t, 2, res.Hits.Len()) {
+		assert.Equal(t, "2", res.Hits[0].ID)
+		assert.Equal(t, "3", res.Hits[1].ID)
+	}
+
+	res, err = ix.SimilarTo("articles", "4", docs["4"].Data.(map[string]interface{}), []string{"title"}, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+}
@@ -0,0 +1,181 @@
+package indexer
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/token/edgengram"
+	"github.com/blevesearch/bleve/v2/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+const (
+	// SuggestAnalyzer analyzes whole values into lowercased edge ngrams
+	SuggestAnalyzer = "suggest"
+	// SuggestFieldSuffix suffix of the edge ngram shadow field
+	SuggestFieldSuffix = "_suggest"
+	// ExactFieldSuffix suffix of the keyword shadow field used to count completions
+	ExactFieldSuffix = "_exact"
+	// DefaultSuggestions is the number of completions returned when n is not positive
+	DefaultSuggestions = 10
+
+	suggestEdgeNgram = "suggest_edge_ngram"
+)
+
+// Suggestion a completion and the number of documents which contain it
+type Suggestion struct {
+	Term  string `json:"term"`
+	Count int    `json:"count"`
+}
+
+// AddSuggestAnalyzer registers the edge ngram analyzer used by suggest fields
+func AddSuggestAnalyzer(im *mapping.IndexMappingImpl) error {
+	err := im.AddCustomTokenFilter(suggestEdgeNgram, map[string]interface{}{
+		"type": edgengram.Name,
+		"min":  1.0,
+		"max":  25.0,
+	})
+	if err != nil {
+		return err
+	}
+	return im.AddCustomAnalyzer(SuggestAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name, suggestEdgeNgram},
+	})
+}
+
+// AddSuggestField declares edge ngram and keyword shadow fields for a field of
+// a data document mapping. The field keeps a text mapping if it had none
+func AddSuggestField(dm *mapping.DocumentMapping, field string) {
+	if pm, ok := dm.Properties[field]; !ok || len(pm.Fields) == 0 {
+		dm.AddFieldMappingsAt(field, bleve.NewTextFieldMapping())
+	}
+	suggest := bleve.NewTextFieldMapping()
+	suggest.Name = field + SuggestFieldSuffix
+	suggest.Analyzer = SuggestAnalyzer
+	suggest.IncludeInAll = false
+	suggest.IncludeTermVectors = false
+	exact := bleve.NewTextFieldMapping()
+	exact.Name = field + ExactFieldSuffix
+	exact.Analyzer = keyword.Name
+	exact.IncludeInAll = false
+	exact.IncludeTermVectors = false
+	dm.AddFieldMappingsAt(field, suggest, exact)
+}
+
+// dataFieldMappings finds the field mappings declared for a data field
+func dataFieldMappings(m mapping.IndexMapping, field string) []*mapping.FieldMapping {
+	im, ok := m.(*mapping.IndexMappingImpl)
+	if !ok {
+		return nil
+	}
+	docMappings := []*mapping.DocumentMapping{im.DefaultMapping}
+	for _, dm := range im.TypeMapping {
+		docMappings = append(docMappings, dm)
+	}
+	for _, dm := range docMappings {
+		if dm == nil {
+			continue
+		}
+		data, ok := dm.Properties["data"]
+		if !ok {
+			continue
+		}
+		if pm, ok := data.Properties[field]; ok && len(pm.Fields) > 0 {
+			return pm.Fields
+		}
+	}
+	return nil
+}
+
+// Suggest returns the n most common completions of prefix for a field in a store,
+// DefaultSuggestions when n is not positive. Fields declared with
+// AddSuggestField match whole values, other fields complete their terms
+func (i *DefaultIndexer) Suggest(store, field, prefix string, n int) ([]Suggestion, error) {
+	if i.index == nil {
+		return nil, errors.New("no index")
+	}
+	if n <= 0 {
+		n = DefaultSuggestions
+	}
+	fields := dataFieldMappings(i.index.Mapping(), field)
+	hasSuggest, isKeyword := false, false
+	for _, fm := range fields {
+		if fm.Name == field+SuggestFieldSuffix && fm.Analyzer == SuggestAnalyzer {
+			hasSuggest = true
+		}
+		if (fm.Name == "" || fm.Name == field) && fm.Analyzer == keyword.Name {
+			isKeyword = true
+		}
+	}
+	if hasSuggest {
+		return i.suggestFromShadow(store, field, prefix, n)
+	}
+	if !isKeyword {
+		prefix = strings.ToLower(prefix)
+	}
+	return i.suggestFromTerms(store, field, prefix, n)
+}
+
+func (i *DefaultIndexer) suggestFromShadow(store, field, prefix string, n int) ([]Suggestion, error) {
+	term := bleve.NewTermQuery(strings.ToLower(prefix))
+	term.SetField("data." + field + SuggestFieldSuffix)
+	query := bleve.NewConjunctionQuery(bleve.NewQueryStringQuery(GetQueryString(store, nil)), term)
+	searchRequest := bleve.NewSearchRequestOptions(query, 0, 0, false)
+	searchRequest.AddFacet("suggest", bleve.NewFacetRequest("data."+field+ExactFieldSuffix, n))
+	res, err := i.index.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := []Suggestion{}
+	if facet, ok := res.Facets["suggest"]; ok && facet.Terms != nil {
+		for _, t := range facet.Terms.Terms() {
+			suggestions = append(suggestions, Suggestion{Term: t.Term, Count: t.Count})
+		}
+	}
+	return suggestions, nil
+}
+
+// suggestFromTerms counts the terms of a field starting with prefix in the
+// documents of a store. A matching document can hold other terms of the field
+// so every term is faceted and terms without the prefix are dropped
+func (i *DefaultIndexer) suggestFromTerms(store, field, prefix string, n int) ([]Suggestion, error) {
+	terms := bleve.NewPrefixQuery(prefix)
+	terms.SetField("data." + field)
+	query := bleve.NewConjunctionQuery(bleve.NewQueryStringQuery(GetQueryString(store, nil)), terms)
+	searchRequest := bleve.NewSearchRequestOptions(query, 0, 0, false)
+	searchRequest.AddFacet("suggest", bleve.NewFacetRequest("data."+field, math.MaxInt32))
+	res, err := i.index.Search(searchRequest)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := []Suggestion{}
+	if facet, ok := res.Facets["suggest"]; ok && facet.Terms != nil {
+		for _, t := range facet.Terms.Terms() {
+			if strings.HasPrefix(t.Term, prefix) {
+				suggestions = append(suggestions, Suggestion{Term: t.Term, Count: t.Count})
+			}
+		}
+	}
+	sortSuggestions(suggestions)
+	if len(suggestions) > n {
+		suggestions = suggestions[:n]
+	}
+	return suggestions, nil
+}
+
+func sortSuggestions(suggestions []Suggestion) {
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Count == suggestions[j].Count {
+			return suggestions[i].Term < suggestions[j].Term
+		}
+		return suggestions[i].Count > suggestions[j].Count
+	})
+}
@@ -0,0 +1,283 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/mapping"
+)
+
+// DefaultRebuildBatchSize number of documents indexed per batch during a rebuild
+const DefaultRebuildBatchSize = 1000
+
+// ErrRebuildRunning returned when a rebuild is started while another is running
+var ErrRebuildRunning = errors.New("rebuild already running")
+
+// RebuildProgress progress of an online rebuild
+type RebuildProgress struct {
+	Running  bool      `json:"running"`
+	Indexed  uint64    `json:"indexed"`
+	Skipped  uint64    `json:"skipped"`
+	Failed   uint64    `json:"failed"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Err      error     `json:"-"`
+}
+
+// SwapIndexer serves an active indexer which can be rebuilt online.
+// While a rebuild runs writes go to both the active and the shadow index,
+// once the shadow is complete it atomically replaces the active index
+type SwapIndexer struct {
+	mu       sync.RWMutex
+	active   Indexer
+	shadow   Indexer
+	touchMu  sync.Mutex
+	touched  map[string]struct{}
+	progress RebuildProgress
+	indexed  uint64
+	skipped  uint64
+	failed   uint64
+}
+
+// NewSwapIndexer wraps an active indexer
+func NewSwapIndexer(active Indexer) *SwapIndexer {
+	return &SwapIndexer{active: active}
+}
+
+// Active returns the indexer currently serving requests
+func (s *SwapIndexer) Active() Indexer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active
+}
+
+// Progress returns the progress of the current or last rebuild
+func (s *SwapIndexer) Progress() RebuildProgress {
+	s.mu.RLock()
+	p := s.progress
+	s.mu.RUnlock()
+	p.Indexed = atomic.LoadUint64(&s.indexed)
+	p.Skipped = atomic.LoadUint64(&s.skipped)
+	p.Failed = atomic.LoadUint64(&s.failed)
+	return p
+}
+
+// Rebuild indexes every row from provider into shadow while live writes are
+// dual written, then swaps shadow in as the active index. The replaced index
+// is returned so it can be closed and removed. On error or cancellation the
+// shadow is discarded and the active index is left in place
+func (s *SwapIndexer) Rebuild(ctx context.Context, provider ProviderStore, shadow Indexer, batchSize int) (Indexer, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultRebuildBatchSize
+	}
+	s.mu.Lock()
+	if s.shadow != nil {
+		s.mu.Unlock()
+		return nil, ErrRebuildRunning
+	}
+	s.shadow = shadow
+	s.touched = make(map[string]struct{})
+	s.progress = RebuildProgress{Running: true, Started: time.Now()}
+	atomic.StoreUint64(&s.indexed, 0)
+	atomic.StoreUint64(&s.skipped, 0)
+	atomic.StoreUint64(&s.failed, 0)
+	s.mu.Unlock()
+
+	err := s.fill(ctx, provider, shadow, batchSize)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shadow = nil
+	s.touched = nil
+	s.progress.Running = false
+	s.progress.Finished = time.Now()
+	s.progress.Err = err
+	if err != nil {
+		return nil, err
+	}
+	old := s.active
+	s.active = shadow
+	return old, nil
+}
+
+// fill copies rows into the shadow index in batches, rows already written
+// by a live write are skipped since the scan may hold an older value
+func (s *SwapIndexer) fill(ctx context.Context, provider ProviderStore, shadow Indexer, batchSize int) error {
+	iter, err := provider.Cursor()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	ids := make([]string, 0, batchSize)
+	docs := make([]interface{}, 0, batchSize)
+	flush := func() error {
+		s.touchMu.Lock()
+		defer s.touchMu.Unlock()
+		b := shadow.BatchIndex()
+		for i, id := range ids {
+			if _, ok := s.touched[id]; ok {
+				atomic.AddUint64(&s.skipped, 1)
+				continue
+			}
+			if err := b.Index(id, docs[i]); err != nil {
+				logger.Warn("rebuild failed to index document", "id", id, "err", err)
+				atomic.AddUint64(&s.failed, 1)
+				continue
+			}
+			atomic.AddUint64(&s.indexed, 1)
+		}
+		ids, docs = ids[:0], docs[:0]
+		return shadow.Batch(b)
+	}
+	for ; iter.Valid(); iter.Next() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		store, id, ok := parseRowKey(iter.Key())
+		if !ok {
+			continue
+		}
+		doc, err := reIndexData(shadow, store, iter.Value())
+		if err != nil {
+			logger.Warn("rebuild failed to read document", "id", id, "err", err)
+			atomic.AddUint64(&s.failed, 1)
+			continue
+		}
+		ids = append(ids, id)
+		docs = append(docs, doc)
+		if len(ids) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(ids) > 0 {
+		return flush()
+	}
+	return nil
+}
+
+// toShadow applies a write to the shadow index, marking the ids so the
+// rebuild scan does not overwrite them
+func (s *SwapIndexer) toShadow(ids []string, write func(Indexer) error) {
+	if s.shadow == nil {
+		return
+	}
+	s.touchMu.Lock()
+	defer s.touchMu.Unlock()
+	for _, id := range ids {
+		s.touched[id] = struct{}{}
+	}
+	if err := write(s.shadow); err != nil {
+		logger.Warn("failed to write to shadow index", "err", err)
+		atomic.AddUint64(&s.failed, 1)
+	}
+}
+
+func (s *SwapIndexer) Index() bleve.Index {
+	return s.Active().Index()
+}
+
+func (s *SwapIndexer) BatchIndex() *Batch {
+	return s.Active().BatchIndex()
+}
+
+func (s *SwapIndexer) Batch(b *Batch) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.active.Batch(b); err != nil {
+		return err
+	}
+	s.toShadow(b.IDs(), func(shadow Indexer) error {
+		sb := shadow.BatchIndex()
+		if err := b.Replay(sb); err != nil {
+			return err
+		}
+		return shadow.Batch(sb)
+	})
+	return nil
+}
+
+func (s *SwapIndexer) AddDocumentMapping(name string, dm *mapping.DocumentMapping) {
+	s.Active().AddDocumentMapping(name, dm)
+}
+
+func (s *SwapIndexer) IndexDocument(id string, data interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.active.IndexDocument(id, data); err != nil {
+		return err
+	}
+	s.toShadow([]string{id}, func(shadow Indexer) error {
+		return shadow.IndexDocument(id, data)
+	})
+	return nil
+}
+
+func (s *SwapIndexer) UnIndexDocument(id string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if err := s.active.UnIndexDocument(id); err != nil {
+		return err
+	}
+	s.toShadow([]string{id}, func(shadow Indexer) error {
+		return shadow.UnIndexDocument(id)
+	})
+	return nil
+}
+
+func (s *SwapIndexer) QueryMap(q map[string]interface{}, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().QueryMap(q, opts...)
+}
+
+func (s *SwapIndexer) Query(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().Query(q, opts...)
+}
+
+func (s *SwapIndexer) QueryWithOptions(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().QueryWithOptions(q, size, from, explain, fields, opts...)
+}
+
+func (s *SwapIndexer) RangeQuery(q string, ranges []Range, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().RangeQuery(q, ranges, size, from, explain, fields, opts...)
+}
+
+func (s *SwapIndexer) FacetedQuery(q string, facets *Facets, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().FacetedQuery(q, facets, size, from, explain, fields, opts...)
+}
+
+func (s *SwapIndexer) QueryWithOptionsHighlighted(q string, size, from int, explain bool, fields []string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().QueryWithOptionsHighlighted(q, size, from, explain, fields, opts...)
+}
+
+func (s *SwapIndexer) MatchQuery(q, field string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().MatchQuery(q, field, opts...)
+}
+
+func (s *SwapIndexer) TermQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().TermQuery(q, opts...)
+}
+
+func (s *SwapIndexer) MatchPhraseQuery(q string, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().MatchPhraseQuery(q, opts...)
+}
+
+func (s *SwapIndexer) Suggest(store, field, prefix string, n int) ([]Suggestion, error) {
+	return s.Active().Suggest(store, field, prefix, n)
+}
+
+func (s *SwapIndexer) SimilarTo(store, id string, doc map[string]interface{}, fields []string, n int, opts ...RequestOpt) (*bleve.SearchResult, error) {
+	return s.Active().SimilarTo(store, id, doc, fields, n, opts...)
+}
+
+func (s *SwapIndexer) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active.Close()
+}
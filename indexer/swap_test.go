@@ -0,0 +1,99 @@
+package indexer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/osiloke/gostore-contrib/common"
+	"github.com/stretchr/testify/assert"
+)
+
+type sliceIterator struct {
+	rows   [][2]string
+	pos    int
+	onNext func(pos int)
+}
+
+func (it *sliceIterator) Seek(key []byte) {
+	it.pos = 0
+	for it.Valid() && string(it.Key()) < string(key) {
+		it.pos++
+	}
+}
+func (it *sliceIterator) Next() {
+	it.pos++
+	if it.onNext != nil {
+		it.onNext(it.pos)
+	}
+}
+func (it *sliceIterator) Current() ([]byte, []byte, bool) {
+	return it.Key(), it.Value(), it.Valid()
+}
+func (it *sliceIterator) Key() []byte   { return []byte(it.rows[it.pos][0]) }
+func (it *sliceIterator) Value() []byte { return []byte(it.rows[it.pos][1]) }
+func (it *sliceIterator) Valid() bool   { return it.pos < len(it.rows) }
+func (it *sliceIterator) Close() error  { return nil }
+
+type sliceProvider struct {
+	iter *sliceIterator
+}
+
+func (p *sliceProvider) Cursor() (common.Iterator, error) {
+	return p.iter, nil
+}
+
+func newMemTestIndexer(t *testing.T) Indexer {
+	ix, failed := NewMemIndexer("")
+	assert.False(t, failed)
+	return ix
+}
+
+func TestSwapIndexerRebuild(t *testing.T) {
+	swap := NewSwapIndexer(newMemTestIndexer(t))
+	defer swap.Close()
+	shadow := newMemTestIndexer(t)
+	iter := &sliceIterator{rows: [][2]string{
+		{"t$fruit|a", `{"name":"apple"}`},
+		{"t$fruit|b", `{"name":"banana"}`},
+		{"t$fruit|c", `{"name":"cherry"}`},
+		{"invalid", `{}`},
+	}}
+	// a live write during the scan wins over the scanned row
+	iter.onNext = func(pos int) {
+		if pos == 1 {
+			assert.Nil(t, swap.IndexDocument("c", IndexedData{"fruit", map[string]interface{}{"name": "coconut"}}))
+		}
+	}
+	old, err := swap.Rebuild(context.Background(), &sliceProvider{iter}, shadow, 2)
+	assert.Nil(t, err)
+	assert.NotNil(t, old)
+	assert.Equal(t, shadow, swap.Active())
+
+	progress := swap.Progress()
+	assert.False(t, progress.Running)
+	assert.Equal(t, uint64(2), progress.Indexed)
+	assert.Equal(t, uint64(1), progress.Skipped)
+
+	count, err := swap.Index().DocCount()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), count)
+	res, err := swap.Query("+data.name:coconut")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), res.Total)
+	res, err = swap.Query("+data.name:cherry")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), res.Total)
+}
+
+func TestSwapIndexerRebuildCancelled(t *testing.T) {
+	active := newMemTestIndexer(t)
+	swap := NewSwapIndexer(active)
+	defer swap.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	iter := &sliceIterator{rows: [][2]string{{"t$fruit|a", `{"name":"apple"}`}}}
+	_, err := swap.Rebuild(ctx, &sliceProvider{iter}, newMemTestIndexer(t), 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, active, swap.Active())
+	assert.ErrorIs(t, swap.Progress().Err, context.Canceled)
+}
@@ -0,0 +1,89 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve/v2/analysis"
+	"github.com/blevesearch/bleve/v2/registry"
+)
+
+// SynonymFilterName is the name used to register SynonymFilter in the bleve registry
+const SynonymFilterName = "synonym"
+
+// SynonymFilter adds every equivalent of a token at the same position.
+// Synonyms are single lowercased terms
+type SynonymFilter struct {
+	synonyms map[string][]string
+}
+
+// NewSynonymFilter creates a filter from groups of equivalent terms
+func NewSynonymFilter(groups [][]string) *SynonymFilter {
+	synonyms := make(map[string][]string)
+	for _, group := range groups {
+		for _, term := range group {
+			term = strings.ToLower(term)
+			for _, other := range group {
+				other = strings.ToLower(other)
+				if other != term {
+					synonyms[term] = append(synonyms[term], other)
+				}
+			}
+		}
+	}
+	return &SynonymFilter{synonyms: synonyms}
+}
+
+// Filter expands tokens with their synonyms
+func (f *SynonymFilter) Filter(input analysis.TokenStream) analysis.TokenStream {
+	rv := make(analysis.TokenStream, 0, len(input))
+	for _, token := range input {
+		rv = append(rv, token)
+		for _, synonym := range f.synonyms[string(token.Term)] {
+			rv = append(rv, &analysis.Token{
+				Start:    token.Start,
+				End:      token.End,
+				Term:     []byte(synonym),
+				Position: token.Position,
+				Type:     token.Type,
+				KeyWord:  token.KeyWord,
+			})
+		}
+	}
+	return rv
+}
+
+// SynonymFilterConstructor builds a synonym filter from a "synonyms" list of term groups
+func SynonymFilterConstructor(config map[string]interface{}, cache *registry.Cache) (analysis.TokenFilter, error) {
+	raw, ok := config["synonyms"].([]interface{})
+	if !ok {
+		if groups, ok := config["synonyms"].([][]string); ok {
+			return NewSynonymFilter(groups), nil
+		}
+		return nil, fmt.Errorf("must specify synonyms")
+	}
+	groups := make([][]string, 0, len(raw))
+	for _, g := range raw {
+		var group []string
+		switch terms := g.(type) {
+		case []string:
+			group = terms
+		case []interface{}:
+			for _, t := range terms {
+				term, ok := t.(string)
+				if !ok {
+					return nil, fmt.Errorf("synonyms must be strings")
+				}
+				group = append(group, term)
+			}
+		default:
+			return nil, fmt.Errorf("synonyms must be lists of terms")
+		}
+		groups = append(groups, group)
+	}
+	return NewSynonymFilter(groups), nil
+}
+
+func init() {
+	registry.RegisterTokenFilter(SynonymFilterName, SynonymFilterConstructor)
+}